@@ -46,14 +46,18 @@ func (p *Plugin) activityTemplate(activity Activity) string {
 	if len(name) < 1 {
 		name = activity.Relationships.Actor.Data.Attributes.Username
 	}
-	actorLink := "[" + name + "](https://hackerone.com/" + activity.Relationships.Actor.Data.Attributes.Username + ")"
+	actorLink := "[" + sanitizeInline(name) + "](" + hackeroneURL("", activity.Relationships.Actor.Data.Attributes.Username) + ")"
 	activitiesListString += fmt.Sprintf(
 		"%s %s\n",
 		actorLink,
-		getActivityType(activity.ActivityType),
+		sanitizeInline(getActivityType(activity.ActivityType)),
 	)
 	if len(activity.Attributes.Message) > 1 {
-		activitiesListString += "\n```\n" + activity.Attributes.Message + "\n```\n"
+		message, redacted := p.redact(activity.Attributes.Message)
+		activitiesListString += codeBlock(message)
+		if redacted > 0 {
+			activitiesListString += "_Potential secrets were removed from this message: " + redactionNote(redacted) + "._\n"
+		}
 	}
 	return activitiesListString
 }
@@ -87,22 +91,45 @@ func (p *Plugin) notifyNewActivity() error {
 
 	for _, activity := range activities.Activities {
 		activitiesListString := p.activityTemplate(activity)
-		postAttachments := []*model.SlackAttachment{}
+		summaryAttachments := []*model.SlackAttachment{}
+		detailedAttachments := []*model.SlackAttachment{}
+		// The current report is needed to evaluate the query subscriptions, nil when unavailable
+		var currentReport *Report
 		report, err := p.fetchReport(activity.Attributes.ReportID)
 		if err != nil {
 			p.API.LogWarn("Something went wrong while getting the report from Hackerone API", "error", err.Error())
 		} else {
-			var attachment = &model.SlackAttachment{}
+			currentReport = &report
+			summaryAttachments = append(summaryAttachments, p.getReportAttachment(report, false))
 			if activity.ActivityType == "activity-bug-filed" {
-				attachment = p.getReportAttachment(report, true)
+				detailedAttachments = append(detailedAttachments, p.getReportAttachment(report, true))
 			} else {
-				attachment = p.getReportAttachment(report, false)
+				detailedAttachments = summaryAttachments
 			}
-			postAttachments = append(postAttachments, attachment)
 		}
+		if err = p.recordFirstResponse(activity, currentReport); err != nil {
+			p.API.LogWarn("Unable to record the first response to a report", "report_id", activity.Attributes.ReportID, "error", err.Error())
+		}
+		if currentReport != nil {
+			if _, err = p.recordStageMetrics([]Report{report}); err != nil {
+				p.API.LogWarn("Unable to record the stage metrics of a report", "report_id", report.Id, "error", err.Error())
+			}
+		}
+		now := model.GetMillis()
 		for _, v := range subs {
-			if (len(v.ReportID) == 0) || (v.ReportID == activity.Attributes.ReportID) {
-				p.sendPostByChannelId(v.ChannelID, activitiesListString, postAttachments)
+			if v.IsPaused(now) {
+				continue
+			}
+			if ok, note := p.matchActivity(v, activity.Attributes.ReportID, currentReport); ok {
+				postAttachments := summaryAttachments
+				if !v.SummaryOnly && p.canShowDetails(v.ChannelID) {
+					postAttachments = detailedAttachments
+				}
+				message := activitiesListString
+				if note != "" {
+					message += "\n" + note
+				}
+				p.sendPostByChannelId(v.ChannelID, message, postAttachments)
 			}
 		}
 	}
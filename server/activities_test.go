@@ -57,3 +57,22 @@ func Test_StoreActivityLastKey(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func Test_activityTemplate(t *testing.T) {
+	p := &Plugin{}
+	t.Run("Regular comment", func(t *testing.T) {
+		activity := Activity{ActivityType: "activity-comment"}
+		activity.Attributes.Message = "Thanks, we are looking into it."
+		activity.Relationships.Actor.Data.Attributes.Name = "Alice"
+		activity.Relationships.Actor.Data.Attributes.Username = "alice"
+		assert.Equal(t, "[Alice](https://hackerone.com/alice) commented on the report\n\n```\nThanks, we are looking into it.\n```\n", p.activityTemplate(activity))
+	})
+	t.Run("Adversarial comment and actor", func(t *testing.T) {
+		activity := Activity{ActivityType: "activity-comment"}
+		activity.Attributes.Message = "ok\n```\n@all [click here](https://evil.example.com)\n```"
+		activity.Relationships.Actor.Data.Attributes.Name = "@channel](https://evil.example.com)"
+		activity.Relationships.Actor.Data.Attributes.Username = "eve)"
+		got := p.activityTemplate(activity)
+		assert.Equal(t, "[@\u200bchannel\\]\\(https://evil.example.com\\)](https://hackerone.com/eve%29) commented on the report\n\n````\nok\n```\n@all [click here](https://evil.example.com)\n```\n````\n", got)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// handleReportDetails responds to the "Show full details" button by sending the complete
+// report details as an ephemeral post to the user who clicked it.
+func (p *Plugin) handleReportDetails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	reportID, _ := request.Context[contextReport].(string)
+	if reportID == "" {
+		http.Error(w, "Missing report id", http.StatusBadRequest)
+		return
+	}
+
+	isAllowed, err := p.IsAuthorized(userID)
+	if err != nil {
+		p.API.LogError("Error occurred while authorizing the report details request", "err", err.Error())
+		writePostActionResponse(w, "Something went wrong while checking your permissions. Please check the server logs.")
+		return
+	}
+	if !isAllowed {
+		writePostActionResponse(w, "Report details can only be viewed by a system administrator or a list of whitelisted users.")
+		return
+	}
+
+	if !p.canShowDetails(request.ChannelId) {
+		writePostActionResponse(w, "Report details are only shown in private channels and direct messages.")
+		return
+	}
+
+	report, err := p.fetchReport(reportID)
+	if err != nil {
+		writePostActionResponse(w, fmt.Sprintf("Something went wrong while getting the report from Hackerone API. Error: %s", err.Error()))
+		return
+	}
+
+	p.sendEphemeralPostByChannelId(userID, request.ChannelId, "", []*model.SlackAttachment{p.getFullReportAttachment(report)})
+	writePostActionResponse(w, "")
+}
+
+func writePostActionResponse(w http.ResponseWriter, ephemeralText string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&model.PostActionIntegrationResponse{
+		EphemeralText: ephemeralText,
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_handleReportDetails(t *testing.T) {
+	body := `{"channel_id":"channel1","context":{"report":"1234"}}`
+
+	t.Run("Wrong method", func(t *testing.T) {
+		p := &Plugin{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, URLReportDetails, nil)
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Result().StatusCode)
+	})
+	t.Run("Missing user", func(t *testing.T) {
+		p := &Plugin{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, URLReportDetails, strings.NewReader(body))
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	})
+	t.Run("Missing report id", func(t *testing.T) {
+		p := &Plugin{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, URLReportDetails, strings.NewReader(`{"channel_id":"channel1"}`))
+		r.Header.Set("Mattermost-User-Id", "user1")
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+	t.Run("User not authorized", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetUser", "user1").Return(&model.User{Id: "user1", Roles: "system_user"}, nil)
+		mockPluginAPI.On("KVGet", PermissionsKey).Return(nil, nil)
+		mockPluginAPI.On("KVGet", PermissionGrantsKey).Return(nil, nil)
+		p.SetAPI(mockPluginAPI)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, URLReportDetails, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-Id", "user1")
+		p.ServeHTTP(nil, w, r)
+
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		var response model.PostActionIntegrationResponse
+		require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&response))
+		assert.Contains(t, response.EphemeralText, "can only be viewed by")
+		mockPluginAPI.AssertNotCalled(t, "SendEphemeralPost")
+	})
+}
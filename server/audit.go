@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Audit entries are bucketed per UTC day, eg: audit-2021-09-02
+	auditKeyPrefix = "audit-"
+	auditDayLayout = "2006-01-02"
+
+	auditDefaultPeriod = 7 * 24 * time.Hour
+	auditMaxPeriod     = 366 * 24 * time.Hour
+	auditMaxListed     = 50
+
+	AuditResultExecuted = "executed"
+	AuditResultDenied   = "denied"
+	AuditResultFailed   = "failed"
+
+	// commandResultProp carries the audit result set by sendEphemeralError from the command
+	// handler to ExecuteCommand, which removes it from the response.
+	commandResultProp = "hackerone_audit_result"
+
+	URLAuditExport = "/api/v1/audit"
+)
+
+// AuditEntry records a slash command run by a user or a write request sent to the Hackerone API.
+type AuditEntry struct {
+	Timestamp int64  `json:"timestamp"`
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	Command   string `json:"command"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+}
+
+func auditKey(t time.Time) string {
+	return auditKeyPrefix + t.UTC().Format(auditDayLayout)
+}
+
+func decodeAuditBucket(value []byte) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	if value == nil {
+		return entries, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "could not properly decode audit log key")
+	}
+
+	return entries, nil
+}
+
+func (p *Plugin) getAuditBucket(key string) ([]*AuditEntry, error) {
+	value, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get audit log from KVStore")
+	}
+
+	return decodeAuditBucket(value)
+}
+
+// AppendAuditEntry adds the entry to the audit log of the day it happened. Entries are never
+// modified or removed once written.
+func (p *Plugin) AppendAuditEntry(entry *AuditEntry) error {
+	key := auditKey(model.GetTimeForMillis(entry.Timestamp))
+	return p.atomicModify(key, func(value []byte) ([]byte, error) {
+		entries, err := decodeAuditBucket(value)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(append(entries, entry))
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting audit log to json")
+		}
+		return b, nil
+	})
+}
+
+// GetAuditEntries returns the entries recorded since the given time, newest first, optionally
+// only those of a single user.
+func (p *Plugin) GetAuditEntries(since time.Time, userID string) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	sinceMillis := model.GetMillisForTime(since)
+	now := time.Now().UTC()
+	for day := since.UTC().Truncate(24 * time.Hour); !day.After(now); day = day.Add(24 * time.Hour) {
+		bucket, err := p.getAuditBucket(auditKey(day))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range bucket {
+			if entry.Timestamp < sinceMillis || (userID != "" && entry.UserID != userID) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp > entries[j].Timestamp
+	})
+	return entries, nil
+}
+
+// recordAudit appends an entry to the audit log, masking any secret in the arguments.
+// Failures are logged rather than returned so that auditing never blocks the audited action.
+func (p *Plugin) recordAudit(userID string, channelID string, command string, arguments string, result string) {
+	masked, _ := p.redact(arguments)
+	entry := &AuditEntry{
+		Timestamp: model.GetMillis(),
+		UserID:    userID,
+		ChannelID: channelID,
+		Command:   command,
+		Arguments: masked,
+		Result:    result,
+	}
+
+	if err := p.AppendAuditEntry(entry); err != nil {
+		p.API.LogError("Unable to record audit log entry", "command", command, "error", err.Error())
+	}
+}
+
+// recordCommandAudit records a /hackerone slash command invocation.
+func (p *Plugin) recordCommandAudit(args *model.CommandArgs, result string) {
+	split := strings.Fields(args.Command)
+	command, arguments := hackeroneCommand, ""
+	if len(split) > 1 {
+		command += " " + split[1]
+	}
+	if len(split) > 2 {
+		arguments = strings.Join(split[2:], " ")
+	}
+	p.recordAudit(args.UserId, args.ChannelId, command, arguments, result)
+}
+
+// parseAuditFilters converts the --since and --user options into the audit log query.
+func (p *Plugin) parseAuditFilters(sinceValue string, username string) (time.Time, string, error) {
+	period := auditDefaultPeriod
+	if sinceValue != "" {
+		var err error
+		if period, err = parseDuration(sinceValue); err != nil {
+			return time.Time{}, "", err
+		}
+		if period > auditMaxPeriod {
+			period = auditMaxPeriod
+		}
+	}
+
+	userID := ""
+	if username != "" {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(username, "@"))
+		if appErr != nil {
+			return time.Time{}, "", errors.Errorf("could not find the user `%s`", username)
+		}
+		userID = user.Id
+	}
+
+	return time.Now().Add(-period), userID, nil
+}
+
+func (p *Plugin) executeAudit(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
+	_, flags := parseCommandFlags(split)
+	since, userID, err := p.parseAuditFilters(flags["since"], flags["user"])
+	if err != nil {
+		msg := fmt.Sprintf("Invalid audit filter: %s. Run the command, eg: `/hackerone audit --user @user1 --since 7d`.", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	entries, err := p.GetAuditEntries(since, userID)
+	if err != nil {
+		p.API.LogError(
+			fmt.Sprintf("Something went wrong while getting the audit log. Error: %s", err.Error()))
+		msg := "Something went wrong while getting the audit log. Please check the server logs"
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	if len(entries) == 0 {
+		return p.sendEphemeralResponse(args, "No audit log entries found matching the filter criteria you have specified."), nil
+	}
+
+	msg := "##### Audit log:\n\n"
+	msg += "| Time | User | Channel | Command | Arguments | Result |\n"
+	msg += "| ----------- | ----------- | ----------- | ----------- | ----------- | ----------- |\n"
+	usernames := map[string]string{}
+	channelNames := map[string]string{}
+	for i, entry := range entries {
+		if i == auditMaxListed {
+			msg += fmt.Sprintf("\nOnly the latest %d of %d entries are shown. Use the JSON export at `/plugins/mattermost-plugin-hackerone%s` to get all of them.", auditMaxListed, len(entries), URLAuditExport)
+			break
+		}
+		msg += fmt.Sprintf("| %s | %s | %s | `%s` | %s | %s |\n",
+			formatMillis(entry.Timestamp),
+			p.displayUsername(entry.UserID, usernames),
+			p.auditChannelName(entry.ChannelID, channelNames),
+			entry.Command,
+			sanitizeInline(entry.Arguments),
+			entry.Result,
+		)
+	}
+
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+func (p *Plugin) auditChannelName(channelID string, cache map[string]string) string {
+	if channelID == "" {
+		return "-"
+	}
+	if name, ok := cache[channelID]; ok {
+		return name
+	}
+	name := channelID
+	if channel, appErr := p.API.GetChannel(channelID); appErr == nil {
+		name = "~" + channel.Name
+	}
+	cache[channelID] = name
+	return name
+}
+
+// handleAuditExport returns the audit log as JSON to plugin admins. It accepts the same
+// filters as the audit command as query parameters, eg: ?since=30d&user=user1
+func (p *Plugin) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	isAdmin, err := p.HasRole(userID, RoleAdmin)
+	if err != nil {
+		p.API.LogError("Error occurred while authorizing the audit log export", "err", err.Error())
+		http.Error(w, "Error occurred while authorizing the request", http.StatusInternalServerError)
+		return
+	}
+	if !isAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	since, filterUserID, err := p.parseAuditFilters(r.URL.Query().Get("since"), r.URL.Query().Get("user"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := p.GetAuditEntries(since, filterUserID)
+	if err != nil {
+		p.API.LogError("Error occurred while exporting the audit log", "err", err.Error())
+		http.Error(w, "Error occurred while exporting the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	p.recordAudit(userID, "", "audit export", r.URL.RawQuery, AuditResultExecuted)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AppendAuditEntry(t *testing.T) {
+	now := time.Date(2021, 9, 2, 10, 0, 0, 0, time.UTC)
+	existing, _ := json.Marshal([]*AuditEntry{{Timestamp: model.GetMillisForTime(now.Add(-time.Hour)), Command: "hackerone reports"}})
+
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	store.values["audit-2021-09-02"] = existing
+	p.SetAPI(mockPluginAPI)
+
+	err := p.AppendAuditEntry(&AuditEntry{Timestamp: model.GetMillisForTime(now), Command: "hackerone permissions"})
+	require.NoError(t, err)
+
+	var entries []*AuditEntry
+	require.NoError(t, json.Unmarshal(store.values["audit-2021-09-02"], &entries))
+	require.Len(t, entries, 2)
+	assert.Equal(t, "hackerone reports", entries[0].Command)
+	assert.Equal(t, "hackerone permissions", entries[1].Command)
+}
+
+func Test_GetAuditEntries(t *testing.T) {
+	now := time.Now()
+	entries := []*AuditEntry{
+		{Timestamp: model.GetMillisForTime(now.Add(-2 * time.Hour)), UserID: "user1", Command: "hackerone reports"},
+		{Timestamp: model.GetMillisForTime(now.Add(-time.Minute)), UserID: "user2", Command: "hackerone report"},
+		{Timestamp: model.GetMillisForTime(now.Add(-time.Second)), UserID: "user1", Command: "hackerone audit"},
+	}
+	bucket, _ := json.Marshal(entries)
+
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("KVGet", auditKey(now)).Return(bucket, nil)
+	mockPluginAPI.On("KVGet", mock.AnythingOfType("string")).Return(nil, nil)
+	p.SetAPI(mockPluginAPI)
+
+	t.Run("All users, newest first", func(t *testing.T) {
+		got, err := p.GetAuditEntries(now.Add(-time.Hour), "")
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "hackerone audit", got[0].Command)
+		assert.Equal(t, "hackerone report", got[1].Command)
+	})
+	t.Run("Single user", func(t *testing.T) {
+		got, err := p.GetAuditEntries(now.Add(-3*time.Hour), "user1")
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "hackerone audit", got[0].Command)
+		assert.Equal(t, "hackerone reports", got[1].Command)
+	})
+	t.Run("Error in store", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("KVGet", mock.AnythingOfType("string")).Return(nil, appError())
+		p.SetAPI(mockPluginAPI)
+		_, err := p.GetAuditEntries(now.Add(-time.Hour), "")
+		require.Error(t, err)
+	})
+}
+
+func Test_recordCommandAudit(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	p.SetAPI(mockPluginAPI)
+
+	p.recordCommandAudit(&model.CommandArgs{
+		UserId:    "user1",
+		ChannelId: "channel1",
+		Command:   "/hackerone reports password=hunter22",
+	}, AuditResultDenied)
+
+	var entries []*AuditEntry
+	require.NoError(t, json.Unmarshal(store.values[auditKey(time.Now())], &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "user1", entries[0].UserID)
+	assert.Equal(t, "channel1", entries[0].ChannelID)
+	assert.Equal(t, "/hackerone reports", entries[0].Command)
+	assert.Equal(t, "password=[REDACTED]", entries[0].Arguments)
+	assert.Equal(t, AuditResultDenied, entries[0].Result)
+}
+
+func Test_ExecuteCommandAudit(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("GetUser", "user1").Return(&model.User{Id: "user1", Roles: "system_admin"}, nil)
+	mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Return(&model.Post{})
+	p.SetAPI(mockPluginAPI)
+
+	args := &model.CommandArgs{UserId: "user1", ChannelId: "channel1"}
+	for _, command := range []string{"/hackerone audit", "/hackerone subscriptions unknown", "/hackerone permissions add"} {
+		args.Command = command
+		response, appErr := p.ExecuteCommand(nil, args)
+		require.Nil(t, appErr)
+		assert.NotContains(t, response.Props, commandResultProp)
+	}
+
+	// Commands rejected or failing in their handler are recorded as failed
+	var entries []*AuditEntry
+	require.NoError(t, json.Unmarshal(store.values[auditKey(time.Now())], &entries))
+	require.Len(t, entries, 3)
+	assert.Equal(t, AuditResultExecuted, entries[0].Result)
+	assert.Equal(t, "/hackerone subscriptions", entries[1].Command)
+	assert.Equal(t, AuditResultFailed, entries[1].Result)
+	assert.Equal(t, "/hackerone permissions", entries[2].Command)
+	assert.Equal(t, AuditResultFailed, entries[2].Result)
+}
+
+func Test_doHTTPRequestAudit(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{})
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	resp, err := p.doHTTPRequest(http.MethodPost, "reports/1234/activities", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	resp, err = p.doHTTPRequest(http.MethodGet, "reports/1234", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// Only write requests are audited
+	var entries []*AuditEntry
+	require.NoError(t, json.Unmarshal(store.values[auditKey(time.Now())], &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Hackerone API POST", entries[0].Command)
+	assert.Equal(t, "reports/1234/activities", entries[0].Arguments)
+	assert.Equal(t, "200 OK", entries[0].Result)
+}
+
+func Test_handleAuditExport(t *testing.T) {
+	t.Run("Wrong method", func(t *testing.T) {
+		p := &Plugin{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, URLAuditExport, nil)
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Result().StatusCode)
+	})
+	t.Run("Missing user", func(t *testing.T) {
+		p := &Plugin{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, URLAuditExport, nil)
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	})
+	t.Run("User is not an admin", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetUser", "user1").Return(&model.User{Id: "user1", Roles: "system_user"}, nil)
+		mockPluginAPI.On("KVGet", PermissionsKey).Return(nil, nil)
+		mockPluginAPI.On("KVGet", PermissionGrantsKey).Return(nil, nil)
+		p.SetAPI(mockPluginAPI)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, URLAuditExport, nil)
+		r.Header.Set("Mattermost-User-Id", "user1")
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	})
+	t.Run("Invalid period", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetUser", "user1").Return(&model.User{Id: "user1", Roles: "system_admin"}, nil)
+		mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+		p.SetAPI(mockPluginAPI)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, URLAuditExport+"?since=soon", nil)
+		r.Header.Set("Mattermost-User-Id", "user1")
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+}
@@ -18,6 +18,9 @@ const (
 	cmdReportKey      = "report"
 	cmdReportsKey     = "reports"
 	cmdSubscribeKey   = "subscriptions"
+	cmdAuditKey       = "audit"
+	cmdSLAKey         = "sla"
+	cmdMetricsKey     = "metrics"
 	cmdError          = "Command Error"
 )
 
@@ -27,8 +30,11 @@ const helpText = "###### Mattermost Hackerone Plugin\n" +
 	// "* `/hackerone stats` - Gets stats info like # of new, # of pending bounty, # of pending disclosure, # of triaged reports\n" +
 	"* `/hackerone reports <filter>` - Gets list of reports from Hackerone based on the filter supplied.\n" +
 	"* `/hackerone report <report_id>` - Gets information about the requested report id\n" +
-	"* `/hackerone subscriptions <command>` - Available subcommands: list, add, delete. Subscribe the current channel to receive Hackerone notifications. Once a channel is subscribed, the service will poll Hackerone for new activity and publish it on the subscribed channel\n" +
-	"* `/hackerone permissions <command>` - Available subcommands: list, add, delete. Access Control users who can run hackerone slash commands.\n" +
+	"* `/hackerone sla [--stage triage|bounty|resolve|first-response] [--at-risk]` - Lists the open reports with their age, SLA target, time remaining or overdue and assignee, the most urgent first. Use `--at-risk` to only list the reports at risk of missing their SLA\n" +
+	"* `/hackerone metrics [--period 90d] [--by severity|asset]` - Shows the median and 90th percentile time to triage, bounty and resolve per month and severity or asset. Admins can compute the metrics of the existing reports with `/hackerone metrics backfill [--period 365d]`\n" +
+	"* `/hackerone subscriptions <command>` - Available subcommands: list, add, edit, pause, resume, delete, export, import. Subscribe the current channel to receive Hackerone notifications. Once a channel is subscribed, the service will poll Hackerone for new activity and publish it on the subscribed channel. Use `add --query state=triaged severity>=high` to follow only the reports matching a query and `pause <subscriptionId> [--until 4h]` to silence a subscription temporarily\n" +
+	"* `/hackerone audit [--user @username] [--since 7d]` - Lists who ran `/hackerone` commands and the write requests sent to Hackerone. Only available to admins.\n" +
+	"* `/hackerone permissions <command>` - Available subcommands: list, add, delete. Access Control users who can run hackerone slash commands. Roles: `viewer` (report, reports), `triager` (plus state changes and comments), `manager` (plus subscriptions) and `admin` (plus permissions).\n" +
 	""
 
 func (p *Plugin) getCommand(config *configuration) (*model.Command, error) {
@@ -40,7 +46,7 @@ func (p *Plugin) getCommand(config *configuration) (*model.Command, error) {
 	return &model.Command{
 		Trigger:              "hackerone",
 		AutoComplete:         true,
-		AutoCompleteDesc:     "Available commands: help, permissions, reports, report, subscriptions",
+		AutoCompleteDesc:     "Available commands: help, permissions, reports, report, sla, metrics, subscriptions, audit",
 		AutoCompleteHint:     "[command]",
 		AutocompleteData:     getAutocompleteData(config),
 		AutocompleteIconData: iconData,
@@ -55,21 +61,48 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		command = split[1]
 	}
 
+	result := AuditResultExecuted
+	defer func() { p.recordCommandAudit(args, result) }()
+
 	if command == cmdHelpKey {
 		return p.sendEphemeralResponse(args, helpText), nil
 	}
 
-	isAllowed, err := p.IsAuthorized(args.UserId)
+	role, err := p.GetUserRole(args.UserId)
 	msg := ""
 	if err != nil {
+		result = AuditResultFailed
 		msg = fmt.Sprintf("error occurred while authorizing the command: %v", err)
 		return p.sendEphemeralResponse(args, msg), nil
 	}
-	if !isAllowed {
+	if role == "" {
+		result = AuditResultDenied
 		msg = "`/hackerone` commands can only be executed by a system administrator or a list of whitelisted users. Please ask your system administrator to run the command, eg: `/hackerone permissions add @user1` to whitelist a specific user."
 		return p.sendEphemeralResponse(args, msg), nil
 	}
 
+	subcommands := []string{}
+	if len(split) > 2 {
+		subcommands = split[2:]
+	}
+	if required := requiredRole(command, subcommands); !roleIncludes(role, required) {
+		result = AuditResultDenied
+		msg = fmt.Sprintf("This command requires the `%s` role or higher, but your role is `%s`. Please ask an admin to update your role, eg: `/hackerone permissions add @user1 %s`.", required, role, required)
+		return p.sendEphemeralResponse(args, msg), nil
+	}
+
+	response, appErr := p.dispatchCommand(args, command, split)
+	if appErr != nil {
+		result = AuditResultFailed
+	} else if value, ok := response.Props[commandResultProp].(string); ok {
+		result = value
+		delete(response.Props, commandResultProp)
+	}
+	return response, appErr
+}
+
+// dispatchCommand runs the handler of the command once the user is allowed to run it.
+func (p *Plugin) dispatchCommand(args *model.CommandArgs, command string, split []string) (*model.CommandResponse, *model.AppError) {
 	switch command {
 	case cmdReportKey:
 		return p.executeReport(args, split[2:])
@@ -79,13 +112,19 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		return p.executeSubscriptions(args, split[2:])
 	case cmdPermissionsKey:
 		return p.executePermissions(args, split[2:])
+	case cmdAuditKey:
+		return p.executeAudit(args, split[2:])
+	case cmdSLAKey:
+		return p.executeSLA(args, split[2:])
+	case cmdMetricsKey:
+		return p.executeMetrics(args, split[2:])
 	default:
 		return p.sendEphemeralResponse(args, helpText), nil
 	}
 }
 
 func getAutocompleteData(config *configuration) *model.AutocompleteData {
-	hackerone := model.NewAutocompleteData("hackerone", "[command]", "Available commands: help, reports, report, subscriptions, permissions")
+	hackerone := model.NewAutocompleteData("hackerone", "[command]", "Available commands: help, reports, report, sla, metrics, subscriptions, permissions, audit")
 	note := " NOTE: Response will be visible to all in this channel."
 
 	help := model.NewAutocompleteData(cmdHelpKey, "", "Display Slash Command help text")
@@ -113,31 +152,57 @@ func getAutocompleteData(config *configuration) *model.AutocompleteData {
 	report := model.NewAutocompleteData(cmdReportKey, "[report-id]", "Gets detailed info about a Hackerone report."+note)
 	hackerone.AddCommand(report)
 
-	subscriptions := model.NewAutocompleteData(cmdSubscribeKey, "[command]", "Available commands: list, add, delete")
+	sla := model.NewAutocompleteData(cmdSLAKey, "[--stage triage|bounty|resolve|first-response] [--at-risk]", "Lists the open reports with their SLA status, the most urgent first. Only visible to you.")
+	hackerone.AddCommand(sla)
 
-	subscribeAdd := model.NewAutocompleteData("add", "<report_id>(optional)", "The current channel will receive notifications when there are any activity on your Hackerone program. If report_id is not specified, it will subscribe to all the Hackerone reports")
+	metrics := model.NewAutocompleteData(cmdMetricsKey, "[--period 90d] [--by severity|asset]", "Shows the median and 90th percentile time to triage, bounty and resolve per month. Only visible to you.")
+	metricsBackfill := model.NewAutocompleteData("backfill", "[--period 365d]", "Computes the metrics of the stages completed during the period, in the background. Only available to admins.")
+	metrics.AddCommand(metricsBackfill)
+	hackerone.AddCommand(metrics)
+
+	subscriptions := model.NewAutocompleteData(cmdSubscribeKey, "[command]", "Available commands: list, add, edit, pause, resume, delete, export, import")
+
+	subscribeAdd := model.NewAutocompleteData("add", "<report_id>(optional) [--query <conditions>]", "The current channel will receive notifications when there are any activity on your Hackerone program. If report_id is not specified, it will subscribe to all the Hackerone reports. Use --query state=triaged severity>=high to follow the reports matching a query")
 	subscriptions.AddCommand(subscribeAdd)
 
+	subscribeEdit := model.NewAutocompleteData("edit", "[subscriptionId] [--report <report_id|all>] [--summary-only true|false] [--query <conditions>] [--sla <targets|off|default>]", "Changes the reports the subscription notifies about, whether notifications include report details and the SLA targets of the channel.")
+	subscriptions.AddCommand(subscribeEdit)
+
+	subscribePause := model.NewAutocompleteData("pause", "[subscriptionId] [--until 4h|2006-01-02T15:04]", "Stops the notifications of the subscription until it is resumed or, with --until, for a while.")
+	subscriptions.AddCommand(subscribePause)
+
+	subscribeResume := model.NewAutocompleteData("resume", "[subscriptionId]", "Resumes the notifications of a paused subscription.")
+	subscriptions.AddCommand(subscribeResume)
+
 	subscribeDelete := model.NewAutocompleteData("delete", "[subscriptionId]", "The specified channel will stop receiving any notifications for any events from Hackerone. You can run the command '/hackerone subscriptions list' to get the subscriptionId.")
 	subscriptions.AddCommand(subscribeDelete)
 
 	subscribeList := model.NewAutocompleteData("list", "", "Lists all the channels which has been set to receive Hackerone notifications")
 	subscriptions.AddCommand(subscribeList)
 
+	subscribeExport := model.NewAutocompleteData("export", "", "Sends you a JSON file of all subscriptions in a direct message, to be imported on another Mattermost instance.")
+	subscriptions.AddCommand(subscribeExport)
+
+	subscribeImport := model.NewAutocompleteData("import", "[fileId] [--dry-run] [--replace]", "Imports the subscriptions of the latest JSON export you uploaded in this channel. Use --dry-run to check the file without importing it, and --replace to let subscriptions to all reports replace the subscriptions of their channel.")
+	subscriptions.AddCommand(subscribeImport)
+
 	hackerone.AddCommand(subscriptions)
 
 	permissions := model.NewAutocompleteData(cmdPermissionsKey, "[command]", "Available commands: list, allow, remove")
 
-	permissionAdd := model.NewAutocompleteData("add", "@username", "Whitelist the user to run the Hackerone slash commands. "+permissionNote)
+	permissionAdd := model.NewAutocompleteData("add", "@username|group:<name>|team:<name>|channel:<name> [viewer|triager|manager|admin] [--for 7d] [--allow-public]", "Whitelist the user, or all members of a group, team or channel, to run the Hackerone slash commands with the given role (default: viewer). Use --for to grant temporary access to a user, and --allow-public to grant the manager or admin role to a public channel or an open team. "+permissionNote)
 	permissions.AddCommand(permissionAdd)
 
-	permissionsRemove := model.NewAutocompleteData("delete", "@username", "Remove the user from running the Hackerone slash commands. "+permissionNote)
+	permissionsRemove := model.NewAutocompleteData("delete", "@username|group:<name>|team:<name>|channel:<name>", "Remove the user, group, team or channel from running the Hackerone slash commands. "+permissionNote)
 	permissions.AddCommand(permissionsRemove)
 
-	permissionsList := model.NewAutocompleteData("list", "", "List all the users who are allowed to run the Hackerone slash commands. "+permissionNote)
+	permissionsList := model.NewAutocompleteData("list", "", "List all the users, groups, teams and channels that are allowed to run the Hackerone slash commands. "+permissionNote)
 	permissions.AddCommand(permissionsList)
 
 	hackerone.AddCommand(permissions)
 
+	audit := model.NewAutocompleteData(cmdAuditKey, "[--user @username] [--since 7d]", "Lists who ran /hackerone commands and the write requests sent to Hackerone. Only available to admins.")
+	hackerone.AddCommand(audit)
+
 	return hackerone
 }
@@ -26,6 +26,27 @@ type configuration struct {
 	HackeroneSLANew                 int
 	HackeroneSLABounty              int
 	HackeroneSLATriaged             int
+	HackeroneRedactionPatterns      string
+	HackeroneSLAPolicies            string
+	HackeroneSLAWorkingDays         string
+	HackeroneSLAWorkingHours        string
+	HackeroneSLATimezone            string
+	HackeroneSLAHolidays            string
+	HackeroneSLAWarningThreshold    string
+	HackeroneSLABreachReminderDays  int
+	HackeroneSLAEscalations         string
+	HackeroneSLAFirstResponse       int
+	// HackeroneClosedReportSubscriptionDays removes single-report subscriptions this many days
+	// after their report was closed, 0 keeps them.
+	HackeroneClosedReportSubscriptionDays int
+
+	HackeroneRestrictDetailsToPrivateChannels bool
+
+	// redactor is computed from HackeroneRedactionPatterns whenever the configuration changes.
+	redactor *redactor
+	// slaPolicy is computed from HackeroneSLAPolicies and the SLA calendar settings whenever the
+	// configuration changes.
+	slaPolicy *slaPolicy
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -37,6 +58,24 @@ func (c *configuration) Clone() *configuration {
 
 // IsValid checks if all needed fields are set.
 func (c *configuration) IsValid() error {
+	if err := c.isValidForJobs(); err != nil {
+		return err
+	}
+
+	if _, err := newRedactor(c.HackeroneRedactionPatterns); err != nil {
+		return err
+	}
+
+	if _, err := c.newSLAPolicyFromSettings(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// isValidForJobs checks the fields needed to run the recurring jobs. Redaction patterns and SLA
+// policies are left out, as OnConfigurationChange falls back to defaults when they are invalid.
+func (c *configuration) isValidForJobs() error {
 	if c.HackeroneProgramHandle == "" {
 		return errors.New("must have a hackerone program handle")
 	}
@@ -66,6 +105,18 @@ func (c *configuration) IsValid() error {
 		return errors.New("SLA for Triaged Reports should be minimum of 1 day")
 	}
 
+	if c.HackeroneSLAFirstResponse < 0 {
+		return errors.New("SLA for First Response should not be negative")
+	}
+
+	if c.HackeroneSLABreachReminderDays < 0 {
+		return errors.New("days between reminders of missed SLA deadlines should not be negative")
+	}
+
+	if c.HackeroneClosedReportSubscriptionDays < 0 {
+		return errors.New("days before removing subscriptions of closed reports should not be negative")
+	}
+
 	return nil
 }
 
@@ -119,6 +170,21 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	// An invalid custom pattern is logged by IsValid below, but the built-in detectors should
+	// keep protecting posted content in the meantime.
+	r, err := newRedactor(configuration.HackeroneRedactionPatterns)
+	if err != nil {
+		r = &redactor{detectors: builtinSecretDetectors}
+	}
+	configuration.redactor = r
+
+	// Likewise, invalid SLA policies fall back to the SLA settings in days, around the clock
+	policy, err := configuration.newSLAPolicyFromSettings()
+	if err != nil {
+		policy = &slaPolicy{defaults: configuration.defaultSLATargets()}
+	}
+	configuration.slaPolicy = policy
+
 	p.setConfiguration(configuration)
 
 	command, err := p.getCommand(configuration)
@@ -136,12 +202,18 @@ func (p *Plugin) OnConfigurationChange() error {
 	p.cancelHackeroneRecurring()
 
 	config := p.getConfiguration()
-	if err := config.IsValid(); err != nil {
+	if err := config.isValidForJobs(); err != nil {
 		return err
 	}
 
 	p.createHackeroneRecurring()
 
+	// The jobs keep running with the defaults used in place of invalid redaction patterns or SLA
+	// settings, so the error is only logged
+	if err := config.IsValid(); err != nil {
+		p.API.LogError("Invalid configuration, the defaults are used instead", "error", err.Error())
+	}
+
 	p.API.LogInfo("Reloaded configuration")
 
 	return nil
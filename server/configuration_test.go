@@ -14,6 +14,14 @@ func Test_configuration_IsValid(t *testing.T) {
 		HackeroneSLANew                 int
 		HackeroneSLABounty              int
 		HackeroneSLATriaged             int
+		HackeroneRedactionPatterns      string
+		HackeroneSLAPolicies            string
+		HackeroneSLAWorkingDays         string
+		HackeroneSLAWorkingHours        string
+		HackeroneSLATimezone            string
+		HackeroneSLAHolidays            string
+		HackeroneSLAEscalations         string
+		HackeroneSLAFirstResponse       int
 	}
 
 	tests := []struct {
@@ -134,6 +142,129 @@ func Test_configuration_IsValid(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid configuration (custom redaction patterns)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneRedactionPatterns:      "internal-[a-z]+\\.example\\.com\n\nCUST-[0-9]{6}",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid configuration (redaction pattern does not compile)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneRedactionPatterns:      "secret-(",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid configuration (SLA policies)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAPolicies:            "critical: triage=4h bounty=1d resolve=7d\nhigh: triage=12h",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid configuration (SLA policy with unknown severity)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAPolicies:            "urgent: triage=4h",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid configuration (SLA calendar)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAWorkingDays:         "mon-fri",
+				HackeroneSLAWorkingHours:        "09:00-17:00",
+				HackeroneSLATimezone:            "Europe/Berlin",
+				HackeroneSLAHolidays:            "2021-12-24\n2021-12-31",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid configuration (SLA working hours)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAWorkingHours:        "17:00-09:00",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid configuration (SLA escalation channel without team)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAEscalations:         "triage 1d channel:security",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid configuration (sla first response < 0)",
+			fields: fields{
+				HackeroneProgramHandle:          "dummy",
+				HackeroneApiIdentifier:          "dummyIdentifier",
+				HackeroneApiKey:                 "dummyKey",
+				HackeronePollIntervalSeconds:    3600,
+				HackeroneSLAPollIntervalSeconds: 86400,
+				HackeroneSLANew:                 1,
+				HackeroneSLABounty:              1,
+				HackeroneSLATriaged:             1,
+				HackeroneSLAFirstResponse:       -1,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -146,6 +277,14 @@ func Test_configuration_IsValid(t *testing.T) {
 				HackeroneSLANew:                 tt.fields.HackeroneSLANew,
 				HackeroneSLABounty:              tt.fields.HackeroneSLABounty,
 				HackeroneSLATriaged:             tt.fields.HackeroneSLATriaged,
+				HackeroneRedactionPatterns:      tt.fields.HackeroneRedactionPatterns,
+				HackeroneSLAPolicies:            tt.fields.HackeroneSLAPolicies,
+				HackeroneSLAWorkingDays:         tt.fields.HackeroneSLAWorkingDays,
+				HackeroneSLAWorkingHours:        tt.fields.HackeroneSLAWorkingHours,
+				HackeroneSLATimezone:            tt.fields.HackeroneSLATimezone,
+				HackeroneSLAHolidays:            tt.fields.HackeroneSLAHolidays,
+				HackeroneSLAEscalations:         tt.fields.HackeroneSLAEscalations,
+				HackeroneSLAFirstResponse:       tt.fields.HackeroneSLAFirstResponse,
 			}
 			if err := c.IsValid(); (err != nil) != tt.wantErr {
 				t.Errorf("configuration.IsValid() error = %v, wantErr %v", err, tt.wantErr)
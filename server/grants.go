@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	PermissionGrantsKey = "permission-grants"
+
+	GrantTypeGroup         = "group"
+	GrantTypeTeam          = "team"
+	GrantTypeChannel       = "channel"
+	GrantTypeChannelAdmins = "channel-admins"
+)
+
+// PermissionGrant gives a role to every member of a Mattermost group, team or channel.
+type PermissionGrant struct {
+	Type     string
+	TargetID string
+	// Name is the name of the target when the grant was created, used for display only.
+	Name string
+	Role string
+}
+
+// Key identifies the grant as typed in the permissions commands, eg: group:security-team
+func (g *PermissionGrant) Key() string {
+	return g.Type + ":" + g.Name
+}
+
+func isGrantSubject(subject string) bool {
+	return strings.Contains(subject, ":")
+}
+
+func decodePermissionGrants(value []byte) ([]*PermissionGrant, error) {
+	grants := []*PermissionGrant{}
+	if value == nil {
+		return grants, nil
+	}
+
+	err := json.NewDecoder(bytes.NewReader(value)).Decode(&grants)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not properly decode permission grants key")
+	}
+
+	return grants, nil
+}
+
+func (p *Plugin) GetPermissionGrants() ([]*PermissionGrant, error) {
+	value, appErr := p.API.KVGet(PermissionGrantsKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get permission grants from KVStore")
+	}
+
+	return decodePermissionGrants(value)
+}
+
+// modifyPermissionGrants atomically replaces the stored grants with the result of modify.
+func (p *Plugin) modifyPermissionGrants(modify func(grants []*PermissionGrant) []*PermissionGrant) error {
+	return p.atomicModify(PermissionGrantsKey, func(value []byte) ([]byte, error) {
+		grants, err := decodePermissionGrants(value)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(modify(grants))
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting permission grants to json")
+		}
+		return b, nil
+	})
+}
+
+// AddPermissionGrant stores the grant, replacing the role of an existing grant for the same target.
+func (p *Plugin) AddPermissionGrant(grant *PermissionGrant) error {
+	err := p.modifyPermissionGrants(func(grants []*PermissionGrant) []*PermissionGrant {
+		for _, g := range grants {
+			if g.Type == grant.Type && g.TargetID == grant.TargetID {
+				g.Role = grant.Role
+				g.Name = grant.Name
+				return grants
+			}
+		}
+		return append(grants, grant)
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store permission grants")
+	}
+
+	return nil
+}
+
+func (p *Plugin) RemovePermissionGrant(grantType string, targetID string) error {
+	err := p.modifyPermissionGrants(func(grants []*PermissionGrant) []*PermissionGrant {
+		newGrants := []*PermissionGrant{}
+		for _, g := range grants {
+			if g.Type != grantType || g.TargetID != targetID {
+				newGrants = append(newGrants, g)
+			}
+		}
+		return newGrants
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store permission grants")
+	}
+
+	return nil
+}
+
+// resolveGrantSubject looks up the group, team or channel referenced by a subject such as
+// group:security-team, team:engineering, channel:town-square or channel-admins:myteam/security.
+// Channels without a team name are looked up in the team the command was run from.
+func (p *Plugin) resolveGrantSubject(subject string, teamID string) (*PermissionGrant, error) {
+	parts := strings.SplitN(subject, ":", 2)
+	grantType, name := strings.ToLower(parts[0]), strings.TrimPrefix(parts[1], "~")
+	if name == "" {
+		return nil, errors.Errorf("please specify a name after `%s:`", grantType)
+	}
+
+	switch grantType {
+	case GrantTypeGroup:
+		group, appErr := p.API.GetGroupByName(strings.TrimPrefix(name, "@"))
+		if appErr != nil {
+			return nil, errors.Errorf("could not find the group `%s`", name)
+		}
+		return &PermissionGrant{Type: grantType, TargetID: group.Id, Name: strings.TrimPrefix(name, "@")}, nil
+	case GrantTypeTeam:
+		team, appErr := p.API.GetTeamByName(name)
+		if appErr != nil {
+			return nil, errors.Errorf("could not find the team `%s`", name)
+		}
+		return &PermissionGrant{Type: grantType, TargetID: team.Id, Name: team.Name}, nil
+	case GrantTypeChannel, GrantTypeChannelAdmins:
+		var channel *model.Channel
+		var appErr *model.AppError
+		if i := strings.Index(name, "/"); i >= 0 {
+			channel, appErr = p.API.GetChannelByNameForTeamName(name[:i], name[i+1:], false)
+		} else {
+			channel, appErr = p.API.GetChannelByName(teamID, name, false)
+		}
+		if appErr != nil {
+			return nil, errors.Errorf("could not find the channel `%s`", name)
+		}
+		return &PermissionGrant{Type: grantType, TargetID: channel.Id, Name: name}, nil
+	default:
+		return nil, errors.Errorf("unknown permission target `%s`. Use `group:<name>`, `team:<name>`, `channel:<name>` or `channel-admins:<name>`", grantType)
+	}
+}
+
+// isOpenGrantTarget reports whether anyone can join the target of the grant, and thereby receive
+// its role: public channels and open teams.
+func (p *Plugin) isOpenGrantTarget(grant *PermissionGrant) (bool, error) {
+	switch grant.Type {
+	case GrantTypeTeam:
+		team, appErr := p.API.GetTeam(grant.TargetID)
+		if appErr != nil {
+			return false, errors.Errorf("could not find the team `%s`", grant.Name)
+		}
+		return team.Type == model.TeamOpen || team.AllowOpenInvite, nil
+	case GrantTypeChannel:
+		channel, appErr := p.API.GetChannel(grant.TargetID)
+		if appErr != nil {
+			return false, errors.Errorf("could not find the channel `%s`", grant.Name)
+		}
+		return channel.Type == model.ChannelTypeOpen, nil
+	default:
+		return false, nil
+	}
+}
+
+// grantIncludesUser reports whether the user is currently a member of the grant's target.
+func (p *Plugin) grantIncludesUser(grant *PermissionGrant, userID string, userGroups map[string]bool) bool {
+	switch grant.Type {
+	case GrantTypeGroup:
+		return userGroups[grant.TargetID]
+	case GrantTypeTeam:
+		member, appErr := p.API.GetTeamMember(grant.TargetID, userID)
+		return appErr == nil && member.DeleteAt == 0
+	case GrantTypeChannel:
+		_, appErr := p.API.GetChannelMember(grant.TargetID, userID)
+		return appErr == nil
+	case GrantTypeChannelAdmins:
+		member, appErr := p.API.GetChannelMember(grant.TargetID, userID)
+		return appErr == nil && member.SchemeAdmin
+	default:
+		return false
+	}
+}
+
+// resolveGrantedRole returns the highest role the user receives through group, team and
+// channel grants, along with the grant it comes from.
+func (p *Plugin) resolveGrantedRole(userID string) (string, *PermissionGrant, error) {
+	grants, err := p.GetPermissionGrants()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(grants) == 0 {
+		return "", nil, nil
+	}
+
+	userGroups := map[string]bool{}
+	for _, g := range grants {
+		if g.Type == GrantTypeGroup {
+			groups, appErr := p.API.GetGroupsForUser(userID)
+			if appErr != nil {
+				return "", nil, errors.Wrap(appErr, "could not get the groups of the user")
+			}
+			for _, group := range groups {
+				userGroups[group.Id] = true
+			}
+			break
+		}
+	}
+
+	role := ""
+	var source *PermissionGrant
+	for _, g := range grants {
+		if roleLevels[g.Role] > roleLevels[role] && p.grantIncludesUser(g, userID, userGroups) {
+			role = g.Role
+			source = g
+		}
+	}
+
+	return role, source, nil
+}
+
+// describeGrant explains where a grant comes from in the permissions list.
+func (p *Plugin) describeGrant(grant *PermissionGrant) string {
+	switch grant.Type {
+	case GrantTypeGroup:
+		group, appErr := p.API.GetGroup(grant.TargetID)
+		if appErr != nil {
+			return fmt.Sprintf("Members of the group `%s` (group could not be found)", grant.Name)
+		}
+		source := "custom"
+		if group.Source == model.GroupSourceLdap {
+			source = "LDAP synced"
+		}
+		return fmt.Sprintf("Members of the %s group `%s`", source, group.DisplayName)
+	case GrantTypeTeam:
+		return fmt.Sprintf("Members of the team `%s`", grant.Name)
+	case GrantTypeChannel:
+		return fmt.Sprintf("Members of the channel ~%s", grant.Name)
+	case GrantTypeChannelAdmins:
+		return fmt.Sprintf("Channel admins of ~%s", grant.Name)
+	default:
+		return grant.Type
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveUserRole_grants(t *testing.T) {
+	grants, _ := json.Marshal([]*PermissionGrant{
+		{Type: GrantTypeGroup, TargetID: "group1", Name: "security", Role: RoleTriager},
+		{Type: GrantTypeTeam, TargetID: "team1", Name: "engineering", Role: RoleViewer},
+		{Type: GrantTypeChannelAdmins, TargetID: "channel1", Name: "security-leads", Role: RoleManager},
+	})
+	perms, _ := json.Marshal([]*Permission{{UserID: "direct", Role: RoleViewer}})
+
+	setup := func(userID string, groups []*model.Group, teamMember bool, channelAdmin *bool) *Plugin {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetUser", userID).Return(&model.User{Id: userID, Roles: "system_user"}, nil)
+		mockPluginAPI.On("KVGet", PermissionsKey).Return(perms, nil)
+		mockPluginAPI.On("KVGet", PermissionGrantsKey).Return(grants, nil)
+		mockPluginAPI.On("GetGroupsForUser", userID).Return(groups, nil)
+		if teamMember {
+			mockPluginAPI.On("GetTeamMember", "team1", userID).Return(&model.TeamMember{TeamId: "team1", UserId: userID}, nil)
+		} else {
+			mockPluginAPI.On("GetTeamMember", "team1", userID).Return(nil, appError())
+		}
+		if channelAdmin != nil {
+			mockPluginAPI.On("GetChannelMember", "channel1", userID).Return(&model.ChannelMember{ChannelId: "channel1", UserId: userID, SchemeAdmin: *channelAdmin}, nil)
+		} else {
+			mockPluginAPI.On("GetChannelMember", "channel1", userID).Return(nil, appError())
+		}
+		mockPluginAPI.On("LogDebug", mock.Anything).Return()
+		p.SetAPI(mockPluginAPI)
+		return p
+	}
+	yes, no := true, false
+
+	tests := []struct {
+		name         string
+		userID       string
+		groups       []*model.Group
+		teamMember   bool
+		channelAdmin *bool
+		wantRole     string
+		wantSource   string
+	}{
+		{
+			name:     "no grants apply",
+			userID:   "user1",
+			wantRole: "",
+		},
+		{
+			name:       "team member",
+			userID:     "user1",
+			teamMember: true,
+			wantRole:   RoleViewer,
+			wantSource: "team:engineering",
+		},
+		{
+			name:       "group member wins over team membership",
+			userID:     "user1",
+			groups:     []*model.Group{{Id: "group1"}},
+			teamMember: true,
+			wantRole:   RoleTriager,
+			wantSource: "group:security",
+		},
+		{
+			name:         "channel member without the channel admin role",
+			userID:       "user1",
+			channelAdmin: &no,
+			wantRole:     "",
+		},
+		{
+			name:         "channel admin",
+			userID:       "user1",
+			groups:       []*model.Group{{Id: "group1"}},
+			channelAdmin: &yes,
+			wantRole:     RoleManager,
+			wantSource:   "channel-admins:security-leads",
+		},
+		{
+			name:       "grant higher than the direct role",
+			userID:     "direct",
+			groups:     []*model.Group{{Id: "group1"}},
+			wantRole:   RoleTriager,
+			wantSource: "group:security",
+		},
+		{
+			name:       "direct role",
+			userID:     "direct",
+			wantRole:   RoleViewer,
+			wantSource: "user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := setup(tt.userID, tt.groups, tt.teamMember, tt.channelAdmin)
+			role, source, err := p.resolveUserRole(tt.userID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRole, role)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+}
+
+func Test_resolveGrantSubject(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	name := "security"
+	mockPluginAPI.On("GetGroupByName", "security").Return(&model.Group{Id: "group1", Name: &name}, nil)
+	mockPluginAPI.On("GetTeamByName", "engineering").Return(&model.Team{Id: "team1", Name: "engineering"}, nil)
+	mockPluginAPI.On("GetChannelByName", "team1", "town-square", false).Return(&model.Channel{Id: "channel1"}, nil)
+	mockPluginAPI.On("GetChannelByNameForTeamName", "other", "leads", false).Return(&model.Channel{Id: "channel2"}, nil)
+	mockPluginAPI.On("GetTeamByName", "missing").Return(nil, appError())
+	p.SetAPI(mockPluginAPI)
+
+	grant, err := p.resolveGrantSubject("group:@security", "team1")
+	require.NoError(t, err)
+	assert.Equal(t, &PermissionGrant{Type: GrantTypeGroup, TargetID: "group1", Name: "security"}, grant)
+
+	grant, err = p.resolveGrantSubject("team:engineering", "team1")
+	require.NoError(t, err)
+	assert.Equal(t, &PermissionGrant{Type: GrantTypeTeam, TargetID: "team1", Name: "engineering"}, grant)
+
+	grant, err = p.resolveGrantSubject("channel:~town-square", "team1")
+	require.NoError(t, err)
+	assert.Equal(t, &PermissionGrant{Type: GrantTypeChannel, TargetID: "channel1", Name: "town-square"}, grant)
+
+	grant, err = p.resolveGrantSubject("channel-admins:other/leads", "team1")
+	require.NoError(t, err)
+	assert.Equal(t, &PermissionGrant{Type: GrantTypeChannelAdmins, TargetID: "channel2", Name: "other/leads"}, grant)
+
+	_, err = p.resolveGrantSubject("team:missing", "team1")
+	assert.EqualError(t, err, "could not find the team `missing`")
+
+	_, err = p.resolveGrantSubject("role:admin", "team1")
+	assert.Error(t, err)
+
+	_, err = p.resolveGrantSubject("group:", "team1")
+	assert.EqualError(t, err, "please specify a name after `group:`")
+}
+
+func Test_handlePermissionGrantAdd_openTargets(t *testing.T) {
+	setup := func() (*Plugin, *string) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		newMemoryKVStore(mockPluginAPI)
+		mockPluginAPI.On("GetTeamByName", "engineering").Return(&model.Team{Id: "team1", Name: "engineering", Type: model.TeamOpen}, nil)
+		mockPluginAPI.On("GetTeam", "team1").Return(&model.Team{Id: "team1", Name: "engineering", Type: model.TeamOpen}, nil)
+		mockPluginAPI.On("GetChannelByName", "team1", "town-square", false).Return(&model.Channel{Id: "public", Type: model.ChannelTypeOpen}, nil)
+		mockPluginAPI.On("GetChannel", "public").Return(&model.Channel{Id: "public", Type: model.ChannelTypeOpen}, nil)
+		mockPluginAPI.On("GetChannelByName", "team1", "security", false).Return(&model.Channel{Id: "private", Type: model.ChannelTypePrivate}, nil)
+		mockPluginAPI.On("GetChannel", "private").Return(&model.Channel{Id: "private", Type: model.ChannelTypePrivate}, nil)
+		message := ""
+		mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			message = args.Get(1).(*model.Post).Message
+		}).Return(&model.Post{})
+		p.SetAPI(mockPluginAPI)
+		return p, &message
+	}
+	args := &model.CommandArgs{UserId: "user1", TeamId: "team1"}
+
+	t.Run("Manager and admin roles are refused on open targets", func(t *testing.T) {
+		p, message := setup()
+		for _, command := range [][]string{
+			{"add", "channel:town-square", RoleAdmin},
+			{"add", "team:engineering", RoleManager},
+		} {
+			p.executePermissions(args, command)
+			assert.Contains(t, *message, "Anyone can join")
+		}
+		grants, err := p.GetPermissionGrants()
+		require.NoError(t, err)
+		assert.Empty(t, grants)
+	})
+	t.Run("Lower roles and private channels are granted", func(t *testing.T) {
+		p, message := setup()
+		p.executePermissions(args, []string{"add", "channel:town-square", RoleViewer})
+		assert.NotContains(t, *message, "Anyone can join")
+		p.executePermissions(args, []string{"add", "channel:security", RoleAdmin})
+		assert.NotContains(t, *message, "Warning")
+		grants, err := p.GetPermissionGrants()
+		require.NoError(t, err)
+		assert.Len(t, grants, 2)
+	})
+	t.Run("Open targets are granted with --allow-public", func(t *testing.T) {
+		p, message := setup()
+		p.executePermissions(args, []string{"add", "team:engineering", RoleAdmin, "--allow-public"})
+		assert.Contains(t, *message, "**Warning:** anyone who joins `team:engineering` receives the `admin` role.")
+		grants, err := p.GetPermissionGrants()
+		require.NoError(t, err)
+		require.Len(t, grants, 1)
+		assert.Equal(t, RoleAdmin, grants[0].Role)
+	})
+	t.Run("--allow-public takes no value", func(t *testing.T) {
+		p, message := setup()
+		p.executePermissions(args, []string{"add", "channel:town-square", "--allow-public", RoleManager})
+		assert.Contains(t, *message, "**Warning:** anyone who joins `channel:town-square` receives the `manager` role.")
+		grants, err := p.GetPermissionGrants()
+		require.NoError(t, err)
+		require.Len(t, grants, 1)
+		assert.Equal(t, RoleManager, grants[0].Role)
+
+		p, message = setup()
+		p.executePermissions(args, []string{"add", "channel:town-square", RoleManager, "--allow-public=yes"})
+		assert.Equal(t, "Invalid permissions option: invalid value `yes` for `--allow-public`, it takes no value", *message)
+		grants, err = p.GetPermissionGrants()
+		require.NoError(t, err)
+		assert.Empty(t, grants)
+	})
+}
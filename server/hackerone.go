@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -13,6 +14,22 @@ const (
 	hackeroneApiUrl = "https://api.hackerone.com/v1/"
 )
 
+// hackeroneStatusError is returned when the Hackerone API answers with a non-ok status code.
+type hackeroneStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *hackeroneStatusError) Error() string {
+	return fmt.Sprintf("non-ok %d status code for url: %s", e.StatusCode, e.URL)
+}
+
+// isHackeroneNotFound reports whether the Hackerone API could not find the requested resource.
+func isHackeroneNotFound(err error) bool {
+	var statusErr *hackeroneStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
 func (p *Plugin) doHTTPRequest(method string, url string, body io.Reader) (*http.Response, error) {
 	p.API.LogDebug("Making HTTP request to Hackerone API:" + hackeroneApiUrl + url)
 	req, err := http.NewRequest(method, hackeroneApiUrl+url, body)
@@ -24,6 +41,13 @@ func (p *Plugin) doHTTPRequest(method string, url string, body io.Reader) (*http
 	}
 
 	resp, err := p.httpClient.Do(req)
+	if method != http.MethodGet {
+		result := AuditResultFailed
+		if err == nil {
+			result = resp.Status
+		}
+		p.recordAudit("", "", "Hackerone API "+method, url, result)
+	}
 	if err != nil {
 		p.API.LogWarn("connection problem for url:" + url)
 		return nil, errors.Wrap(err, "connection problem for url:"+url)
@@ -31,9 +55,9 @@ func (p *Plugin) doHTTPRequest(method string, url string, body io.Reader) (*http
 
 	if resp.StatusCode != http.StatusOK {
 		_ = resp.Body.Close()
-		msg := fmt.Sprintf("non-ok %d status code for url: %s", resp.StatusCode, url)
-		p.API.LogWarn(msg)
-		return nil, errors.New(msg)
+		statusErr := &hackeroneStatusError{StatusCode: resp.StatusCode, URL: url}
+		p.API.LogWarn(statusErr.Error())
+		return nil, statusErr
 	}
 	return resp, err
 }
@@ -51,6 +75,8 @@ type Activity struct {
 		CreatedAt string `json:"created_at"`
 		Internal  bool   `json:"internal"`
 		Message   string `json:"message"`
+		// AutomatedResponse is set for the messages sent automatically by the program.
+		AutomatedResponse bool `json:"automated_response"`
 	} `json:"attributes"`
 	ActivityType  string `json:"type"`
 	Relationships struct {
@@ -96,6 +122,9 @@ func (p *Plugin) fetchActivities(count string, last_updated_at string) (Activiti
 	return response, nil
 }
 
+// reportsPageSize is the number of reports fetched per request, the maximum of the Hackerone API.
+const reportsPageSize = 100
+
 type Reports struct {
 	Reports []Report `json:"data"`
 }
@@ -129,17 +158,65 @@ type Report struct {
 				} `json:"attributes"`
 			} `json:"data"`
 		} `json:"reporter"`
+		Severity struct {
+			Data struct {
+				Attributes struct {
+					Rating string `json:"rating"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"severity"`
+		Program struct {
+			Data struct {
+				Attributes struct {
+					Handle string `json:"handle"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"program"`
+		StructuredScope struct {
+			Data struct {
+				Attributes struct {
+					AssetIdentifier string `json:"asset_identifier"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"structured_scope"`
+		Assignee struct {
+			Data struct {
+				Type       string `json:"type"`
+				Attributes struct {
+					Username string `json:"username"`
+					Name     string `json:"name"`
+				} `json:"attributes"`
+			} `json:"data"`
+		} `json:"assignee"`
 	} `json:"relationships"`
+
+	// firstResponse is when the report was filed and first answered, as recorded from the
+	// activities. It is only set to evaluate the first response SLA.
+	firstResponse *FirstResponse
 }
 
 func (p *Plugin) fetchReports(filters map[string]string) ([]Report, error) {
+	return p.fetchReportsPage(filters, 0)
+}
+
+// fetchReportsPage fetches a page of the reports matching the filters, the first page when page
+// is 0.
+func (p *Plugin) fetchReportsPage(filters map[string]string, page int) ([]Report, error) {
 	program := p.getConfiguration().HackeroneProgramHandle
-	pageSize := 100
-	reportsEndpoint := fmt.Sprintf("reports?filter[program][]=%s&page[size]=%d", program, pageSize)
+	reportsEndpoint := fmt.Sprintf("reports?filter[program][]=%s&page[size]=%d", program, reportsPageSize)
+	if page > 0 {
+		reportsEndpoint += fmt.Sprintf("&page[number]=%d", page)
+	}
 	for key, value := range filters {
-		if key == "state" || key == "severity" {
+		switch key {
+		case "state", "severity":
 			reportsEndpoint += fmt.Sprintf("&filter[%s][]=%s", key, value)
-		} else {
+		case "id":
+			// Several report IDs are separated by commas
+			for _, id := range strings.Split(value, ",") {
+				reportsEndpoint += fmt.Sprintf("&filter[id][]=%s", id)
+			}
+		default:
 			reportsEndpoint += fmt.Sprintf("&filter[%s]=%s", key, value)
 		}
 	}
@@ -164,6 +241,24 @@ func (p *Plugin) fetchReports(filters map[string]string) ([]Report, error) {
 	return response.Reports, nil
 }
 
+// fetchReportsByID fetches the reports with the given IDs, a page of IDs per request. Reports
+// which cannot be found in the program are left out.
+func (p *Plugin) fetchReportsByID(reportIDs []string) ([]Report, error) {
+	reports := []Report{}
+	for start := 0; start < len(reportIDs); start += reportsPageSize {
+		end := start + reportsPageSize
+		if end > len(reportIDs) {
+			end = len(reportIDs)
+		}
+		batch, err := p.fetchReports(map[string]string{"id": strings.Join(reportIDs[start:end], ",")})
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, batch...)
+	}
+	return reports, nil
+}
+
 type Stats struct {
 	NewCount           int `json:"new_count"`
 	TriagedCount       int `json:"triaged_count"`
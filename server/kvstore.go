@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// kvMaxAttempts bounds how often a modification is retried when another user or cluster
+	// node changed the same key in the meantime.
+	kvMaxAttempts = 10
+	kvRetryDelay  = 10 * time.Millisecond
+)
+
+// atomicModify applies modify to the current value of the key and stores the result with a
+// compare-and-set, retrying with the fresh value when the key was changed concurrently.
+// modify receives nil when the key does not exist. Returning a nil value leaves the key
+// untouched, returning an error aborts the modification.
+func (p *Plugin) atomicModify(key string, modify func(value []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < kvMaxAttempts; attempt++ {
+		oldValue, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "could not get %s from KVStore", key)
+		}
+
+		newValue, err := modify(oldValue)
+		if err != nil {
+			return err
+		}
+
+		if newValue == nil || bytes.Equal(oldValue, newValue) {
+			return nil
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(key, oldValue, newValue)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "could not store %s in KV store", key)
+		}
+		if ok {
+			return nil
+		}
+
+		// #nosec G404 -- the jitter only spreads out competing writers
+		time.Sleep(kvRetryDelay + time.Duration(rand.Int63n(int64(kvRetryDelay))))
+	}
+
+	return errors.Errorf("could not store %s in KV store: too many concurrent modifications", key)
+}
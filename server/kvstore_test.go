@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKVStore backs the KV methods of a plugintest.API with a map, honouring the
+// compare-and-set semantics of the server so concurrent writers can be exercised.
+type memoryKVStore struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func newMemoryKVStore(api *plugintest.API) *memoryKVStore {
+	store := &memoryKVStore{values: map[string][]byte{}}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(
+		func(key string) []byte {
+			store.mutex.Lock()
+			defer store.mutex.Unlock()
+			return store.values[key]
+		},
+		func(key string) *model.AppError {
+			return nil
+		}).Maybe()
+	api.On("KVSet", mock.AnythingOfType("string"), mock.Anything).Return(
+		func(key string, value []byte) *model.AppError {
+			store.mutex.Lock()
+			defer store.mutex.Unlock()
+			store.values[key] = value
+			return nil
+		}).Maybe()
+	api.On("KVCompareAndSet", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(
+		func(key string, oldValue []byte, newValue []byte) bool {
+			store.mutex.Lock()
+			defer store.mutex.Unlock()
+			if !bytes.Equal(store.values[key], oldValue) {
+				return false
+			}
+			store.values[key] = newValue
+			return true
+		},
+		func(key string, oldValue []byte, newValue []byte) *model.AppError {
+			return nil
+		}).Maybe()
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(
+		func(key string) *model.AppError {
+			store.mutex.Lock()
+			defer store.mutex.Unlock()
+			delete(store.values, key)
+			return nil
+		}).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			store.mutex.Lock()
+			defer store.mutex.Unlock()
+			if options.Atomic && !bytes.Equal(store.values[key], options.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(store.values, key)
+			} else {
+				store.values[key] = value
+			}
+			return true
+		},
+		func(key string, value []byte, options model.PluginKVSetOptions) *model.AppError {
+			return nil
+		}).Maybe()
+	return store
+}
+
+func Test_atomicModify(t *testing.T) {
+	t.Run("Retries when the value changed concurrently", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("KVGet", "key").Return([]byte("1"), nil).Once()
+		mockPluginAPI.On("KVCompareAndSet", "key", []byte("1"), []byte("1+")).Return(false, nil).Once()
+		mockPluginAPI.On("KVGet", "key").Return([]byte("2"), nil).Once()
+		mockPluginAPI.On("KVCompareAndSet", "key", []byte("2"), []byte("2+")).Return(true, nil).Once()
+		p.SetAPI(mockPluginAPI)
+
+		err := p.atomicModify("key", func(value []byte) ([]byte, error) {
+			return append(value, '+'), nil
+		})
+		require.NoError(t, err)
+		mockPluginAPI.AssertExpectations(t)
+	})
+	t.Run("Gives up after too many conflicts", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("KVGet", "key").Return([]byte("1"), nil)
+		mockPluginAPI.On("KVCompareAndSet", "key", mock.Anything, mock.Anything).Return(false, nil)
+		p.SetAPI(mockPluginAPI)
+
+		err := p.atomicModify("key", func(value []byte) ([]byte, error) {
+			return []byte("2"), nil
+		})
+		require.Error(t, err)
+		mockPluginAPI.AssertNumberOfCalls(t, "KVCompareAndSet", kvMaxAttempts)
+	})
+	t.Run("Unchanged value is not stored", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("KVGet", "key").Return([]byte("1"), nil)
+		p.SetAPI(mockPluginAPI)
+
+		err := p.atomicModify("key", func(value []byte) ([]byte, error) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+		mockPluginAPI.AssertNotCalled(t, "KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func Test_concurrentSubscriptions(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+
+	var wg sync.WaitGroup
+	for i := 0; i < kvMaxAttempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, p.Subscribe("user1", fmt.Sprintf("channel%d", i), "", "", false))
+		}(i)
+	}
+	wg.Wait()
+
+	subs, err := p.GetSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subs, kvMaxAttempts)
+
+	for i, sub := range subs {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			if i%2 == 0 {
+				assert.NoError(t, p.Unsubscribe(id))
+			}
+		}(i, sub.ID)
+	}
+	wg.Wait()
+
+	subs, err = p.GetSubscriptions()
+	require.NoError(t, err)
+	assert.Len(t, subs, kvMaxAttempts/2)
+}
+
+func Test_concurrentPermissions(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+
+	var wg sync.WaitGroup
+	for i := 0; i < kvMaxAttempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, p.AllowPermission(fmt.Sprintf("user%d", i), "admin", RoleViewer, 0))
+		}(i)
+	}
+	wg.Wait()
+
+	perms, err := p.GetPermissions()
+	require.NoError(t, err)
+	require.Len(t, perms, kvMaxAttempts)
+
+	for i := 0; i < kvMaxAttempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				assert.NoError(t, p.RemovePermission(fmt.Sprintf("user%d", i)))
+			} else {
+				assert.NoError(t, p.AllowPermission(fmt.Sprintf("user%d", i), "admin", RoleTriager, 0))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	perms, err = p.GetPermissions()
+	require.NoError(t, err)
+	assert.Len(t, perms, kvMaxAttempts/2)
+	for _, perm := range perms {
+		assert.NotNil(t, findPermission(perms, perm.UserID))
+		assert.Equal(t, RoleTriager, perm.Role)
+	}
+}
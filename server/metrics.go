@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Stage metrics are bucketed per UTC month the stage ended in, eg: metrics-2021-09
+	metricsKeyPrefix   = "metrics-"
+	metricsMonthLayout = "2006-01"
+	metricsDayLayout   = "2006-01-02"
+
+	metricsDefaultPeriod = 90 * 24 * time.Hour
+	metricsMaxPeriod     = 2 * 366 * 24 * time.Hour
+
+	// metricsBackfillMaxPages bounds the reports fetched by a backfill.
+	metricsBackfillMaxPages = 20
+
+	MetricsBySeverity = "severity"
+	MetricsByAsset    = "asset"
+)
+
+// metricStages are the stages whose durations are measured: time to triage, to bounty and to
+// resolve.
+var metricStages = []slaStage{slaStageTriage, slaStageBounty, slaStageResolve}
+
+// StageMetric records how long a report took to go through a stage.
+type StageMetric struct {
+	ReportID  string
+	Stage     slaStage
+	Severity  string
+	Asset     string
+	EnteredAt int64
+	ExitedAt  int64
+}
+
+func metricsKey(t time.Time) string {
+	return metricsKeyPrefix + t.UTC().Format(metricsMonthLayout)
+}
+
+func metricKey(reportID string, stage slaStage) string {
+	return reportID + "/" + string(stage)
+}
+
+// metricInterval returns when the report entered the stage and completed it. Only completed
+// stages are measured: triaged reports for the triage, awarded bounties for the bounty and
+// resolved reports for the resolve stage.
+func metricInterval(report *Report, stage slaStage) (time.Time, time.Time, bool) {
+	enteredAt, exitedAt, ok := stageInterval(report, stage)
+	if !ok || exitedAt.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+
+	switch stage {
+	case slaStageTriage:
+		ok = report.Attributes.TriagedAt != ""
+	case slaStageBounty:
+		ok = report.Attributes.BountyAwardedAt != ""
+	case slaStageResolve:
+		ok = report.Attributes.State == "resolved"
+	}
+	return enteredAt, exitedAt, ok
+}
+
+// newStageMetrics returns the metrics of the stages the report completed.
+func newStageMetrics(report *Report) []*StageMetric {
+	metrics := []*StageMetric{}
+	for _, stage := range metricStages {
+		enteredAt, exitedAt, ok := metricInterval(report, stage)
+		if !ok {
+			continue
+		}
+		metrics = append(metrics, &StageMetric{
+			ReportID:  report.Id,
+			Stage:     stage,
+			Severity:  reportSeverity(report),
+			Asset:     strings.ToLower(report.Relationships.StructuredScope.Data.Attributes.AssetIdentifier),
+			EnteredAt: model.GetMillisForTime(enteredAt),
+			ExitedAt:  model.GetMillisForTime(exitedAt),
+		})
+	}
+	return metrics
+}
+
+func decodeMetricsBucket(value []byte) (map[string]*StageMetric, error) {
+	metrics := map[string]*StageMetric{}
+	if value == nil {
+		return metrics, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&metrics); err != nil {
+		return nil, errors.Wrap(err, "could not properly decode metrics key")
+	}
+
+	return metrics, nil
+}
+
+func (p *Plugin) getMetricsBucket(key string) (map[string]*StageMetric, error) {
+	value, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get metrics from KVStore")
+	}
+
+	return decodeMetricsBucket(value)
+}
+
+// recordStageMetrics stores the stages completed by the reports in the bucket of the month
+// each stage ended in. Stages already recorded are updated, eg: when the severity of the report
+// changed. It returns the number of stage metrics added or updated.
+func (p *Plugin) recordStageMetrics(reports []Report) (int, error) {
+	buckets := map[string][]*StageMetric{}
+	for i := range reports {
+		for _, metric := range newStageMetrics(&reports[i]) {
+			key := metricsKey(model.GetTimeForMillis(metric.ExitedAt))
+			buckets[key] = append(buckets[key], metric)
+		}
+	}
+
+	recorded := 0
+	for key, metrics := range buckets {
+		changed := 0
+		err := p.atomicModify(key, func(value []byte) ([]byte, error) {
+			bucket, err := decodeMetricsBucket(value)
+			if err != nil {
+				return nil, err
+			}
+
+			changed = 0
+			for _, metric := range metrics {
+				id := metricKey(metric.ReportID, metric.Stage)
+				if existing, ok := bucket[id]; ok && *existing == *metric {
+					continue
+				}
+				bucket[id] = metric
+				changed++
+			}
+			if changed == 0 {
+				return nil, nil
+			}
+
+			b, err := json.Marshal(bucket)
+			if err != nil {
+				return nil, errors.Wrap(err, "error while converting metrics to json")
+			}
+			return b, nil
+		})
+		if err != nil {
+			return recorded, errors.Wrap(err, "could not store metrics")
+		}
+		recorded += changed
+	}
+
+	return recorded, nil
+}
+
+// GetStageMetrics returns the stages completed since the given time.
+func (p *Plugin) GetStageMetrics(since time.Time) ([]*StageMetric, error) {
+	metrics := []*StageMetric{}
+	sinceMillis := model.GetMillisForTime(since)
+	now := time.Now().UTC()
+	y, m, _ := since.UTC().Date()
+	for month := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC); !month.After(now); month = month.AddDate(0, 1, 0) {
+		bucket, err := p.getMetricsBucket(metricsKey(month))
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range bucket {
+			if metric.ExitedAt >= sinceMillis {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// metricsBackfillFilters are the filters of the reports which completed each stage after a
+// time, as metrics are kept by the time the stage was completed.
+var metricsBackfillFilters = []string{"triaged_at__gt", "bounty_awarded_at__gt", "closed_at__gt"}
+
+// backfillStageMetrics records the stages completed since the given time, so that metrics cover
+// the history before the activity poller recorded them. Reports submitted before the period are
+// included, as slow reports are those which complete their stages long after their submission.
+// It returns the number of reports checked, of stage durations recorded and whether the reports
+// of a stage were only partially checked.
+func (p *Plugin) backfillStageMetrics(since time.Time) (int, int, bool, error) {
+	seen := map[string]bool{}
+	recorded, truncated := 0, false
+	for _, filter := range metricsBackfillFilters {
+		filters := map[string]string{filter: since.UTC().Format(time.RFC3339)}
+		for page := 1; page <= metricsBackfillMaxPages; page++ {
+			reports, err := p.fetchReportsPage(filters, page)
+			if err != nil {
+				return len(seen), recorded, truncated, err
+			}
+			for i := range reports {
+				seen[reports[i].Id] = true
+			}
+
+			n, err := p.recordStageMetrics(reports)
+			recorded += n
+			if err != nil {
+				return len(seen), recorded, truncated, err
+			}
+			if len(reports) < reportsPageSize {
+				break
+			}
+			if page == metricsBackfillMaxPages {
+				truncated = true
+			}
+		}
+	}
+	return len(seen), recorded, truncated, nil
+}
+
+// metricsRow is the durations of a stage for a month and a severity or asset.
+type metricsRow struct {
+	month     string
+	group     string
+	durations []time.Duration
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// groupStageMetrics groups the durations of the stage per month and severity or asset, ordered
+// by month then by decreasing severity or by asset.
+func groupStageMetrics(metrics []*StageMetric, stage slaStage, by string, calendar *slaCalendar) []*metricsRow {
+	rows := map[string]*metricsRow{}
+	for _, metric := range metrics {
+		if metric.Stage != stage {
+			continue
+		}
+		exitedAt := model.GetTimeForMillis(metric.ExitedAt)
+		group := metric.Severity
+		if by == MetricsByAsset {
+			group = metric.Asset
+			if group == "" {
+				group = "unknown"
+			}
+		}
+		month := exitedAt.UTC().Format(metricsMonthLayout)
+		key := month + "/" + group
+		if _, ok := rows[key]; !ok {
+			rows[key] = &metricsRow{month: month, group: group}
+		}
+		rows[key].durations = append(rows[key].durations, calendar.WorkingTime(model.GetTimeForMillis(metric.EnteredAt), exitedAt))
+	}
+
+	sorted := []*metricsRow{}
+	for _, row := range rows {
+		sort.Slice(row.durations, func(i, j int) bool { return row.durations[i] < row.durations[j] })
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].month != sorted[j].month {
+			return sorted[i].month < sorted[j].month
+		}
+		if by == MetricsBySeverity {
+			return severityLevels[sorted[i].group] > severityLevels[sorted[j].group]
+		}
+		return sorted[i].group < sorted[j].group
+	})
+	return sorted
+}
+
+// parseMetricsOptions converts the --period and --by options of the metrics command.
+func parseMetricsOptions(flags map[string]string) (time.Duration, string, error) {
+	period := metricsDefaultPeriod
+	if value, ok := flags["period"]; ok {
+		var err error
+		if period, err = parseDuration(value); err != nil {
+			return 0, "", err
+		}
+		if period > metricsMaxPeriod {
+			period = metricsMaxPeriod
+		}
+	}
+
+	by := MetricsBySeverity
+	if value, ok := flags["by"]; ok {
+		by = strings.ToLower(value)
+		if by != MetricsBySeverity && by != MetricsByAsset {
+			return 0, "", errors.Errorf("unknown breakdown `%s`, use severity or asset", value)
+		}
+	}
+	return period, by, nil
+}
+
+// isMetricsBackfill reports whether the metrics command asks for a backfill, whether or not its
+// flags come before the backfill subcommand.
+func isMetricsBackfill(split []string) bool {
+	positional, _ := parseCommandFlags(split)
+	return len(positional) > 0 && positional[0] == "backfill"
+}
+
+func (p *Plugin) executeMetrics(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
+	_, flags := parseCommandFlags(split)
+	period, by, err := parseMetricsOptions(flags)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid metrics option: %s. Run the command, eg: `/hackerone metrics --period 90d --by severity`.", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+	since := time.Now().Add(-period)
+
+	if isMetricsBackfill(split) {
+		return p.executeMetricsBackfill(args, since)
+	}
+
+	metrics, err := p.GetStageMetrics(since)
+	if err != nil {
+		p.API.LogError("Something went wrong while getting the metrics", "error", err.Error())
+		msg := "Something went wrong while getting the metrics. Please check the server logs"
+		return p.sendEphemeralError(args, msg), nil
+	}
+	if len(metrics) == 0 {
+		msg := "No metrics recorded for the period you have specified. Admins can compute them from the existing reports with `/hackerone metrics backfill --period 365d`."
+		return p.sendEphemeralResponse(args, msg), nil
+	}
+
+	calendar := p.getConfiguration().getSLAPolicy().calendar
+	column := "Severity"
+	if by == MetricsByAsset {
+		column = "Asset"
+	}
+	msg := fmt.Sprintf("##### Time to triage, bounty and resolve since %s, by %s:\n", since.UTC().Format(metricsDayLayout), by)
+	for _, stage := range metricStages {
+		rows := groupStageMetrics(metrics, stage, by, calendar)
+		if len(rows) == 0 {
+			continue
+		}
+		msg += fmt.Sprintf("\n###### Time to %s\n\n", stage)
+		msg += "| Month | " + column + " | Reports | Median | P90 |\n"
+		msg += "| ----------- | ----------- | ----------- | ----------- | ----------- |\n"
+		for _, row := range rows {
+			msg += fmt.Sprintf("| %s | %s | %d | %s | %s |\n",
+				row.month,
+				sanitizeInline(row.group),
+				len(row.durations),
+				calendar.FormatDuration(percentile(row.durations, 50)),
+				calendar.FormatDuration(percentile(row.durations, 90)),
+			)
+		}
+	}
+	if calendar != nil {
+		msg += "\nDurations are in business time."
+	}
+
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+// executeMetricsBackfill starts the backfill in the background, as it may take many requests to
+// the Hackerone API, and sends its outcome to the user in a direct message.
+func (p *Plugin) executeMetricsBackfill(args *model.CommandArgs, since time.Time) (*model.CommandResponse, *model.AppError) {
+	if !atomic.CompareAndSwapInt32(&p.metricsBackfillRunning, 0, 1) {
+		return p.sendEphemeralError(args, "A metrics backfill is already running, its outcome will be sent to the user who started it."), nil
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&p.metricsBackfillRunning, 0)
+		p.sendDirectMessage(args.UserId, p.runMetricsBackfill(since))
+	}()
+
+	msg := fmt.Sprintf("Backfilling the metrics of the stages completed since %s. You will receive a direct message once it is done.", since.UTC().Format(metricsDayLayout))
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+// runMetricsBackfill backfills the metrics and describes the outcome.
+func (p *Plugin) runMetricsBackfill(since time.Time) string {
+	fetched, recorded, truncated, err := p.backfillStageMetrics(since)
+	if err != nil {
+		p.API.LogError("Something went wrong while backfilling the metrics", "error", err.Error())
+		return fmt.Sprintf("Something went wrong while backfilling the metrics after %d reports. Please check the server logs", fetched)
+	}
+
+	msg := fmt.Sprintf("Backfilled the metrics of %d reports which completed a stage since %s: %d stage durations were recorded.", fetched, since.UTC().Format(metricsDayLayout), recorded)
+	if truncated {
+		msg += fmt.Sprintf(" Only the first %d reports of each stage were checked, run the backfill again with a shorter `--period` to cover the others.", metricsBackfillMaxPages*reportsPageSize)
+	}
+	return msg
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newMetricsReport(id string, severity string, state string, createdAt string, triagedAt string, bountyAwardedAt string, closedAt string) Report {
+	report := Report{Id: id}
+	report.Attributes.State = state
+	report.Attributes.CreatedAt = createdAt
+	report.Attributes.TriagedAt = triagedAt
+	report.Attributes.BountyAwardedAt = bountyAwardedAt
+	report.Attributes.ClosedAt = closedAt
+	report.Relationships.Severity.Data.Attributes.Rating = severity
+	return report
+}
+
+func Test_newStageMetrics(t *testing.T) {
+	stages := func(metrics []*StageMetric) []slaStage {
+		result := []slaStage{}
+		for _, m := range metrics {
+			result = append(result, m.Stage)
+		}
+		return result
+	}
+
+	report := newMetricsReport("1", "high", "new", "2021-10-01T00:00:00Z", "", "", "")
+	assert.Empty(t, newStageMetrics(&report))
+
+	report = newMetricsReport("1", "high", "spam", "2021-10-01T00:00:00Z", "", "", "2021-10-02T00:00:00Z")
+	assert.Empty(t, newStageMetrics(&report), "reports closed without triage are not measured")
+
+	report = newMetricsReport("1", "high", "triaged", "2021-10-01T00:00:00Z", "2021-10-02T00:00:00Z", "2021-10-05T00:00:00Z", "")
+	assert.Equal(t, []slaStage{slaStageTriage, slaStageBounty}, stages(newStageMetrics(&report)))
+
+	report = newMetricsReport("1", "high", "resolved", "2021-10-01T00:00:00Z", "2021-10-02T00:00:00Z", "", "2021-10-10T00:00:00Z")
+	metrics := newStageMetrics(&report)
+	assert.Equal(t, []slaStage{slaStageTriage, slaStageResolve}, stages(metrics))
+	assert.Equal(t, &StageMetric{
+		ReportID:  "1",
+		Stage:     slaStageResolve,
+		Severity:  "high",
+		EnteredAt: model.GetMillisForTime(time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC)),
+		ExitedAt:  model.GetMillisForTime(time.Date(2021, 10, 10, 0, 0, 0, 0, time.UTC)),
+	}, metrics[1])
+}
+
+func Test_percentile(t *testing.T) {
+	durations := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, time.Duration(5), percentile(durations, 50))
+	assert.Equal(t, time.Duration(9), percentile(durations, 90))
+	assert.Equal(t, time.Duration(1), percentile(durations[:1], 90))
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func Test_parseMetricsOptions(t *testing.T) {
+	period, by, err := parseMetricsOptions(map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, metricsDefaultPeriod, period)
+	assert.Equal(t, MetricsBySeverity, by)
+
+	period, by, err = parseMetricsOptions(map[string]string{"period": "30d", "by": "Asset"})
+	require.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, period)
+	assert.Equal(t, MetricsByAsset, by)
+
+	_, _, err = parseMetricsOptions(map[string]string{"by": "reporter"})
+	assert.Error(t, err)
+	_, _, err = parseMetricsOptions(map[string]string{"period": "soon"})
+	assert.Error(t, err)
+}
+
+func Test_backfillStageMetrics(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{})
+
+	// A slow report submitted long before the period, resolved during it
+	now := time.Now().UTC()
+	slow := fmt.Sprintf(`{"id":"1","attributes":{"state":"resolved","created_at":"%s","triaged_at":"%s","closed_at":"%s"}}`,
+		now.AddDate(0, 0, -200).Format(time.RFC3339), now.AddDate(0, 0, -190).Format(time.RFC3339), now.AddDate(0, 0, -5).Format(time.RFC3339))
+	filters := []string{}
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"data":[]}`
+		for key := range r.URL.Query() {
+			if strings.HasSuffix(key, "_at__gt]") {
+				filters = append(filters, key)
+				if key == "filter[closed_at__gt]" {
+					body = `{"data":[` + slow + `]}`
+				}
+			}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	fetched, recorded, truncated, err := p.backfillStageMetrics(now.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"filter[triaged_at__gt]", "filter[bounty_awarded_at__gt]", "filter[closed_at__gt]"}, filters)
+	assert.Equal(t, 1, fetched)
+	assert.Equal(t, 2, recorded)
+	assert.False(t, truncated)
+
+	metrics, err := p.GetStageMetrics(now.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, slaStageResolve, metrics[0].Stage)
+}
+
+func Test_executeMetrics(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	var message string
+	mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		message = args.Get(1).(*model.Post).Message
+	}).Return(&model.Post{})
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{})
+	args := &model.CommandArgs{UserId: "user1", ChannelId: "channel1"}
+
+	// The reports are all handled during the previous month
+	y, m, _ := time.Now().UTC().Date()
+	previousMonth := time.Date(y, m-1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(days int) string {
+		return previousMonth.AddDate(0, 0, 12+days).Format(time.RFC3339)
+	}
+	month := previousMonth.Format(metricsMonthLayout)
+
+	t.Run("No metrics", func(t *testing.T) {
+		_, appErr := p.executeMetrics(args, []string{})
+		require.Nil(t, appErr)
+		assert.Contains(t, message, "No metrics recorded")
+	})
+	t.Run("Backfill", func(t *testing.T) {
+		critical := newReportJSON("1", "critical", time.Now().AddDate(0, 0, -3))
+		stubHackeroneReportList(p, critical)
+		done := make(chan string, 1)
+		mockPluginAPI.On("GetDirectChannel", "user1", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		mockPluginAPI.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			done <- args.Get(0).(*model.Post).Message
+		}).Return(&model.Post{}, nil)
+
+		_, appErr := p.executeMetrics(args, []string{"backfill", "--period", "30d"})
+		require.Nil(t, appErr)
+		assert.Contains(t, message, "You will receive a direct message once it is done")
+
+		select {
+		case dm := <-done:
+			assert.Contains(t, dm, "Backfilled the metrics of 1 reports")
+			assert.Contains(t, dm, "0 stage durations were recorded")
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the backfill did not complete")
+		}
+	})
+	t.Run("Durations per month and severity", func(t *testing.T) {
+		reports := []Report{
+			newMetricsReport("1", "critical", "triaged", day(-3), day(-2), "", ""),
+			newMetricsReport("2", "critical", "triaged", day(-4), day(-1), "", ""),
+			newMetricsReport("3", "low", "resolved", day(-10), day(-6), "", day(-1)),
+		}
+		reports[2].Relationships.StructuredScope.Data.Attributes.AssetIdentifier = "api.example.com"
+		recorded, err := p.recordStageMetrics(reports)
+		require.NoError(t, err)
+		assert.Equal(t, 4, recorded)
+
+		// Metrics are only recorded once
+		recorded, err = p.recordStageMetrics(reports)
+		require.NoError(t, err)
+		assert.Equal(t, 0, recorded)
+
+		_, appErr := p.executeMetrics(args, []string{"--period", "90d"})
+		require.Nil(t, appErr)
+		assert.Contains(t, message, "###### Time to triage")
+		assert.Contains(t, message, "| "+month+" | critical | 2 | 1d | 3d |")
+		assert.NotContains(t, message, "###### Time to bounty")
+		assert.Contains(t, message, "###### Time to resolve")
+		assert.Contains(t, message, "| "+month+" | low | 1 | 5d | 5d |")
+
+		_, appErr = p.executeMetrics(args, []string{"--by", "asset"})
+		require.Nil(t, appErr)
+		assert.Contains(t, message, "| Month | Asset | Reports | Median | P90 |")
+		assert.Contains(t, message, "| "+month+" | api.example.com | 1 | 5d | 5d |")
+		assert.Contains(t, message, "| "+month+" | unknown | 2 | 1d | 3d |")
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SchemaVersionKey holds the version of the data stored in the KV store, ie: the number of
+	// the last migration applied.
+	SchemaVersionKey  = "schema-version"
+	migrationsLockKey = "migrations-lock"
+)
+
+// migration upgrades the data stored in the KV store to the next schema version. Migrations
+// must be idempotent, as a migration interrupted before the schema version is stored is run again.
+type migration struct {
+	version int
+	name    string
+	run     func(p *Plugin) error
+}
+
+// migrations are applied in order, new migrations must be appended with the next version.
+var migrations = []migration{
+	{version: 1, name: "convert permissions from user IDs to permission records", run: (*Plugin).migratePermissionRecords},
+	{version: 2, name: "assign roles to previously allowlisted users", run: (*Plugin).migrateRoles},
+	{version: 3, name: "remove invalid subscriptions", run: (*Plugin).migrateSubscriptions},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (p *Plugin) getSchemaVersion() (int, error) {
+	value, appErr := p.API.KVGet(SchemaVersionKey)
+	if appErr != nil {
+		return 0, errors.Wrap(appErr, "could not get schema version from KVStore")
+	}
+
+	if value == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not properly decode schema version key")
+	}
+
+	return version, nil
+}
+
+func (p *Plugin) storeSchemaVersion(version int) error {
+	if appErr := p.API.KVSet(SchemaVersionKey, []byte(strconv.Itoa(version))); appErr != nil {
+		return errors.Wrap(appErr, "could not store schema version in KV store")
+	}
+
+	return nil
+}
+
+// runMigrations brings the KV store up to the latest schema version. A cluster mutex makes sure
+// only one server of the cluster migrates the data when the plugin is activated on all of them.
+func (p *Plugin) runMigrations() error {
+	lock, err := cluster.NewMutex(p.API, migrationsLockKey)
+	if err != nil {
+		return errors.Wrap(err, "could not create the migrations lock")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	version, err := p.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > latestSchemaVersion() {
+		p.API.LogWarn(fmt.Sprintf("The KV store schema version %d is newer than the version %d supported by this plugin version, skipping migrations", version, latestSchemaVersion()))
+		return nil
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if err := m.run(p); err != nil {
+			return errors.Wrapf(err, "migration %d to %s failed", m.version, m.name)
+		}
+
+		if err := p.storeSchemaVersion(m.version); err != nil {
+			return err
+		}
+
+		p.API.LogInfo(fmt.Sprintf("Migrated the KV store to schema version %d: %s", m.version, m.name))
+	}
+
+	return nil
+}
+
+// migratePermissionRecords converts permissions stored as a flat list of user IDs, as done
+// before roles were introduced.
+func (p *Plugin) migratePermissionRecords() error {
+	return p.atomicModify(PermissionsKey, func(value []byte) ([]byte, error) {
+		if value == nil {
+			return nil, nil
+		}
+
+		userIDs := []string{}
+		if err := json.NewDecoder(bytes.NewReader(value)).Decode(&userIDs); err != nil {
+			// Already stored as permission records
+			return nil, nil
+		}
+
+		perms := []*Permission{}
+		for _, userID := range userIDs {
+			perms = append(perms, &Permission{UserID: userID})
+		}
+
+		b, err := json.Marshal(perms)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting permissions to json")
+		}
+		return b, nil
+	})
+}
+
+// migrateRoles stores the legacy role on the permissions of users who were allowlisted before
+// roles existed.
+func (p *Plugin) migrateRoles() error {
+	migrated := 0
+	err := p.modifyPermissions(func(perms []*Permission) ([]*Permission, error) {
+		migrated = 0
+		for _, perm := range perms {
+			if perm.Role == "" {
+				perm.Role = legacyRole
+				migrated++
+			}
+		}
+		if migrated == 0 {
+			return nil, nil
+		}
+		return perms, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store permissions")
+	}
+
+	if migrated > 0 {
+		p.API.LogInfo(fmt.Sprintf("Assigned the `%s` role to %d previously allowlisted users", legacyRole, migrated))
+	}
+	return nil
+}
+
+// migrateSubscriptions drops the null and channel-less entries that could end up in the
+// subscriptions list, and gives an ID to subscriptions without one so they can be deleted.
+func (p *Plugin) migrateSubscriptions() error {
+	return p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		changed := false
+		newSubs := []*Subscription{}
+		for _, sub := range subs {
+			if sub == nil || sub.ChannelID == "" {
+				changed = true
+				continue
+			}
+			if sub.ID == "" {
+				sub.ID = generateUUIDName()
+				changed = true
+			}
+			newSubs = append(newSubs, sub)
+		}
+
+		if !changed {
+			return nil, nil
+		}
+		return newSubs, nil
+	})
+}
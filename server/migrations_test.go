@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runMigrations(t *testing.T) {
+	setup := func() (*Plugin, *memoryKVStore) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		store := newMemoryKVStore(mockPluginAPI)
+		mockPluginAPI.On("LogInfo", mock.AnythingOfType("string"))
+		mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"))
+		p.SetAPI(mockPluginAPI)
+		return p, store
+	}
+
+	t.Run("Upgrade from unversioned subscriptions and permissions", func(t *testing.T) {
+		p, store := setup()
+		store.values[SubscriptionsKey] = []byte(`[{"ID":"sub1","ChannelID":"channel1","CreatorID":"user1","ReportID":""},{"ID":"sub2","ChannelID":"channel2","CreatorID":"user1","ReportID":"1234"},null]`)
+		store.values[PermissionsKey] = []byte(`["user1","user2"]`)
+
+		require.NoError(t, p.runMigrations())
+
+		version, err := p.getSchemaVersion()
+		require.NoError(t, err)
+		assert.Equal(t, latestSchemaVersion(), version)
+
+		perms, err := p.GetPermissions()
+		require.NoError(t, err)
+		assert.Equal(t, []*Permission{{UserID: "user1", Role: legacyRole}, {UserID: "user2", Role: legacyRole}}, perms)
+
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{
+			{ID: "sub1", ChannelID: "channel1", CreatorID: "user1"},
+			{ID: "sub2", ChannelID: "channel2", CreatorID: "user1", ReportID: "1234"},
+		}, subs)
+
+		_, locked := store.values["mutex_"+migrationsLockKey]
+		assert.False(t, locked)
+	})
+	t.Run("Migrations are idempotent", func(t *testing.T) {
+		p, store := setup()
+		store.values[PermissionsKey] = []byte(`["user1"]`)
+
+		for _, m := range migrations {
+			require.NoError(t, m.run(p))
+		}
+		first := map[string]string{}
+		for key, value := range store.values {
+			first[key] = string(value)
+		}
+
+		for _, m := range migrations {
+			require.NoError(t, m.run(p))
+		}
+		for key, value := range store.values {
+			assert.Equal(t, first[key], string(value), key)
+		}
+		perms, err := p.GetPermissions()
+		require.NoError(t, err)
+		assert.Equal(t, []*Permission{{UserID: "user1", Role: legacyRole}}, perms)
+	})
+	t.Run("Fresh install", func(t *testing.T) {
+		p, store := setup()
+		require.NoError(t, p.runMigrations())
+		assert.Nil(t, store.values[PermissionsKey])
+		assert.Nil(t, store.values[SubscriptionsKey])
+
+		version, err := p.getSchemaVersion()
+		require.NoError(t, err)
+		assert.Equal(t, latestSchemaVersion(), version)
+	})
+	t.Run("Newer schema version is left alone", func(t *testing.T) {
+		p, store := setup()
+		store.values[SchemaVersionKey] = []byte("1000")
+		store.values[PermissionsKey] = []byte(`["user1"]`)
+
+		require.NoError(t, p.runMigrations())
+		assert.Equal(t, `["user1"]`, string(store.values[PermissionsKey]))
+		assert.Equal(t, "1000", string(store.values[SchemaVersionKey]))
+	})
+	t.Run("Failed migration keeps the previous version", func(t *testing.T) {
+		p, store := setup()
+		store.values[PermissionsKey] = []byte(`{`)
+
+		require.Error(t, p.runMigrations())
+		version, err := p.getSchemaVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 1, version)
+	})
+}
+
+func Test_migrateRoles(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogInfo", mock.Anything).Return()
+	p.SetAPI(mockPluginAPI)
+	require.NoError(t, p.StorePermissions([]*Permission{{UserID: "user1", Role: RoleViewer}, {UserID: "user2"}}))
+
+	require.NoError(t, p.migrateRoles())
+	perms, err := p.GetPermissions()
+	require.NoError(t, err)
+	assert.Equal(t, []*Permission{{UserID: "user1", Role: RoleViewer}, {UserID: "user2", Role: legacyRole}}, perms)
+	mockPluginAPI.AssertNumberOfCalls(t, "LogInfo", 1)
+
+	// Nothing to migrate
+	require.NoError(t, p.migrateRoles())
+	mockPluginAPI.AssertNumberOfCalls(t, "LogInfo", 1)
+}
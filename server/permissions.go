@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/pkg/errors"
@@ -12,31 +13,73 @@ import (
 
 const (
 	PermissionsKey = "permissions"
-	permissionNote = "Note: By default, all system administrators can run the `/hackerone` commands."
+	permissionNote = "Note: By default, all system administrators can run the `/hackerone` commands with the `admin` role."
 )
 
 type Permissions struct {
-	Permissions []string
+	Permissions []*Permission
 }
 
-func (p *Plugin) AllowPermission(userID string) error {
-	perms, err := p.GetPermissions()
-	if err != nil {
-		return errors.Wrap(err, "could not get permissions")
+// Permission allows a user to run the /hackerone commands with a role, optionally until ExpiresAt.
+// Timestamps are in milliseconds, an ExpiresAt of 0 means the permission never expires.
+type Permission struct {
+	UserID    string
+	GrantorID string
+	GrantedAt int64
+	ExpiresAt int64
+	Role      string
+}
+
+// userRole returns the role of the permission, the legacy role for permissions stored without
+// a valid role.
+func (perm *Permission) userRole() string {
+	if !isValidRole(perm.Role) {
+		return legacyRole
 	}
-	exists := false
-	for _, v := range perms {
-		if v == userID {
-			exists = true
-			break
+	return perm.Role
+}
+
+// IsExpired reports whether the permission has lapsed at the given time in milliseconds.
+func (perm *Permission) IsExpired(now int64) bool {
+	return perm.ExpiresAt > 0 && perm.ExpiresAt <= now
+}
+
+// findPermission returns the active permission of the user, if any.
+func findPermission(perms []*Permission, userID string) *Permission {
+	now := model.GetMillis()
+	for _, perm := range perms {
+		if perm.UserID == userID && !perm.IsExpired(now) {
+			return perm
 		}
 	}
+	return nil
+}
 
-	if !exists {
-		perms = append(perms, userID)
+// AllowPermission allows the user to run the /hackerone commands with the role. A non-zero
+// expiresAt limits the permission in time, granting again replaces the role, grantor and expiry
+// of an existing permission.
+func (p *Plugin) AllowPermission(userID string, grantorID string, role string, expiresAt int64) error {
+	if !isValidRole(role) {
+		return errors.Errorf("unknown role `%s`. Available roles are: %s", role, strings.Join(roleNames, ", "))
 	}
 
-	err = p.StorePermissions(perms)
+	perm := &Permission{
+		UserID:    userID,
+		GrantorID: grantorID,
+		GrantedAt: model.GetMillis(),
+		ExpiresAt: expiresAt,
+		Role:      role,
+	}
+
+	err := p.modifyPermissions(func(perms []*Permission) ([]*Permission, error) {
+		for i, v := range perms {
+			if v.UserID == userID {
+				perms[i] = perm
+				return perms, nil
+			}
+		}
+		return append(perms, perm), nil
+	})
 	if err != nil {
 		return errors.Wrap(err, "could not store permissions")
 	}
@@ -45,19 +88,15 @@ func (p *Plugin) AllowPermission(userID string) error {
 }
 
 func (p *Plugin) RemovePermission(userID string) error {
-	newPerms := []string{}
-	perms, err := p.GetPermissions()
-	if err != nil {
-		return errors.Wrap(err, "could not get permissions")
-	}
-
-	for _, v := range perms {
-		if v != userID {
-			newPerms = append(newPerms, v)
+	err := p.modifyPermissions(func(perms []*Permission) ([]*Permission, error) {
+		newPerms := []*Permission{}
+		for _, v := range perms {
+			if v.UserID != userID {
+				newPerms = append(newPerms, v)
+			}
 		}
-	}
-
-	err = p.StorePermissions(newPerms)
+		return newPerms, nil
+	})
 	if err != nil {
 		return errors.Wrap(err, "could not store permissions")
 	}
@@ -65,15 +104,10 @@ func (p *Plugin) RemovePermission(userID string) error {
 	return nil
 }
 
-func (p *Plugin) GetPermissions() ([]string, error) {
-	permissions := []string{}
-	value, appErr := p.API.KVGet(PermissionsKey)
-	if appErr != nil {
-		return nil, errors.Wrap(appErr, "could not get permissions from KVStore")
-	}
-
+func decodePermissions(value []byte) ([]*Permission, error) {
+	permissions := []*Permission{}
 	if value == nil {
-		return []string{}, nil
+		return permissions, nil
 	}
 
 	err := json.NewDecoder(bytes.NewReader(value)).Decode(&permissions)
@@ -84,7 +118,38 @@ func (p *Plugin) GetPermissions() ([]string, error) {
 	return permissions, nil
 }
 
-func (p *Plugin) StorePermissions(perm []string) error {
+func (p *Plugin) GetPermissions() ([]*Permission, error) {
+	value, appErr := p.API.KVGet(PermissionsKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get permissions from KVStore")
+	}
+
+	return decodePermissions(value)
+}
+
+// modifyPermissions atomically replaces the stored permissions with the result of modify,
+// so that concurrent changes from other admins or cluster nodes are never lost.
+func (p *Plugin) modifyPermissions(modify func(perms []*Permission) ([]*Permission, error)) error {
+	return p.atomicModify(PermissionsKey, func(value []byte) ([]byte, error) {
+		perms, err := decodePermissions(value)
+		if err != nil {
+			return nil, err
+		}
+
+		perms, err = modify(perms)
+		if err != nil || perms == nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(perms)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting permissions to json")
+		}
+		return b, nil
+	})
+}
+
+func (p *Plugin) StorePermissions(perm []*Permission) error {
 	b, err := json.Marshal(perm)
 	if err != nil {
 		return errors.Wrap(err, "error while converting permissions to json")
@@ -97,6 +162,46 @@ func (p *Plugin) StorePermissions(perm []string) error {
 	return nil
 }
 
+// pruneExpiredPermissions removes lapsed temporary permissions and lets their grantors know.
+func (p *Plugin) pruneExpiredPermissions() error {
+	now := model.GetMillis()
+	expired := []*Permission{}
+	err := p.modifyPermissions(func(perms []*Permission) ([]*Permission, error) {
+		// The list is rebuilt on every attempt, a permission renewed meanwhile is kept
+		expired = []*Permission{}
+		remaining := []*Permission{}
+		for _, perm := range perms {
+			if perm.IsExpired(now) {
+				expired = append(expired, perm)
+			} else {
+				remaining = append(remaining, perm)
+			}
+		}
+		if len(expired) == 0 {
+			return nil, nil
+		}
+		return remaining, nil
+	})
+	if err != nil {
+		p.API.LogWarn("Error while pruning expired permissions", "error", err.Error())
+		return errors.Wrap(err, "could not remove expired permissions")
+	}
+
+	for _, perm := range expired {
+		if perm.GrantorID == "" {
+			continue
+		}
+		username := perm.UserID
+		if user, appErr := p.API.GetUser(perm.UserID); appErr == nil {
+			username = user.Username
+		}
+		msg := fmt.Sprintf("The temporary access you granted to @%s for running `/hackerone` commands has expired and was removed. Run `/hackerone permissions add @%s` to grant it again.", username, username)
+		p.sendDirectMessage(perm.GrantorID, msg)
+	}
+
+	return nil
+}
+
 func (p *Plugin) IsAdmin(userID string) (bool, error) {
 	user, appErr := p.API.GetUser(userID)
 	if appErr != nil {
@@ -111,37 +216,20 @@ func (p *Plugin) IsAdmin(userID string) (bool, error) {
 	return false, nil
 }
 
+// IsAuthorized reports whether the user has any role allowing them to use the plugin.
 func (p *Plugin) IsAuthorized(userID string) (bool, error) {
-	isAdmin, appErr := p.IsAdmin(userID)
-	if appErr != nil {
-		return false, appErr
-	}
-
-	if isAdmin {
-		return true, nil
-	}
-
-	perms, err := p.GetPermissions()
+	role, err := p.GetUserRole(userID)
 	if err != nil {
-		return false, fmt.Errorf(
-			"failed to obtain information about user `%s`: %w", userID, err)
-	}
-
-	for _, u := range perms {
-		if u == userID {
-			p.API.LogDebug(
-				fmt.Sprintf("UserID `%s` is authorized on basis of plugin allowed users list", userID))
-			return true, nil
-		}
+		return false, err
 	}
 
-	return false, nil
+	return role != "", nil
 }
 
 func (p *Plugin) executePermissions(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
 	if 0 >= len(split) {
 		msg := "Invalid permissions command. Available commands are 'list', 'add' and 'delete'."
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	command := split[0]
@@ -150,43 +238,79 @@ func (p *Plugin) executePermissions(args *model.CommandArgs, split []string) (*m
 	case command == "list":
 		return p.handlePermissionsList(args)
 	case command == "add":
-		if len(split) < 2 {
-			msg := "Please specify the user who needs to be whitelisted to run the hackerone slash command. Run the command, eg: `/hackerone permissions add @user1` to whitelist a specific user."
-			return p.sendEphemeralResponse(args, msg), nil
+		positional, flags := parseCommandFlags(split[1:], "allow-public")
+		allowPublic, err := parseBoolFlag(flags, "allow-public")
+		if err != nil {
+			return p.sendEphemeralError(args, "Invalid permissions option: "+err.Error()), nil
+		}
+		if len(positional) < 1 {
+			msg := "Please specify the user who needs to be whitelisted to run the hackerone slash command. Run the command, eg: `/hackerone permissions add @user1 triager` to whitelist a specific user with the `triager` role, or `/hackerone permissions add @user1 triager --for 7d` to whitelist them for 7 days."
+			return p.sendEphemeralError(args, msg), nil
 		} else {
-			return p.handlePermissionsAdd(args, split[1])
+			role := defaultRole
+			if len(positional) >= 2 {
+				role = strings.ToLower(positional[1])
+			}
+			if isGrantSubject(positional[0]) {
+				if _, ok := flags["for"]; ok {
+					msg := "Temporary access can only be granted to users."
+					return p.sendEphemeralError(args, msg), nil
+				}
+				return p.handlePermissionGrantAdd(args, positional[0], role, allowPublic)
+			}
+			var expiresAt int64
+			if value, ok := flags["for"]; ok {
+				var duration time.Duration
+				duration, err = parseDuration(value)
+				if err != nil {
+					msg := fmt.Sprintf("Invalid value for `--for`: %s. Use a duration such as `12h`, `7d` or `2w`.", err.Error())
+					return p.sendEphemeralError(args, msg), nil
+				}
+				expiresAt = model.GetMillis() + duration.Milliseconds()
+			}
+			return p.handlePermissionsAdd(args, positional[0], role, expiresAt)
 		}
 	case command == "delete":
 		if len(split) < 2 {
 			msg := "Please specify the user who needs to be removed from running the /hackerone slash command. Run the command, eg: `/hackerone permissions delete @user1` to remove a specific user."
-			return p.sendEphemeralResponse(args, msg), nil
+			return p.sendEphemeralError(args, msg), nil
+		} else if isGrantSubject(split[1]) {
+			return p.handlePermissionGrantDelete(args, split[1])
 		} else {
 			return p.handlePermissionsDelete(args, split[1])
 		}
 	default:
 		msg := "Unknown subcommand for permissions command. Available commands are 'list', 'add' and 'delete'."
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 }
 
-func (p *Plugin) handlePermissionsAdd(args *model.CommandArgs, username string) (*model.CommandResponse, *model.AppError) {
+func (p *Plugin) handlePermissionsAdd(args *model.CommandArgs, username string, role string, expiresAt int64) (*model.CommandResponse, *model.AppError) {
+	if !isValidRole(role) {
+		msg := fmt.Sprintf("Unknown role `%s`. Available roles are: %s.", role, strings.Join(roleNames, ", "))
+		return p.sendEphemeralError(args, msg), nil
+	}
+
 	username = strings.TrimPrefix(username, "@")
 	user, userErr := p.API.GetUserByUsername(username)
 	if userErr != nil {
 		p.API.LogError(
 			fmt.Sprintf("Something went wrong while adding permissions. Error: %s", userErr.Error()))
 		msg := "Something went wrong while adding permissions. Please check the username (or) check the server logs"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
-	err := p.AllowPermission(user.Id)
+	err := p.AllowPermission(user.Id, args.UserId, role, expiresAt)
 	if err != nil {
 		p.API.LogError(
 			fmt.Sprintf("Something went wrong while adding permissions. Error: %s", err.Error()))
 		msg := "Something went wrong while adding permissions. Please check the username (or) check the server logs"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
+	}
+	msg := fmt.Sprintf("User `%s` was successfully whitelisted to run `/hackerone` commands with the `%s` role. %s", user.Username, role, permissionNote)
+	if expiresAt > 0 {
+		msg = fmt.Sprintf("User `%s` was successfully whitelisted to run `/hackerone` commands with the `%s` role until %s. You will be notified when the access expires. %s", user.Username, role, formatMillis(expiresAt), permissionNote)
 	}
-	msg := fmt.Sprintf("User `%s` was successfully whitelisted to run `/hackerone` commands. %s", user.Username, permissionNote)
 	return p.sendEphemeralResponse(args, msg), nil
 }
 
@@ -197,7 +321,7 @@ func (p *Plugin) handlePermissionsDelete(args *model.CommandArgs, username strin
 		p.API.LogError(
 			fmt.Sprintf("Something went wrong while removing permissions. Error: %s", userErr.Error()))
 		msg := "Something went wrong while removing permissions. Please check the username (or) check the server logs"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	err := p.RemovePermission(user.Id)
@@ -205,12 +329,87 @@ func (p *Plugin) handlePermissionsDelete(args *model.CommandArgs, username strin
 		p.API.LogError(
 			fmt.Sprintf("Something went wrong while removing permissions. Error: %s", err.Error()))
 		msg := "Something went wrong while removing permissions. Please check the username (or) check the server logs"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 	msg := fmt.Sprintf("User `%s` is now removed from the list of users allowed to run `/hackerone` commands. %s", user.Username, permissionNote)
 	return p.sendEphemeralResponse(args, msg), nil
 }
 
+// handlePermissionGrantAdd grants the role to the members of a group, team or channel. As anyone
+// can join a public channel or an open team, granting them the manager or admin role requires
+// --allow-public.
+func (p *Plugin) handlePermissionGrantAdd(args *model.CommandArgs, subject string, role string, allowPublic bool) (*model.CommandResponse, *model.AppError) {
+	if !isValidRole(role) {
+		msg := fmt.Sprintf("Unknown role `%s`. Available roles are: %s.", role, strings.Join(roleNames, ", "))
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	grant, err := p.resolveGrantSubject(subject, args.TeamId)
+	if err != nil {
+		msg := fmt.Sprintf("Something went wrong while adding permissions. Error: %s", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+	grant.Role = role
+
+	warning := ""
+	if roleLevels[role] >= roleLevels[RoleManager] {
+		open, openErr := p.isOpenGrantTarget(grant)
+		if openErr != nil {
+			msg := fmt.Sprintf("Something went wrong while adding permissions. Error: %s", openErr.Error())
+			return p.sendEphemeralError(args, msg), nil
+		}
+		if open && !allowPublic {
+			msg := fmt.Sprintf("Anyone can join `%s` and would receive the `%s` role. Grant it to a private channel or a group instead, or add `--allow-public` to grant it anyway.", grant.Key(), role)
+			return p.sendEphemeralError(args, msg), nil
+		}
+		if open {
+			warning = fmt.Sprintf(" **Warning:** anyone who joins `%s` receives the `%s` role.", grant.Key(), role)
+		}
+	}
+
+	if err := p.AddPermissionGrant(grant); err != nil {
+		p.API.LogError(
+			fmt.Sprintf("Something went wrong while adding permissions. Error: %s", err.Error()))
+		msg := "Something went wrong while adding permissions. Please check the server logs"
+		return p.sendEphemeralError(args, msg), nil
+	}
+	msg := fmt.Sprintf("%s can now run `/hackerone` commands with the `%s` role.%s %s", p.describeGrant(grant), role, warning, permissionNote)
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+func (p *Plugin) handlePermissionGrantDelete(args *model.CommandArgs, subject string) (*model.CommandResponse, *model.AppError) {
+	grant, err := p.resolveGrantSubject(subject, args.TeamId)
+	if err != nil {
+		msg := fmt.Sprintf("Something went wrong while removing permissions. Error: %s", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	if err := p.RemovePermissionGrant(grant.Type, grant.TargetID); err != nil {
+		p.API.LogError(
+			fmt.Sprintf("Something went wrong while removing permissions. Error: %s", err.Error()))
+		msg := "Something went wrong while removing permissions. Please check the server logs"
+		return p.sendEphemeralError(args, msg), nil
+	}
+	msg := fmt.Sprintf("`%s` is now removed from the list of grants allowed to run `/hackerone` commands. %s", grant.Key(), permissionNote)
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+// describePermission explains who granted a user permission and until when.
+func (p *Plugin) describePermission(perm *Permission) string {
+	description := "User"
+	if perm.GrantorID != "" {
+		grantor := perm.GrantorID
+		if user, appErr := p.API.GetUser(perm.GrantorID); appErr == nil {
+			grantor = "@" + user.Username
+		}
+		description += fmt.Sprintf(", granted by %s on %s", grantor, formatMillis(perm.GrantedAt))
+	}
+	if perm.ExpiresAt > 0 {
+		description += ", expires " + formatMillis(perm.ExpiresAt)
+	}
+	return description
+}
+
 func (p *Plugin) handlePermissionsList(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	perms, err := p.GetPermissions()
 	msg := ""
@@ -218,23 +417,41 @@ func (p *Plugin) handlePermissionsList(args *model.CommandArgs) (*model.CommandR
 		p.API.LogError(
 			fmt.Sprintf("Something went wrong while listing permissions. Error: %s", err.Error()))
 		msg := "Something went wrong while listing permissions. Please check the server logs"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
-	if len(perms) == 0 {
+	grants, err := p.GetPermissionGrants()
+	if err != nil {
+		p.API.LogError(
+			fmt.Sprintf("Something went wrong while listing permissions. Error: %s", err.Error()))
+		msg := "Something went wrong while listing permissions. Please check the server logs"
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	if len(perms) == 0 && len(grants) == 0 {
 		msg = "Currently there are no users whitelisted to run `/hackerone` commands. " + permissionNote
 	} else {
-		msg = "Users whitelisted to run `/hackerone` slash commands:\n"
-		for i, u := range perms {
-			user, appErr := p.API.GetUser(u)
+		msg = "##### Grants allowed to run `/hackerone` slash commands:\n\n"
+		msg += "| Grant | Role | Source |\n"
+		msg += "| ----------- | ----------- | ----------- |\n"
+		now := model.GetMillis()
+		for _, perm := range perms {
+			if perm.IsExpired(now) {
+				continue
+			}
+			user, appErr := p.API.GetUser(perm.UserID)
 			if appErr != nil {
 				p.API.LogWarn(
-					fmt.Sprintf("User was whitelisted but user info for userID `%s` could not be obtained. %s", u, appErr.Error()))
+					fmt.Sprintf("User was whitelisted but user info for userID `%s` could not be obtained. %s", perm.UserID, appErr.Error()))
 
 			} else {
-				msg += fmt.Sprintf("%d. @%s\n", i+1, user.Username)
+				msg += fmt.Sprintf("| @%s | %s | %s |\n", user.Username, perm.userRole(), p.describePermission(perm))
 			}
 		}
+		for _, g := range grants {
+			msg += fmt.Sprintf("| %s | %s | %s |\n", g.Key(), g.Role, p.describeGrant(g))
+		}
+		msg += "\n" + permissionNote
 	}
 	return p.sendEphemeralResponse(args, msg), nil
 }
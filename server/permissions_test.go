@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -29,11 +30,14 @@ func Test_GetPermissions(t *testing.T) {
 		p.SetAPI(mockPluginAPI)
 		val, err := p.GetPermissions()
 		assert.NoError(t, err)
-		assert.Equal(t, []string{}, val)
+		assert.Equal(t, []*Permission{}, val)
 	})
 	t.Run("Valid value in store", func(t *testing.T) {
 		mockPluginAPI := &plugintest.API{}
-		arr := []string{"mock1", "mock2"}
+		arr := []*Permission{
+			{UserID: "mock1", GrantorID: "admin", GrantedAt: 1000},
+			{UserID: "mock2", GrantorID: "admin", GrantedAt: 1000, ExpiresAt: 2000},
+		}
 		b, _ := json.Marshal(arr)
 		mockPluginAPI.On("KVGet", PermissionsKey).Return(b, nil)
 		p.SetAPI(mockPluginAPI)
@@ -41,12 +45,19 @@ func Test_GetPermissions(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, arr, val)
 	})
+	t.Run("Invalid value in store", func(t *testing.T) {
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("KVGet", PermissionsKey).Return([]byte("{"), nil)
+		p.SetAPI(mockPluginAPI)
+		_, err := p.GetPermissions()
+		assert.Error(t, err)
+	})
 }
 
 func Test_StorePermissions(t *testing.T) {
 	p := &Plugin{}
 	t.Run("Empty", func(t *testing.T) {
-		arr1 := []string{}
+		arr1 := []*Permission{}
 		mockPluginAPI := &plugintest.API{}
 		mockPluginAPI.On("KVSet", PermissionsKey, mock.AnythingOfType("[]uint8")).Return(nil)
 		p.SetAPI(mockPluginAPI)
@@ -54,7 +65,7 @@ func Test_StorePermissions(t *testing.T) {
 		assert.NoError(t, err)
 	})
 	t.Run("Valid", func(t *testing.T) {
-		arr1 := []string{"mock1", "mock2"}
+		arr1 := []*Permission{{UserID: "mock1"}, {UserID: "mock2", GrantorID: "admin", GrantedAt: 1000, ExpiresAt: 2000}}
 		mockPluginAPI := &plugintest.API{}
 		mockPluginAPI.On("KVSet", PermissionsKey, mock.AnythingOfType("[]uint8")).Return(nil)
 		p.SetAPI(mockPluginAPI)
@@ -63,3 +74,41 @@ func Test_StorePermissions(t *testing.T) {
 	})
 
 }
+
+func Test_pruneExpiredPermissions(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	now := model.GetMillis()
+	perms := []*Permission{
+		{UserID: "permanent", GrantorID: "admin", GrantedAt: now - 1000},
+		{UserID: "active", GrantorID: "admin", GrantedAt: now - 1000, ExpiresAt: now + 60000},
+		{UserID: "expired", GrantorID: "admin", GrantedAt: now - 1000, ExpiresAt: now - 1},
+	}
+	b, _ := json.Marshal(perms)
+	remaining, _ := json.Marshal(perms[:2])
+
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("KVGet", PermissionsKey).Return(b, nil)
+	mockPluginAPI.On("KVCompareAndSet", PermissionsKey, b, remaining).Return(true, nil)
+	mockPluginAPI.On("GetUser", "expired").Return(&model.User{Id: "expired", Username: "contractor"}, nil)
+	mockPluginAPI.On("GetDirectChannel", "admin", "bot").Return(&model.Channel{Id: "dm"}, nil)
+	mockPluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm" && strings.Contains(post.Message, "@contractor")
+	})).Return(&model.Post{}, nil)
+	p.SetAPI(mockPluginAPI)
+
+	assert.NoError(t, p.pruneExpiredPermissions())
+	mockPluginAPI.AssertExpectations(t)
+}
+
+func Test_findPermission(t *testing.T) {
+	now := model.GetMillis()
+	perms := []*Permission{
+		{UserID: "active", ExpiresAt: now + 60000},
+		{UserID: "expired", ExpiresAt: now - 1},
+		{UserID: "permanent"},
+	}
+	assert.NotNil(t, findPermission(perms, "active"))
+	assert.NotNil(t, findPermission(perms, "permanent"))
+	assert.Nil(t, findPermission(perms, "expired"))
+	assert.Nil(t, findPermission(perms, "unknown"))
+}
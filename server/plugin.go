@@ -40,11 +40,21 @@ type Plugin struct {
 	httpClient http.Client
 
 	scheduledJobs []*cluster.Job
+
+	// metricsBackfillRunning is set while a metrics backfill runs in the background.
+	metricsBackfillRunning int32
 }
 
-// ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
+// ServeHTTP handles the integration actions of the buttons posted by the plugin and the plugin API.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Hello, world!")
+	switch r.URL.Path {
+	case URLReportDetails:
+		p.handleReportDetails(w, r)
+	case URLAuditExport:
+		p.handleAuditExport(w, r)
+	default:
+		fmt.Fprint(w, "Hello, world!")
+	}
 }
 
 func (p *Plugin) OnActivate() error {
@@ -77,11 +87,30 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(appErr, "couldn't set profile image")
 	}
 
+	if err := p.runMigrations(); err != nil {
+		return errors.Wrap(err, "couldn't migrate the KV store")
+	}
+
 	registerHackeroneToUsernameMappingCallback(p.getHackeroneToUsernameMapping)
 
 	return nil
 }
 
+// MessageHasBeenPosted removes the subscriptions of a channel as soon as it is archived.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.Type != model.PostTypeChannelDeleted {
+		return
+	}
+
+	channelName := "`" + post.ChannelId + "`"
+	if channel, appErr := p.API.GetChannel(post.ChannelId); appErr == nil {
+		channelName = "~" + channel.Name
+	}
+	if err := p.removeChannelSubscriptions(post.ChannelId, channelName); err != nil {
+		p.API.LogWarn("Error while removing the subscriptions of an archived channel", "channel_id", post.ChannelId, "error", err.Error())
+	}
+}
+
 // getHackeroneToUsernameMapping maps a Hackerone username to the corresponding Mattermost username, if any.
 func (p *Plugin) getHackeroneToUsernameMapping(hackeroneUsername string) string {
 	user, _ := p.API.GetUser(p.getHackeroneToUserIDMapping(hackeroneUsername))
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// severityLevels orders the Hackerone severity ratings.
+var severityLevels = map[string]int{
+	"none":     0,
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// reportStates lists the states a Hackerone report can be in.
+var reportStates = []string{
+	"new", "pending-program-review", "triaged", "needs-more-info", "resolved",
+	"not-applicable", "informative", "duplicate", "spam", "retesting",
+}
+
+// queryDateFields maps the `__null` filters of fetchReports to the report timestamps they check.
+var queryDateFields = map[string]func(r *Report) string{
+	"triaged_at__null":        func(r *Report) string { return r.Attributes.TriagedAt },
+	"bounty_awarded_at__null": func(r *Report) string { return r.Attributes.BountyAwardedAt },
+	"closed_at__null":         func(r *Report) string { return r.Attributes.ClosedAt },
+	"disclosed_at__null":      func(r *Report) string { return r.Attributes.DisclosedAt },
+	"swag_awarded_at__null":   func(r *Report) string { return r.Attributes.SwagAt },
+}
+
+var queryConditionPattern = regexp.MustCompile(`^([a-z_]+)(>=|<=|!=|=|>|<)(.+)$`)
+
+// queryCondition is a single `field<operator>value` term of a report query. Comma separated
+// values match any of the values.
+type queryCondition struct {
+	Field    string
+	Operator string
+	Values   []string
+}
+
+// reportQuery selects the reports matching all of its conditions, eg:
+// state=triaged severity>=high asset=api.example.com
+type reportQuery []queryCondition
+
+// parseReportQuery parses a query using the filter vocabulary of the reports command: state,
+// severity and the `__null` date filters, plus asset which matches the structured scope.
+func parseReportQuery(text string) (reportQuery, error) {
+	terms := strings.Fields(text)
+	if len(terms) == 0 {
+		return nil, errors.New("the query is empty. Use conditions such as `state=triaged severity>=high asset=api.example.com`")
+	}
+
+	query := reportQuery{}
+	for _, term := range terms {
+		m := queryConditionPattern.FindStringSubmatch(strings.ToLower(term))
+		if m == nil {
+			return nil, errors.Errorf("invalid condition `%s`. Conditions look like `state=triaged` or `severity>=high`", term)
+		}
+		condition := queryCondition{Field: m[1], Operator: m[2], Values: strings.Split(m[3], ",")}
+		if err := condition.validate(); err != nil {
+			return nil, err
+		}
+		query = append(query, condition)
+	}
+
+	return query, nil
+}
+
+func (c queryCondition) validate() error {
+	equality := c.Operator == "=" || c.Operator == "!="
+	switch {
+	case c.Field == "state":
+		for _, v := range c.Values {
+			if !contains(reportStates, v) {
+				return errors.Errorf("unknown state `%s`. Available states are: %s", v, strings.Join(reportStates, ", "))
+			}
+		}
+	case c.Field == "severity":
+		for _, v := range c.Values {
+			if _, ok := severityLevels[v]; !ok {
+				return errors.Errorf("unknown severity `%s`. Available severities are: none, low, medium, high, critical", v)
+			}
+		}
+		if !equality && len(c.Values) > 1 {
+			return errors.Errorf("`severity%s` only accepts a single severity", c.Operator)
+		}
+		return nil
+	case c.Field == "asset":
+	case queryDateFields[c.Field] != nil:
+		if len(c.Values) != 1 || (c.Values[0] != "true" && c.Values[0] != "false") {
+			return errors.Errorf("`%s` must be true or false", c.Field)
+		}
+	default:
+		return errors.Errorf("unknown field `%s`. Available fields are: state, severity, asset, triaged_at__null, bounty_awarded_at__null, closed_at__null, disclosed_at__null and swag_awarded_at__null", c.Field)
+	}
+
+	if !equality {
+		return errors.Errorf("`%s` only supports the = and != operators", c.Field)
+	}
+	return nil
+}
+
+// reportSeverity returns the severity rating of the report, "none" when it has not been rated.
+func reportSeverity(report *Report) string {
+	rating := strings.ToLower(report.Relationships.Severity.Data.Attributes.Rating)
+	if _, ok := severityLevels[rating]; !ok {
+		return "none"
+	}
+	return rating
+}
+
+func (c queryCondition) matches(report *Report) bool {
+	var value string
+	switch {
+	case c.Field == "state":
+		value = report.Attributes.State
+	case c.Field == "severity":
+		value = reportSeverity(report)
+		if c.Operator != "=" && c.Operator != "!=" {
+			level, want := severityLevels[value], severityLevels[c.Values[0]]
+			switch c.Operator {
+			case ">=":
+				return level >= want
+			case "<=":
+				return level <= want
+			case ">":
+				return level > want
+			default:
+				return level < want
+			}
+		}
+	case c.Field == "asset":
+		value = strings.ToLower(report.Relationships.StructuredScope.Data.Attributes.AssetIdentifier)
+	default:
+		value = "false"
+		if queryDateFields[c.Field](report) == "" {
+			value = "true"
+		}
+	}
+
+	found := contains(c.Values, value)
+	if c.Operator == "!=" {
+		return !found
+	}
+	return found
+}
+
+// String returns the normalised form of the query, as stored in subscriptions.
+func (q reportQuery) String() string {
+	terms := []string{}
+	for _, c := range q {
+		terms = append(terms, c.Field+c.Operator+strings.Join(c.Values, ","))
+	}
+	return strings.Join(terms, " ")
+}
+
+// Matches reports whether the report satisfies every condition of the query.
+func (q reportQuery) Matches(report *Report) bool {
+	for _, c := range q {
+		if !c.matches(report) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filters converts the conditions supported by the Hackerone API into fetchReports filters,
+// to narrow down the reports fetched before matching them with the query.
+func (q reportQuery) Filters() map[string]string {
+	filters := map[string]string{}
+	for _, c := range q {
+		if c.Operator != "=" || len(c.Values) != 1 {
+			continue
+		}
+		if c.Field == "state" || c.Field == "severity" || queryDateFields[c.Field] != nil {
+			filters[c.Field] = c.Values[0]
+		}
+	}
+	return filters
+}
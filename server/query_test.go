@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseReportQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{name: "normalised", query: "  State=Triaged   severity>=HIGH asset=api.example.com ", want: "state=triaged severity>=high asset=api.example.com"},
+		{name: "several values", query: "state=new,triaged", want: "state=new,triaged"},
+		{name: "date filter", query: "closed_at__null=true", want: "closed_at__null=true"},
+		{name: "empty", query: " ", wantErr: true},
+		{name: "missing operator", query: "triaged", wantErr: true},
+		{name: "unknown field", query: "title=xss", wantErr: true},
+		{name: "unknown state", query: "state=open", wantErr: true},
+		{name: "unknown severity", query: "severity=urgent", wantErr: true},
+		{name: "comparison on state", query: "state>=triaged", wantErr: true},
+		{name: "comparison with several severities", query: "severity>=high,low", wantErr: true},
+		{name: "invalid date filter", query: "closed_at__null=yes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReportQuery(tt.query)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func Test_reportQuery_Matches(t *testing.T) {
+	report := &Report{Id: "1"}
+	report.Attributes.State = "triaged"
+	report.Attributes.TriagedAt = "2021-09-01T10:00:00.000Z"
+	report.Relationships.Severity.Data.Attributes.Rating = "high"
+	report.Relationships.StructuredScope.Data.Attributes.AssetIdentifier = "API.example.com"
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "state=triaged", want: true},
+		{query: "state=new,triaged", want: true},
+		{query: "state!=triaged", want: false},
+		{query: "severity>=high", want: true},
+		{query: "severity>high", want: false},
+		{query: "severity<critical", want: true},
+		{query: "severity<=medium", want: false},
+		{query: "severity=none", want: false},
+		{query: "asset=api.example.com", want: true},
+		{query: "asset=www.example.com", want: false},
+		{query: "triaged_at__null=false closed_at__null=true", want: true},
+		{query: "triaged_at__null=true", want: false},
+		{query: "state=triaged severity>=high asset=api.example.com", want: true},
+		{query: "state=triaged severity=critical", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := parseReportQuery(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, query.Matches(report))
+		})
+	}
+
+	t.Run("unrated reports have no severity", func(t *testing.T) {
+		query, err := parseReportQuery("severity=none")
+		require.NoError(t, err)
+		assert.True(t, query.Matches(&Report{}))
+	})
+}
+
+func Test_reportQuery_Filters(t *testing.T) {
+	query, err := parseReportQuery("state=triaged severity>=high asset=api.example.com closed_at__null=true state!=new")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"state": "triaged", "closed_at__null": "true"}, query.Filters())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	redactedPlaceholder = "[REDACTED]"
+)
+
+// secretDetector finds one kind of secret in free text. When group is non-zero only that
+// capture group is redacted, which keeps surrounding context such as "Authorization: Bearer".
+type secretDetector struct {
+	name     string
+	re       *regexp.Regexp
+	group    int
+	validate func(string) bool
+}
+
+var builtinSecretDetectors = []*secretDetector{
+	{
+		name: "private-key",
+		re:   regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`),
+	},
+	{
+		name: "jwt",
+		re:   regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}`),
+	},
+	{
+		name: "aws-access-key",
+		re:   regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[0-9A-Z]{16}\b`),
+	},
+	{
+		name:  "bearer-token",
+		re:    regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]{8,}=*)`),
+		group: 1,
+	},
+	{
+		name:  "authorization-header",
+		re:    regexp.MustCompile(`(?im)\bauthorization:[ \t]*(?:basic|digest|token)[ \t]+(\S+)`),
+		group: 1,
+	},
+	{
+		name:  "cookie-header",
+		re:    regexp.MustCompile(`(?im)\b(?:set-)?cookie:[ \t]*(\S[^\r\n]*)`),
+		group: 1,
+	},
+	{
+		name:  "credential-assignment",
+		re:    regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|client_secret|api[_-]?key|access[_-]?token|refresh[_-]?token|session[_-]?id)["']?\s*[:=]\s*["']?([^\s"',;&]{4,})`),
+		group: 1,
+	},
+	{
+		name: "hex-secret",
+		re:   regexp.MustCompile(`\b[a-fA-F0-9]{32,}\b`),
+	},
+	{
+		name:     "base64-secret",
+		re:       regexp.MustCompile(`[A-Za-z0-9+_-]{40,}={0,2}`),
+		validate: looksLikeRandomToken,
+	},
+}
+
+// redactor masks secrets found by the built-in detectors and by admin-configured patterns.
+type redactor struct {
+	detectors []*secretDetector
+}
+
+// newRedactor builds a redactor from the built-in detectors and the newline separated list of
+// regular expressions configured by the administrator.
+func newRedactor(customPatterns string) (*redactor, error) {
+	detectors := append([]*secretDetector{}, builtinSecretDetectors...)
+	for i, pattern := range strings.Split(customPatterns, "\n") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redaction pattern on line %d", i+1)
+		}
+		detectors = append(detectors, &secretDetector{
+			name: fmt.Sprintf("custom-%d", i+1),
+			re:   re,
+		})
+	}
+	return &redactor{detectors: detectors}, nil
+}
+
+// Redact replaces every detected secret in text with a placeholder and returns the redacted
+// text together with the number of values that were replaced.
+func (r *redactor) Redact(text string) (string, int) {
+	count := 0
+	for _, d := range r.detectors {
+		var n int
+		text, n = d.redact(text)
+		count += n
+	}
+	return text, count
+}
+
+func (d *secretDetector) redact(text string) (string, int) {
+	matches := d.re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, 0
+	}
+
+	var b strings.Builder
+	count := 0
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.group > 0 && len(m) > 2*d.group+1 {
+			start, end = m[2*d.group], m[2*d.group+1]
+		}
+		if start < 0 || start == end {
+			continue
+		}
+		value := text[start:end]
+		if value == redactedPlaceholder || (d.validate != nil && !d.validate(value)) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(redactedPlaceholder)
+		last = end
+		count++
+	}
+	b.WriteString(text[last:])
+	return b.String(), count
+}
+
+// looksLikeRandomToken filters out long runs of plain words and paths, which would otherwise
+// match the base64 detector, by requiring a mix of upper case, lower case and digits.
+func looksLikeRandomToken(value string) bool {
+	var upper, lower, digit bool
+	for _, c := range value {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// redact masks secrets in researcher supplied text using the active configuration.
+func (p *Plugin) redact(text string) (string, int) {
+	r := p.getConfiguration().redactor
+	if r == nil {
+		r = &redactor{detectors: builtinSecretDetectors}
+	}
+	redacted, count := r.Redact(text)
+	if count > 0 {
+		p.API.LogDebug("Redacted secrets from Hackerone content", "count", count)
+	}
+	return redacted, count
+}
+
+func redactionNote(count int) string {
+	if count == 1 {
+		return "1 value was redacted"
+	}
+	return fmt.Sprintf("%d values were redacted", count)
+}
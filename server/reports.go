@@ -5,31 +5,40 @@ import (
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
-	"github.com/pkg/errors"
 )
 
 const (
-	URLTrigger    = "/trigger"
-	contextReport = "report"
+	URLTrigger       = "/trigger"
+	URLReportDetails = "/details"
+	contextReport    = "report"
+
+	// reportDetailsSummaryLength is the number of characters of the vulnerability information
+	// shown in channel posts. The full text is available through the "Show full details" button.
+	reportDetailsSummaryLength = 800
 )
 
 func (p *Plugin) executeReport(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
 	if len(split) <= 0 {
 		msg := "Report Id should be specified while fetching the report information"
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	reportId := split[0]
 	report, err := p.fetchReport(reportId)
 	if err != nil {
 		msg := fmt.Sprintf("Something went wrong while getting the report from Hackerone API. Error: %s\n", err.Error())
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
+	detailed := p.canShowDetails(args.ChannelId)
 	postAttachments := []*model.SlackAttachment{}
-	attachment := p.getReportAttachment(report, true)
+	attachment := p.getReportAttachment(report, detailed)
 	postAttachments = append(postAttachments, attachment)
 	_ = p.sendPost(args, "", postAttachments)
+	if !detailed {
+		msg := "Report details are only shown in private channels and direct messages, hence only a summary of the report was posted. Run the command in a private channel or a direct message to see the details."
+		return p.sendEphemeralResponse(args, msg), nil
+	}
 	return &model.CommandResponse{}, nil
 }
 
@@ -37,14 +46,14 @@ func (p *Plugin) executeReports(args *model.CommandArgs, split []string) (*model
 	state := ""
 	if len(split) <= 0 {
 		msg := "Filter not provided. Please select a valid option from the autocomplete."
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	state = split[0]
 	allowedStates := []string{"new", "triaged", "needs-more-info", "bounty", "disclosure", "disclosed", "resolved"}
 	if !contains(allowedStates, state) {
 		msg := "Incorrect filter option applied. Please select a valid option from the autocomplete."
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	filters := make(map[string]string)
@@ -70,7 +79,7 @@ func (p *Plugin) executeReports(args *model.CommandArgs, split []string) (*model
 	reports, err := p.fetchReports(filters)
 	if err != nil {
 		msg := fmt.Sprintf("Something went wrong while getting the reports from Hackerone API. Error: %s\n", err.Error())
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	reportString := "#### " + title + "\n\n"
@@ -88,16 +97,27 @@ func (p *Plugin) executeReports(args *model.CommandArgs, split []string) (*model
 	return &model.CommandResponse{}, nil
 }
 
+// getReportAttachment builds the attachment posted for a report. Detailed attachments only
+// include a summary of the vulnerability information along with a button to view all of it.
 func (p *Plugin) getReportAttachment(report Report, detailed bool) *model.SlackAttachment {
+	return p.newReportAttachment(report, detailed, true)
+}
+
+// getFullReportAttachment builds a detailed attachment with the complete vulnerability information.
+func (p *Plugin) getFullReportAttachment(report Report) *model.SlackAttachment {
+	return p.newReportAttachment(report, true, false)
+}
+
+func (p *Plugin) newReportAttachment(report Report, detailed bool, collapse bool) *model.SlackAttachment {
 	fields := []*model.SlackAttachmentField{
 		{
 			Title: "Report Id",
-			Value: report.Id,
+			Value: sanitizeInline(report.Id),
 			Short: true,
 		},
 		{
 			Title: "State",
-			Value: report.Attributes.State,
+			Value: sanitizeInline(report.Attributes.State),
 			Short: true,
 		},
 		{
@@ -143,22 +163,62 @@ func (p *Plugin) getReportAttachment(report Report, detailed bool) *model.SlackA
 		)
 	}
 
+	title, redacted := p.redact(report.Attributes.Title)
+	var actions []*model.PostAction
 	if detailed {
+		info, count := p.redact(report.Attributes.Info)
+		redacted += count
+		if collapse {
+			var truncated bool
+			if info, truncated = truncateText(info, reportDetailsSummaryLength); truncated {
+				actions = append(actions, generateButton("Show full details", URLReportDetails, map[string]interface{}{
+					contextReport: report.Id,
+				}))
+			}
+		}
 		fields = append(fields, &model.SlackAttachmentField{
 			Title: "Report Details",
-			Value: report.Attributes.Info,
+			Value: sanitizeMarkdown(info),
 			Short: false,
 		},
 		)
 	}
 
+	footer := ""
+	if redacted > 0 {
+		footer = "Potential secrets were removed from this report: " + redactionNote(redacted) + "."
+	}
+
 	return &model.SlackAttachment{
-		Title:      report.Attributes.Title,
-		TitleLink:  "https://hackerone.com/reports/" + report.Id,
-		AuthorName: report.Relationships.Reporter.Data.Attributes.Name,
-		AuthorLink: "https://hackerone.com/" + report.Relationships.Reporter.Data.Attributes.Username,
+		Title:      sanitizeInline(title),
+		TitleLink:  hackeroneURL("reports/", report.Id),
+		AuthorName: sanitizePlain(report.Relationships.Reporter.Data.Attributes.Name),
+		AuthorLink: hackeroneURL("", report.Relationships.Reporter.Data.Attributes.Username),
 		Timestamp:  report.Attributes.CreatedAt,
 		Fields:     fields,
+		Footer:     footer,
+		Actions:    actions,
+	}
+}
+
+// canShowDetails reports whether detailed vulnerability information may be posted in the channel.
+// Unless the restriction is disabled, details are only allowed in private channels and DMs.
+func (p *Plugin) canShowDetails(channelID string) bool {
+	if !p.getConfiguration().HackeroneRestrictDetailsToPrivateChannels {
+		return true
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		p.API.LogWarn("Unable to get channel to check if report details can be shown", "channel_id", channelID, "error", appErr.Error())
+		return false
+	}
+
+	switch channel.Type {
+	case model.ChannelTypePrivate, model.ChannelTypeDirect, model.ChannelTypeGroup:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -168,90 +228,277 @@ func generateButton(name string, urlAction string, context map[string]interface{
 		Name: name,
 		Type: model.PostActionTypeButton,
 		Integration: &model.PostActionIntegration{
-			URL:     fmt.Sprintf("/plugins/mattermost-plugin-hackerone/%s", urlAction),
+			URL:     fmt.Sprintf("/plugins/mattermost-plugin-hackerone%s", urlAction),
 			Context: context,
 		},
 	}
 }
 
-func (p *Plugin) notifyReports(filters map[string]string, title string, description string) error {
-	subs, _ := p.GetSubscriptions()
-	reports, err := p.fetchReports(filters)
-	if err != nil {
-		p.API.LogWarn("Error while fetching Reports from Hackerone", "error", err.Error())
-		return errors.Wrap(err, "error while notifying missed deadline reports")
+const (
+	// slaBreachColor and slaWarningColor set apart the attachments of missed and at risk SLAs.
+	slaBreachColor  = "#D24B4E"
+	slaWarningColor = "#FFBC1F"
+)
+
+// reportNotification is a message listing reports in the subscribed channels.
+type reportNotification struct {
+	Title       string
+	Description string
+	// Notes are added to the attachment of the report with the same ID, eg: to explain by how
+	// long it missed its SLA.
+	Notes map[string]string
+	// Color of the report attachments, empty for the default color.
+	Color string
+}
+
+// subscriptionMatches reports whether the subscription notifies about the report. Each
+// subscription can either be for a single reportId, for the reports matching a query or for all
+// reports.
+func subscriptionMatches(s *Subscription, report *Report) bool {
+	switch {
+	case len(s.Query) > 0:
+		// Query subscriptions list the reports matching their query
+		return matchReport(s, report)
+	case len(s.ReportID) > 0:
+		// Notify only if subscription's report ID is equal to report fetched from Hackerone
+		return s.ReportID == report.Id
+	default:
+		// Else it means it is subscribed to receive all reports info
+		return true
 	}
+}
 
+// notifySubscriptions posts the reports in the channel of each of the subscriptions, listing
+// the reports the subscription notifies about.
+func (p *Plugin) notifySubscriptions(subs []*Subscription, reports []Report, notification *reportNotification) {
 	if len(reports) == 0 {
-		return nil
+		return
 	}
 
-	reportString := "#### " + title + "\n" + description + "\n\n"
-	// Each subscription can either be for a single reportId or for all reports
+	reportString := "#### " + notification.Title + "\n" + notification.Description + "\n\n"
+	now := model.GetMillis()
 	for _, s := range subs {
-		found := false
+		if s.IsPaused(now) {
+			continue
+		}
 		postAttachments := []*model.SlackAttachment{}
-		for _, report := range reports {
-			// If subscription has a report Id, only notify the subscription's report ID
-			if len(s.ReportID) > 0 {
-				// Notify only if subscription's report ID is equal to report fetched from Hackerone
-				if s.ReportID == report.Id {
-					attachment := p.getReportAttachment(report, false)
-					postAttachments = append(postAttachments, attachment)
-					found = true
-					break
-				}
-			} else {
-				// Else it means it is subscribed to receive all reports info
-				attachment := p.getReportAttachment(report, false)
-				postAttachments = append(postAttachments, attachment)
-				found = true
+		for i := range reports {
+			if subscriptionMatches(s, &reports[i]) {
+				postAttachments = append(postAttachments, p.getNotifiedReportAttachment(reports[i], notification))
 			}
 		}
-		if found {
+		if len(postAttachments) > 0 {
 			p.sendPostByChannelId(s.ChannelID, reportString, postAttachments)
 		}
 	}
-	return nil
 }
 
+func (p *Plugin) getNotifiedReportAttachment(report Report, notification *reportNotification) *model.SlackAttachment {
+	attachment := p.getReportAttachment(report, false)
+	attachment.Color = notification.Color
+	if note, ok := notification.Notes[report.Id]; ok {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "SLA",
+			Value: note,
+			Short: false,
+		})
+	}
+	return attachment
+}
+
+// slaCheck describes the SLA notifications of a stage.
+type slaCheck struct {
+	stage slaStage
+	// label names the reports of the stage in the notification titles.
+	label               string
+	description         string
+	reminderDescription string
+	warningDescription  string
+	resolvedVerb        string
+}
+
+var slaChecks = []slaCheck{
+	{
+		stage:               slaStageTriage,
+		label:               "New Reports",
+		description:         "These reports have not been triaged within the SLA of their severity and hence have missed SLA deadlines.",
+		reminderDescription: "These reports missed the SLA of their severity and are still waiting to be triaged.",
+		warningDescription:  "These reports will miss the SLA of their severity soon unless they are triaged.",
+		resolvedVerb:        "triaged",
+	},
+	{
+		stage:               slaStageBounty,
+		label:               "Bounty to be rewarded",
+		description:         "Bounty has not been rewarded for these triaged reports within the SLA of their severity and hence have missed SLA deadlines.",
+		reminderDescription: "These triaged reports missed the SLA of their severity and are still waiting for a bounty.",
+		warningDescription:  "These triaged reports will miss the SLA of their severity soon unless a bounty is rewarded.",
+		resolvedVerb:        "rewarded",
+	},
+	{
+		stage:               slaStageResolve,
+		label:               "Triaged reports to be resolved",
+		description:         "These triaged reports have not been resolved within the SLA of their severity and hence have missed SLA deadlines.",
+		reminderDescription: "These triaged reports missed the SLA of their severity and are still waiting to be resolved.",
+		warningDescription:  "These triaged reports will miss the SLA of their severity soon unless they are resolved.",
+		resolvedVerb:        "closed",
+	},
+	{
+		stage:               slaStageFirstResponse,
+		label:               "Reports awaiting a first response",
+		description:         "These reports have not received a first response from the team within the SLA of their severity and hence have missed SLA deadlines.",
+		reminderDescription: "These reports missed the first response SLA of their severity and are still waiting for a response.",
+		warningDescription:  "These reports will miss the first response SLA of their severity soon unless the team responds.",
+		resolvedVerb:        "answered",
+	},
+}
+
+// notifyMissedDeadlineReports posts the reports which newly missed their SLA, reminders about the
+// reports which still miss it, the reports which moved on after missing it, and the reports at
+// risk of missing it when a warning threshold is configured. Subscriptions overriding the SLA
+// settings are evaluated against their own targets and receive their own lists.
 func (p *Plugin) notifyMissedDeadlineReports() error {
-	subs, _ := p.GetSubscriptions()
-	if len(subs) > 0 {
-		// Check for Missed Deadline - New Reports
-		filters := getDeadlineReportFilter("new", p.getConfiguration().HackeroneSLANew)
-		desc := fmt.Sprintf("These reports have not been triaged for more than %d days and hence have missed SLA deadlines.", p.getConfiguration().HackeroneSLANew)
-		p.notifyReports(filters, "Missed SLA Deadline - New Reports:", desc)
-
-		// Check for Missed Deadline - Pending Bounty
-		filters = getDeadlineReportFilter("bounty", p.getConfiguration().HackeroneSLABounty)
-		desc = fmt.Sprintf("Bounty has not been rewarded for these triaged reports for more than %d days and hence have missed SLA deadlines.", p.getConfiguration().HackeroneSLABounty)
-		p.notifyReports(filters, "Missed SLA Deadline - Bounty to be rewarded:", desc)
-
-		// Check for Missed Deadline - Triaged Reports
-		filters = getDeadlineReportFilter("triaged", p.getConfiguration().HackeroneSLATriaged)
-		desc = fmt.Sprintf("These triaged reports have not been resolved for more than %d days and hence have missed SLA deadlines.", p.getConfiguration().HackeroneSLATriaged)
-		p.notifyReports(filters, "Missed SLA Deadline - Triaged reports to be resolved:", desc)
+	subs, err := p.GetSubscriptions()
+	if err != nil {
+		// Without the subscriptions, the breaches of their scopes would be pruned
+		p.API.LogWarn("Error while checking for subscriptions", "error", err.Error())
+		return err
+	}
+
+	// The global scope is checked even without subscriptions, as its breaches are escalated
+	scopes := p.getSLAScopes(subs)
+	for _, check := range slaChecks {
+		// Fetch the reports which entered the stage long enough ago to be at risk of missing the
+		// shortest target of the stage in any scope, then check each report against the target
+		// of its own severity in each scope
+		horizon, measured := time.Duration(0), false
+		for _, scope := range scopes {
+			if !scope.evaluates(check.stage) {
+				continue
+			}
+			if h := scope.policy.WarningHorizon(check.stage); !measured || h < horizon {
+				horizon, measured = h, true
+			}
+		}
+		if !measured {
+			continue
+		}
+		var reports []Report
+		reports, err = p.fetchSLAReports(check.stage, horizon)
+		if err != nil {
+			p.API.LogWarn("Error while fetching Reports from Hackerone", "error", err.Error())
+			continue
+		}
+
+		for _, scope := range scopes {
+			if !scope.evaluates(check.stage) {
+				continue
+			}
+			p.notifySLAScope(scope, check, reports, time.Now())
+		}
+	}
+
+	if err = p.pruneSLABreachScopes(scopes); err != nil {
+		p.API.LogWarn("Error while removing the SLA breaches of former scopes", "error", err.Error())
 	}
 	return nil
 }
 
-func getDeadlineReportFilter(filterType string, sla int) map[string]string {
+// notifySLAScope evaluates the reports of the stage against the policy of the scope, and posts
+// the SLA notifications to the subscriptions of the scope.
+func (p *Plugin) notifySLAScope(scope *slaScope, check slaCheck, reports []Report, now time.Time) {
+	breached, atRisk := []slaEvaluation{}, []slaEvaluation{}
+	for i := range reports {
+		if !scope.matches(&reports[i]) {
+			continue
+		}
+		status, ok := scope.policy.Evaluate(&reports[i], check.stage)
+		if !ok || !status.ExitedAt.IsZero() {
+			continue
+		}
+		if status.Breached(now) {
+			breached = append(breached, slaEvaluation{report: reports[i], status: status})
+		} else if scope.policy.AtRisk(status, now) {
+			atRisk = append(atRisk, slaEvaluation{report: reports[i], status: status})
+		}
+	}
+
+	newBreaches, reminders, resolved, err := p.trackSLABreaches(scope, check.stage, breached, now)
+	if err != nil {
+		p.API.LogWarn("Error while tracking SLA breaches", "error", err.Error())
+		return
+	}
+	if scope.id == "" {
+		if err = p.escalateSLABreaches(check, breached, now); err != nil {
+			p.API.LogWarn("Error while escalating SLA breaches", "error", err.Error())
+		}
+		p.notifyResolvedEscalations(check, resolved)
+	}
+	warnings, err := p.trackSLAWarnings(scope, check.stage, atRisk, now)
+	if err != nil {
+		p.API.LogWarn("Error while tracking SLA warnings", "error", err.Error())
+		warnings = nil
+	}
+
+	p.notifySLAReports(scope.subscriptions, newBreaches, &reportNotification{
+		Title:       "Missed SLA Deadline - " + check.label + ":",
+		Description: check.description,
+		Color:       slaBreachColor,
+	}, func(status *slaStatus) string { return describeSLAStatus(status, now) })
+	p.notifySLAReports(scope.subscriptions, reminders, &reportNotification{
+		Title:       "Reminder: Missed SLA Deadline - " + check.label + ":",
+		Description: check.reminderDescription,
+		Color:       slaBreachColor,
+	}, func(status *slaStatus) string { return describeSLAStatus(status, now) })
+	p.notifySLAReports(scope.subscriptions, resolved, &reportNotification{
+		Title:       ":white_check_mark: Resolved after SLA Breach - " + check.label + ":",
+		Description: "These reports have finally been " + check.resolvedVerb + " after missing the SLA of their severity.",
+		Color:       slaResolvedColor,
+	}, describeSLAResolution)
+	p.notifySLAReports(scope.subscriptions, warnings, &reportNotification{
+		Title:       ":warning: SLA Deadline at Risk - " + check.label + ":",
+		Description: check.warningDescription,
+		Color:       slaWarningColor,
+	}, func(status *slaStatus) string { return describeSLAStatus(status, now) })
+}
+
+// notifySLAReports posts the evaluated reports to the subscriptions, with a note describing the
+// SLA status of each report.
+func (p *Plugin) notifySLAReports(subs []*Subscription, evaluations []slaEvaluation, notification *reportNotification, describe func(status *slaStatus) string) {
+	reports := []Report{}
+	notification.Notes = map[string]string{}
+	for _, e := range evaluations {
+		reports = append(reports, e.report)
+		notification.Notes[e.report.Id] = describe(e.status)
+	}
+	p.notifySubscriptions(subs, reports, notification)
+}
+
+// listedInSLAStage reports whether the report has the state of the reports fetched for the stage.
+// Reports in another state are not listed by the SLA checks until they get back to it.
+func listedInSLAStage(report *Report, stage slaStage) bool {
+	state, ok := getDeadlineReportFilter(stage, 0)["state"]
+	return !ok || report.Attributes.State == state
+}
+
+// getDeadlineReportFilter returns the filters of the reports which are still in the stage and
+// entered it longer than sla ago.
+func getDeadlineReportFilter(stage slaStage, sla time.Duration) map[string]string {
 	filters := make(map[string]string)
 	now := time.Now().UTC()
-	min_created_at := now.AddDate(0, 0, -sla)
-	filters["created_at__lt"] = min_created_at.Format(time.RFC3339)
-	switch filterType {
-	case "new":
+	enteredBefore := now.Add(-sla).Format(time.RFC3339)
+	switch stage {
+	case slaStageTriage:
 		filters["state"] = "new"
 		filters["triaged_at__null"] = "true"
-	case "bounty":
+		filters["created_at__lt"] = enteredBefore
+	case slaStageBounty:
 		filters["state"] = "triaged"
 		filters["bounty_awarded_at__null"] = "true"
-	case "triaged":
+		filters["triaged_at__lt"] = enteredBefore
+	case slaStageResolve:
 		filters["state"] = "triaged"
-		filters["bounty_awarded_at__null"] = "false"
-		filters["closed_at__null"] = "false"
+		filters["closed_at__null"] = "true"
+		filters["triaged_at__lt"] = enteredBefore
 	}
 	return filters
 }
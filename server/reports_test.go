@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getReportAttachment(t *testing.T) {
+	p := &Plugin{}
+	report := Report{Id: "1234"}
+	report.Attributes.Title = "@all [Urgent](https://evil.example.com) fix"
+	report.Attributes.State = "new"
+	report.Attributes.Info = "Ping ~town-square and @here, see ![x](https://evil.example.com/p.png)"
+	report.Relationships.Reporter.Data.Attributes.Name = "@channel"
+	report.Relationships.Reporter.Data.Attributes.Username = "eve"
+
+	attachment := p.getReportAttachment(report, true)
+	assert.Equal(t, "@\u200ball \\[Urgent\\]\\(https://evil.example.com\\) fix", attachment.Title)
+	assert.Equal(t, "https://hackerone.com/reports/1234", attachment.TitleLink)
+	assert.Equal(t, "@\u200bchannel", attachment.AuthorName)
+	assert.Equal(t, "https://hackerone.com/eve", attachment.AuthorLink)
+	details := attachment.Fields[len(attachment.Fields)-1]
+	assert.Equal(t, "Report Details", details.Title)
+	assert.Equal(t, "Ping ~\u200btown-square and @\u200bhere, see !\\[x\\](https://evil.example.com/p.png)", details.Value)
+	assert.Empty(t, attachment.Footer)
+}
+
+func Test_getReportAttachment_collapsed(t *testing.T) {
+	p := &Plugin{}
+	report := Report{Id: "1234"}
+	report.Attributes.Info = strings.Repeat("Step to reproduce.\n", 100)
+
+	collapsed := p.getReportAttachment(report, true)
+	details := collapsed.Fields[len(collapsed.Fields)-1]
+	assert.True(t, len(details.Value.(string)) <= reportDetailsSummaryLength+len("\n…"))
+	require.Len(t, collapsed.Actions, 1)
+	assert.Equal(t, "Show full details", collapsed.Actions[0].Name)
+	assert.Equal(t, "/plugins/mattermost-plugin-hackerone/details", collapsed.Actions[0].Integration.URL)
+	assert.Equal(t, "1234", collapsed.Actions[0].Integration.Context[contextReport])
+
+	full := p.getFullReportAttachment(report)
+	assert.Equal(t, report.Attributes.Info, full.Fields[len(full.Fields)-1].Value)
+	assert.Empty(t, full.Actions)
+
+	summary := p.getReportAttachment(report, false)
+	assert.Empty(t, summary.Actions)
+}
+
+func Test_canShowDetails(t *testing.T) {
+	tests := []struct {
+		name        string
+		restrict    bool
+		channelType model.ChannelType
+		want        bool
+	}{
+		{name: "restriction disabled", restrict: false, channelType: model.ChannelTypeOpen, want: true},
+		{name: "public channel", restrict: true, channelType: model.ChannelTypeOpen, want: false},
+		{name: "private channel", restrict: true, channelType: model.ChannelTypePrivate, want: true},
+		{name: "direct message", restrict: true, channelType: model.ChannelTypeDirect, want: true},
+		{name: "group message", restrict: true, channelType: model.ChannelTypeGroup, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{}
+			p.setConfiguration(&configuration{HackeroneRestrictDetailsToPrivateChannels: tt.restrict})
+			mockPluginAPI := &plugintest.API{}
+			mockPluginAPI.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Type: tt.channelType}, nil)
+			p.SetAPI(mockPluginAPI)
+			assert.Equal(t, tt.want, p.canShowDetails("channel1"))
+		})
+	}
+
+	t.Run("unknown channel", func(t *testing.T) {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{HackeroneRestrictDetailsToPrivateChannels: true})
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetChannel", "channel1").Return(nil, appError())
+		mockPluginAPI.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+		p.SetAPI(mockPluginAPI)
+		assert.False(t, p.canShowDetails("channel1"))
+	})
+}
+
+// stubHackeroneReportList answers the report list requests of the plugin with the given reports.
+func stubHackeroneReportList(p *Plugin, reports ...string) {
+	body := `{"data":[` + strings.Join(reports, ",") + `]}`
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+}
+
+func newReportJSON(id string, severity string, createdAt time.Time) string {
+	return fmt.Sprintf(`{"id":"%s","attributes":{"title":"Report %s","state":"new","created_at":"%s"},"relationships":{"severity":{"data":{"attributes":{"rating":"%s"}}}}}`,
+		id, id, createdAt.UTC().Format(time.RFC3339), severity)
+}
+
+func Test_notifyMissedDeadlineReports(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	config := &configuration{
+		HackeroneSLANew:              3,
+		HackeroneSLABounty:           7,
+		HackeroneSLATriaged:          15,
+		HackeroneSLAPolicies:         "critical: triage=4h",
+		HackeroneSLAWarningThreshold: "2h",
+	}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+	require.NoError(t, p.StoreSubscriptions([]*Subscription{{ID: "sub1", ChannelID: "channel1"}}))
+
+	now := time.Now()
+	stubHackeroneReportList(p,
+		newReportJSON("1", "critical", now.Add(-10*time.Hour)),
+		newReportJSON("2", "critical", now.Add(-3*time.Hour)),
+		newReportJSON("3", "low", now.Add(-time.Hour)),
+	)
+
+	posts := []*model.Post{}
+	mockPluginAPI.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		posts = append(posts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+
+	require.NoError(t, p.notifyMissedDeadlineReports())
+
+	require.Len(t, posts, 2)
+	assert.Contains(t, posts[0].Message, "Missed SLA Deadline - New Reports")
+	breached := posts[0].Attachments()
+	require.Len(t, breached, 1)
+	assert.Equal(t, "Report 1", breached[0].Title)
+	assert.Equal(t, slaBreachColor, breached[0].Color)
+	assert.Contains(t, breached[0].Fields[len(breached[0].Fields)-1].Value.(string), "overdue by 6h")
+
+	assert.Contains(t, posts[1].Message, "SLA Deadline at Risk - New Reports")
+	atRisk := posts[1].Attachments()
+	require.Len(t, atRisk, 1)
+	assert.Equal(t, "Report 2", atRisk[0].Title)
+	assert.Equal(t, slaWarningColor, atRisk[0].Color)
+	assert.Contains(t, atRisk[0].Fields[len(atRisk[0].Fields)-1].Value.(string), "remaining")
+
+	// Neither the breach nor the warning are announced again on the next run
+	posts = []*model.Post{}
+	require.NoError(t, p.notifyMissedDeadlineReports())
+	require.Empty(t, posts)
+	warnings, err := decodeSLAWarnings(store.values[SLAWarningsKey])
+	require.NoError(t, err)
+	assert.Contains(t, warnings, slaBreachKey("2", slaStageTriage))
+
+	// Once the report at risk misses the SLA, it is announced as a breach and its warning is forgotten
+	stubHackeroneReportList(p,
+		newReportJSON("1", "critical", now.Add(-10*time.Hour)),
+		newReportJSON("2", "critical", now.Add(-5*time.Hour)),
+	)
+	require.NoError(t, p.notifyMissedDeadlineReports())
+	require.Len(t, posts, 1)
+	assert.Contains(t, posts[0].Message, "Missed SLA Deadline - New Reports")
+	assert.Equal(t, "Report 2", posts[0].Attachments()[0].Title)
+	warnings, err = decodeSLAWarnings(store.values[SLAWarningsKey])
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func Test_notifyMissedDeadlineReportsWithoutSubscriptions(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("GetChannelByNameForTeamName", "security", "triage", false).Return(&model.Channel{Id: "triage-channel"}, nil)
+	p.SetAPI(mockPluginAPI)
+	config := &configuration{HackeroneSLANew: 1, HackeroneSLAEscalations: "triage 0 channel:security/triage"}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+
+	stubHackeroneReportList(p, newReportJSON("1", "high", time.Now().Add(-3*24*time.Hour)))
+
+	posts := []*model.Post{}
+	mockPluginAPI.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		posts = append(posts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+
+	require.NoError(t, p.notifyMissedDeadlineReports())
+
+	// Breaches are escalated even though no channel is subscribed
+	require.Len(t, posts, 1)
+	assert.Equal(t, "triage-channel", posts[0].ChannelId)
+	assert.Contains(t, posts[0].Message, "SLA Escalation (level 1) - New Reports")
+}
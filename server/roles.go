@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	RoleViewer  = "viewer"
+	RoleTriager = "triager"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+
+	// defaultRole is given to users added without an explicit role.
+	defaultRole = RoleViewer
+	// legacyRole is given to users who were allowlisted before roles existed. They keep every
+	// ability they had except managing permissions, which is now reserved to admins.
+	legacyRole = RoleManager
+)
+
+// roleLevels orders the roles, every role includes the abilities of the roles below it:
+//   - viewer: report, reports and stats
+//   - triager: plus state changes and comments on reports
+//   - manager: plus subscriptions and bounties
+//   - admin: plus permissions and the audit log
+var roleLevels = map[string]int{
+	RoleViewer:  1,
+	RoleTriager: 2,
+	RoleManager: 3,
+	RoleAdmin:   4,
+}
+
+var roleNames = []string{RoleViewer, RoleTriager, RoleManager, RoleAdmin}
+
+func isValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// roleIncludes reports whether role grants at least the abilities of required.
+func roleIncludes(role string, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+// requiredRole returns the minimum role needed to run a /hackerone subcommand.
+func requiredRole(command string, split []string) string {
+	switch command {
+	case cmdReportKey, cmdReportsKey, cmdStatsKey, cmdSLAKey:
+		return RoleViewer
+	case cmdSubscribeKey:
+		if len(split) > 0 && split[0] == "list" {
+			return RoleViewer
+		}
+		return RoleManager
+	case cmdMetricsKey:
+		if isMetricsBackfill(split) {
+			return RoleAdmin
+		}
+		return RoleViewer
+	case cmdPermissionsKey, cmdAuditKey:
+		return RoleAdmin
+	default:
+		return RoleViewer
+	}
+}
+
+// GetUserRole returns the role of the user, or an empty string if the user is not allowed to
+// run any /hackerone command. System administrators always have the admin role.
+func (p *Plugin) GetUserRole(userID string) (string, error) {
+	role, _, err := p.resolveUserRole(userID)
+	return role, err
+}
+
+// resolveUserRole returns the highest role of the user together with a description of the
+// grant it comes from: system administrator, direct user grant, or a group, team or channel grant.
+func (p *Plugin) resolveUserRole(userID string) (string, string, error) {
+	isAdmin, err := p.IsAdmin(userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if isAdmin {
+		return RoleAdmin, "system administrator", nil
+	}
+
+	role, source := "", ""
+	perms, err := p.GetPermissions()
+	if err != nil {
+		return "", "", fmt.Errorf(
+			"failed to obtain information about user `%s`: %w", userID, err)
+	}
+
+	if perm := findPermission(perms, userID); perm != nil {
+		role = perm.userRole()
+		source = "user"
+	}
+
+	grantedRole, grant, err := p.resolveGrantedRole(userID)
+	if err != nil {
+		return "", "", fmt.Errorf(
+			"failed to obtain the permission grants of user `%s`: %w", userID, err)
+	}
+
+	if roleLevels[grantedRole] > roleLevels[role] {
+		role = grantedRole
+		source = grant.Key()
+	}
+
+	if role != "" {
+		p.API.LogDebug(
+			fmt.Sprintf("UserID `%s` is authorized with the `%s` role on basis of the `%s` permission grant", userID, role, source))
+	}
+	return role, source, nil
+}
+
+// HasRole reports whether the user has at least the required role.
+func (p *Plugin) HasRole(userID string, required string) (bool, error) {
+	role, err := p.GetUserRole(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return role != "" && roleIncludes(role, required), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_requiredRole(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		split   []string
+		want    string
+	}{
+		{name: "report", command: cmdReportKey, split: []string{"123"}, want: RoleViewer},
+		{name: "reports", command: cmdReportsKey, split: []string{"new"}, want: RoleViewer},
+		{name: "subscriptions list", command: cmdSubscribeKey, split: []string{"list"}, want: RoleViewer},
+		{name: "subscriptions add", command: cmdSubscribeKey, split: []string{"add"}, want: RoleManager},
+		{name: "subscriptions without subcommand", command: cmdSubscribeKey, split: []string{}, want: RoleManager},
+		{name: "permissions list", command: cmdPermissionsKey, split: []string{"list"}, want: RoleAdmin},
+		{name: "audit", command: cmdAuditKey, split: []string{}, want: RoleAdmin},
+		{name: "sla", command: cmdSLAKey, split: []string{"--at-risk"}, want: RoleViewer},
+		{name: "metrics", command: cmdMetricsKey, split: []string{"--by", "asset"}, want: RoleViewer},
+		{name: "metrics backfill", command: cmdMetricsKey, split: []string{"backfill"}, want: RoleAdmin},
+		{name: "metrics backfill after flags", command: cmdMetricsKey, split: []string{"--period", "90d", "backfill"}, want: RoleAdmin},
+		{name: "metrics backfill between flags", command: cmdMetricsKey, split: []string{"--by", "asset", "backfill", "--period=90d"}, want: RoleAdmin},
+		{name: "unknown command", command: "unknown", split: []string{}, want: RoleViewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, requiredRole(tt.command, tt.split))
+		})
+	}
+}
+
+func Test_roleIncludes(t *testing.T) {
+	assert.True(t, roleIncludes(RoleAdmin, RoleManager))
+	assert.True(t, roleIncludes(RoleTriager, RoleTriager))
+	assert.False(t, roleIncludes(RoleViewer, RoleTriager))
+	assert.False(t, roleIncludes(RoleManager, RoleAdmin))
+	assert.False(t, roleIncludes("", RoleViewer))
+}
+
+func Test_GetUserRole(t *testing.T) {
+	perms, _ := json.Marshal([]*Permission{{UserID: "user1", Role: RoleTriager}, {UserID: "user2"}})
+
+	setup := func() *Plugin {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		mockPluginAPI.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: "system_user system_admin"}, nil)
+		for _, id := range []string{"user1", "user2", "user3"} {
+			mockPluginAPI.On("GetUser", id).Return(&model.User{Id: id, Roles: "system_user"}, nil)
+		}
+		mockPluginAPI.On("KVGet", PermissionsKey).Return(perms, nil)
+		mockPluginAPI.On("KVGet", PermissionGrantsKey).Return(nil, nil)
+		mockPluginAPI.On("LogDebug", mock.Anything).Return()
+		p.SetAPI(mockPluginAPI)
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		want   string
+	}{
+		{name: "system admin", userID: "admin", want: RoleAdmin},
+		{name: "user with a role", userID: "user1", want: RoleTriager},
+		{name: "allowlisted user without a role", userID: "user2", want: legacyRole},
+		{name: "user not allowlisted", userID: "user3", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := setup().GetUserRole(tt.userID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, role)
+		})
+	}
+}
+
+func Test_AllowPermission_unknownRole(t *testing.T) {
+	p := &Plugin{}
+	err := p.AllowPermission("user1", "admin", "superuser", 0)
+	require.Error(t, err)
+	assert.Equal(t, "unknown role `superuser`. Available roles are: viewer, triager, manager, admin", err.Error())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	zeroWidthSpace = "\u200b"
+)
+
+var (
+	// mentionPattern matches @user, @all, @channel, @here and ~channel references. A zero width
+	// space is inserted after the sigil so the server no longer resolves them.
+	mentionPattern = regexp.MustCompile(`([@~])([\p{L}\p{N}_.\-])`)
+
+	// markdownEscaper escapes every character that can start inline markdown formatting,
+	// including links, images, emphasis, code spans, tables and headings.
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		"`", "\\`",
+		`*`, `\*`,
+		`_`, `\_`,
+		`[`, `\[`,
+		`]`, `\]`,
+		`(`, `\(`,
+		`)`, `\)`,
+		`<`, `\<`,
+		`>`, `\>`,
+		`#`, `\#`,
+		`|`, `\|`,
+		`!`, `\!`,
+		`~`, `\~`,
+	)
+
+	// linkEscaper only escapes link and image syntax, leaving the rest of the markdown intact.
+	linkEscaper = strings.NewReplacer(
+		`[`, `\[`,
+		`]`, `\]`,
+	)
+
+	backtickRun = regexp.MustCompile("`+")
+)
+
+// neutraliseMentions prevents researcher controlled text from notifying users or channels.
+func neutraliseMentions(text string) string {
+	return mentionPattern.ReplaceAllString(text, "$1"+zeroWidthSpace+"$2")
+}
+
+// singleLine collapses line breaks so a value cannot inject additional markdown blocks.
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// sanitizeInline makes researcher controlled text safe to embed in a markdown line, such as
+// a report title or a name used as link text.
+func sanitizeInline(text string) string {
+	return neutraliseMentions(markdownEscaper.Replace(singleLine(text)))
+}
+
+// sanitizePlain makes researcher controlled text safe for places that are not rendered as
+// markdown, such as the author name of an attachment.
+func sanitizePlain(text string) string {
+	return neutraliseMentions(singleLine(text))
+}
+
+// sanitizeMarkdown keeps the formatting of multi-line researcher content, such as the
+// vulnerability information, but disables mentions, channel links, links and images.
+func sanitizeMarkdown(text string) string {
+	return neutraliseMentions(linkEscaper.Replace(text))
+}
+
+// codeBlock wraps text in a fenced code block whose fence is longer than any run of backticks
+// inside the text, so the content can never close the block early.
+func codeBlock(text string) string {
+	longest := 0
+	for _, run := range backtickRun.FindAllString(text, -1) {
+		if len(run) > longest {
+			longest = len(run)
+		}
+	}
+	fenceLength := 3
+	if longest >= fenceLength {
+		fenceLength = longest + 1
+	}
+	fence := strings.Repeat("`", fenceLength)
+	return "\n" + fence + "\n" + text + "\n" + fence + "\n"
+}
+
+// hackeroneURL builds a link to a path on hackerone.com, escaping the researcher controlled
+// segment so it cannot point elsewhere or break out of a markdown link.
+func hackeroneURL(prefix string, segment string) string {
+	escaped := strings.NewReplacer("(", "%28", ")", "%29").Replace(url.PathEscape(segment))
+	return "https://hackerone.com/" + prefix + escaped
+}
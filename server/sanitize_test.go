@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sanitizeInline(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain title",
+			input: "Stored XSS in profile page",
+			want:  "Stored XSS in profile page",
+		},
+		{
+			name:  "channel wide mentions",
+			input: "@all @channel @here please look",
+			want:  "@\u200ball @\u200bchannel @\u200bhere please look",
+		},
+		{
+			name:  "user mention and channel link",
+			input: "ping @sysadmin in ~town-square",
+			want:  "ping @\u200bsysadmin in \\~\u200btown-square",
+		},
+		{
+			name:  "spoofed link",
+			input: "[Approve bounty](https://evil.example.com)",
+			want:  "\\[Approve bounty\\]\\(https://evil.example.com\\)",
+		},
+		{
+			name:  "image tracking pixel",
+			input: "![x](https://evil.example.com/pixel.png)",
+			want:  "\\!\\[x\\]\\(https://evil.example.com/pixel.png\\)",
+		},
+		{
+			name:  "formatting and headings",
+			input: "# **Critical** `rm -rf` _now_ | table",
+			want:  "\\# \\*\\*Critical\\*\\* \\`rm -rf\\` \\_now\\_ \\| table",
+		},
+		{
+			name:  "line breaks cannot start new blocks",
+			input: "Title\n\n```\n@all\n#### Fake heading",
+			want:  "Title \\`\\`\\` @\u200ball \\#\\#\\#\\# Fake heading",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeInline(tt.input))
+		})
+	}
+}
+
+func Test_sanitizePlain(t *testing.T) {
+	assert.Equal(t, "Eve @\u200ball *hacker*", sanitizePlain("Eve\n@all *hacker*"))
+}
+
+func Test_sanitizeMarkdown(t *testing.T) {
+	input := "## Steps\n1. Visit [the page](https://evil.example.com)\n2. Tell @channel and ~security\n```\ncurl -v\n```"
+	want := "## Steps\n1. Visit \\[the page\\](https://evil.example.com)\n2. Tell @\u200bchannel and ~\u200bsecurity\n```\ncurl -v\n```"
+	assert.Equal(t, want, sanitizeMarkdown(input))
+}
+
+func Test_codeBlock(t *testing.T) {
+	t.Run("regular content", func(t *testing.T) {
+		assert.Equal(t, "\n```\nhello\n```\n", codeBlock("hello"))
+	})
+	t.Run("content trying to close the fence", func(t *testing.T) {
+		got := codeBlock("done\n```\n@all # escaped\n```")
+		assert.True(t, strings.HasPrefix(got, "\n````\n"))
+		assert.True(t, strings.HasSuffix(got, "\n````\n"))
+	})
+	t.Run("content with longer backtick runs", func(t *testing.T) {
+		got := codeBlock("`````")
+		assert.Equal(t, "\n``````\n`````\n``````\n", got)
+	})
+}
+
+func Test_hackeroneURL(t *testing.T) {
+	assert.Equal(t, "https://hackerone.com/reports/123", hackeroneURL("reports/", "123"))
+	assert.Equal(t, "https://hackerone.com/..%2Fevil%29%20%5Bx%5D", hackeroneURL("", "../evil) [x]"))
+}
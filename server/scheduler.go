@@ -12,8 +12,13 @@ import (
 )
 
 const (
-	HackeroneNewActivity    = "new-activity"
-	HackeroneMissedDeadline = "missed-deadline"
+	HackeroneNewActivity          = "new-activity"
+	HackeroneMissedDeadline       = "missed-deadline"
+	HackeronePermissionsExpired   = "permissions-expired"
+	HackeroneSubscriptionsCleanup = "subscriptions-cleanup"
+
+	permissionsExpiryInterval    = 15 * time.Minute
+	subscriptionsCleanupInterval = time.Hour
 )
 
 type TaskFunc func()
@@ -57,6 +62,22 @@ func (p *Plugin) createHackeroneRecurring() {
 		p.scheduledJobs = append(p.scheduledJobs, missedDeadlineJob)
 	}
 
+	permissionsExpiredJob, err := p.createNewJob(HackeronePermissionsExpired, func() { p.pruneExpiredPermissions() }, permissionsExpiryInterval)
+	if err != nil {
+		p.API.LogError("Error while scheduling Hackerone job to prune expired permissions", "err", err.Error())
+	}
+	if permissionsExpiredJob != nil {
+		p.scheduledJobs = append(p.scheduledJobs, permissionsExpiredJob)
+	}
+
+	subscriptionsCleanupJob, err := p.createNewJob(HackeroneSubscriptionsCleanup, func() { p.cleanupSubscriptions() }, subscriptionsCleanupInterval)
+	if err != nil {
+		p.API.LogError("Error while scheduling Hackerone job to clean up subscriptions", "err", err.Error())
+	}
+	if subscriptionsCleanupJob != nil {
+		p.scheduledJobs = append(p.scheduledJobs, subscriptionsCleanupJob)
+	}
+
 }
 
 func (p *Plugin) cancelHackeroneRecurring() {
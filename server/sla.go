@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// slaStage is a step of the report lifecycle with its own SLA target.
+type slaStage string
+
+const (
+	// slaStageTriage covers new reports waiting to be triaged.
+	slaStageTriage slaStage = "triage"
+	// slaStageBounty covers triaged reports waiting for a bounty.
+	slaStageBounty slaStage = "bounty"
+	// slaStageResolve covers triaged reports waiting to be resolved.
+	slaStageResolve slaStage = "resolve"
+	// slaStageFirstResponse covers filed reports waiting for a first response of the team.
+	slaStageFirstResponse slaStage = "first-response"
+)
+
+var slaStages = []slaStage{slaStageTriage, slaStageBounty, slaStageResolve, slaStageFirstResponse}
+
+var slaStageNames = map[slaStage]string{
+	slaStageTriage:        "Triage",
+	slaStageBounty:        "Bounty",
+	slaStageResolve:       "Resolve",
+	slaStageFirstResponse: "First response",
+}
+
+// slaStageList lists the stages for error messages, eg: triage, bounty, resolve.
+func slaStageList() string {
+	names := []string{}
+	for _, stage := range slaStages {
+		names = append(names, string(stage))
+	}
+	return strings.Join(names, ", ")
+}
+
+// slaPolicy holds the SLA target of each stage per report severity. Severities and stages missing
+// from the policy use the default targets, which come from the SLA settings in days.
+type slaPolicy struct {
+	targets  map[string]map[slaStage]time.Duration
+	defaults map[slaStage]time.Duration
+	// calendar defines the working time counted by SLA clocks, nil to count all the time.
+	calendar *slaCalendar
+	// warning is the threshold of the at risk alerts, nil to disable them.
+	warning *slaWarning
+	// escalations are the escalation levels of each stage, ordered by delay.
+	escalations map[slaStage][]*slaEscalationLevel
+}
+
+// slaWarning is the threshold from which a report is at risk of missing its target, either a
+// time before the deadline or a share of the target consumed.
+type slaWarning struct {
+	before  time.Duration
+	percent int
+}
+
+// parseSLAWarning parses a warning threshold such as 24h before the deadline or 80% of the target
+// consumed. It returns nil when the threshold is empty.
+func parseSLAWarning(value string) (*slaWarning, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(value, "%")))
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, errors.Errorf("invalid SLA warning threshold `%s`, the share of the target must be between 1%% and 99%%", value)
+		}
+		return &slaWarning{percent: percent}, nil
+	}
+
+	before, err := parseDuration(value)
+	if err != nil {
+		return nil, errors.Errorf("invalid SLA warning threshold `%s`, use a time before the deadline such as 24h or a share of the target such as 80%%", value)
+	}
+	return &slaWarning{before: before}, nil
+}
+
+// newSLAPolicy parses the SLA policies setting, one line per severity, eg:
+// critical: triage=4h bounty=2d resolve=7d
+func newSLAPolicy(text string, defaults map[slaStage]time.Duration) (*slaPolicy, error) {
+	policy := &slaPolicy{
+		targets:  map[string]map[slaStage]time.Duration{},
+		defaults: defaults,
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		severity := strings.ToLower(strings.TrimSpace(parts[0]))
+		if _, ok := severityLevels[severity]; !ok {
+			return nil, errors.Errorf("invalid SLA policy `%s`: unknown severity `%s`. Available severities are: none, low, medium, high, critical", line, severity)
+		}
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.Errorf("invalid SLA policy `%s`: expected targets such as `%s: triage=4h bounty=2d resolve=7d`", line, severity)
+		}
+		if _, ok := policy.targets[severity]; ok {
+			return nil, errors.Errorf("invalid SLA policy `%s`: the severity `%s` is defined more than once", line, severity)
+		}
+
+		targets := map[slaStage]time.Duration{}
+		for _, term := range strings.Fields(parts[1]) {
+			kv := strings.SplitN(term, "=", 2)
+			stage := slaStage(strings.ToLower(kv[0]))
+			if !containsStage(slaStages, stage) {
+				return nil, errors.Errorf("invalid SLA policy `%s`: unknown stage `%s`. Available stages are: %s", line, kv[0], slaStageList())
+			}
+			if len(kv) != 2 {
+				return nil, errors.Errorf("invalid SLA policy `%s`: missing the target of the stage `%s`", line, stage)
+			}
+			target, err := parseDuration(kv[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid SLA policy `%s`", line)
+			}
+			targets[stage] = target
+		}
+		policy.targets[severity] = targets
+	}
+
+	return policy, nil
+}
+
+func containsStage(stages []slaStage, stage slaStage) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Target returns the time allowed for a report of the given severity to leave the stage.
+func (s *slaPolicy) Target(severity string, stage slaStage) time.Duration {
+	if target, ok := s.targets[severity][stage]; ok {
+		return target
+	}
+	return s.defaults[stage]
+}
+
+// MinTarget returns the shortest target of the stage across severities, which bounds the reports
+// that may have missed it. Stages without a target are not measured.
+func (s *slaPolicy) MinTarget(stage slaStage) time.Duration {
+	min := s.defaults[stage]
+	for _, targets := range s.targets {
+		if target, ok := targets[stage]; ok && target > 0 && (min <= 0 || target < min) {
+			min = target
+		}
+	}
+	return min
+}
+
+// slaStatus is where a report stands in an SLA stage.
+type slaStatus struct {
+	Stage    slaStage
+	Severity string
+	Target   time.Duration
+	// EnteredAt is when the report entered the stage.
+	EnteredAt time.Time
+	// ExitedAt is when the report left the stage, zero while it is still in the stage.
+	ExitedAt time.Time
+
+	calendar *slaCalendar
+}
+
+// Elapsed returns the working time the report spent in the stage so far.
+func (s *slaStatus) Elapsed(now time.Time) time.Duration {
+	end := now
+	if !s.ExitedAt.IsZero() {
+		end = s.ExitedAt
+	}
+	return s.calendar.WorkingTime(s.EnteredAt, end)
+}
+
+// Remaining returns the working time left before the target is missed, negative once it was missed.
+func (s *slaStatus) Remaining(now time.Time) time.Duration {
+	return s.calendar.workingTarget(s.Target) - s.Elapsed(now)
+}
+
+// Deadline returns when the target is missed.
+func (s *slaStatus) Deadline() time.Time {
+	return s.calendar.Deadline(s.EnteredAt, s.Target)
+}
+
+// FormatDuration formats a duration of working time, eg: the time remaining.
+func (s *slaStatus) FormatDuration(d time.Duration) string {
+	return s.calendar.FormatDuration(d)
+}
+
+// Breached reports whether the report spent more time in the stage than its target.
+func (s *slaStatus) Breached(now time.Time) bool {
+	return s.Remaining(now) < 0
+}
+
+// parseReportTime parses a timestamp of the Hackerone API, the zero time when it is not set.
+func parseReportTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// stageInterval returns when the report entered and left the stage. A report enters the triage
+// stage when it is submitted and leaves it when it is triaged or closed. The bounty and resolve
+// stages start when the report is triaged. The bounty stage ends when a bounty is awarded or the
+// report is closed, as programs may resolve reports without a bounty, and the resolve stage ends
+// when the report is closed. The first response stage runs from the filing of the report until the
+// first response of the team, as recorded from the activities. ok is false when the report never
+// entered the stage.
+func stageInterval(report *Report, stage slaStage) (time.Time, time.Time, bool) {
+	createdAt := parseReportTime(report.Attributes.CreatedAt)
+	triagedAt := parseReportTime(report.Attributes.TriagedAt)
+	closedAt := parseReportTime(report.Attributes.ClosedAt)
+
+	switch stage {
+	case slaStageTriage:
+		if createdAt.IsZero() {
+			return time.Time{}, time.Time{}, false
+		}
+		exitedAt := triagedAt
+		if exitedAt.IsZero() {
+			exitedAt = closedAt
+		}
+		return createdAt, exitedAt, true
+	case slaStageBounty:
+		if triagedAt.IsZero() {
+			return time.Time{}, time.Time{}, false
+		}
+		bountyAwardedAt := parseReportTime(report.Attributes.BountyAwardedAt)
+		if bountyAwardedAt.IsZero() && !closedAt.IsZero() {
+			// Closed reports are not waiting for a bounty anymore
+			return triagedAt, closedAt, true
+		}
+		return triagedAt, bountyAwardedAt, true
+	case slaStageResolve:
+		if triagedAt.IsZero() {
+			return time.Time{}, time.Time{}, false
+		}
+		return triagedAt, closedAt, true
+	case slaStageFirstResponse:
+		if report.firstResponse == nil {
+			return time.Time{}, time.Time{}, false
+		}
+		exitedAt := time.Time{}
+		if report.firstResponse.RespondedAt != 0 {
+			exitedAt = model.GetTimeForMillis(report.firstResponse.RespondedAt)
+		} else if !closedAt.IsZero() {
+			exitedAt = closedAt
+		}
+		return model.GetTimeForMillis(report.firstResponse.FiledAt), exitedAt, true
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// WarningHorizon returns how long reports must have been in the stage to be at risk of missing
+// the shortest target of the stage, which bounds the reports to check for warnings.
+func (s *slaPolicy) WarningHorizon(stage slaStage) time.Duration {
+	budget := s.calendar.workingTarget(s.MinTarget(stage))
+	switch {
+	case s.warning == nil:
+		return budget
+	case s.warning.percent > 0:
+		return budget * time.Duration(s.warning.percent) / 100
+	case budget > s.warning.before:
+		return budget - s.warning.before
+	default:
+		return 0
+	}
+}
+
+// AtRisk reports whether the report is still in the stage and close to missing its target.
+func (s *slaPolicy) AtRisk(status *slaStatus, now time.Time) bool {
+	if s.warning == nil || !status.ExitedAt.IsZero() || status.Breached(now) {
+		return false
+	}
+	if s.warning.percent > 0 {
+		budget := status.calendar.workingTarget(status.Target)
+		return status.Elapsed(now)*100 >= budget*time.Duration(s.warning.percent)
+	}
+	return status.Remaining(now) <= s.warning.before
+}
+
+// Evaluate measures the time the report spent in the stage against the target of its severity.
+// ok is false when the report never entered the stage, or when its severity has no target for
+// the stage.
+func (s *slaPolicy) Evaluate(report *Report, stage slaStage) (*slaStatus, bool) {
+	enteredAt, exitedAt, ok := stageInterval(report, stage)
+	if !ok {
+		return nil, false
+	}
+
+	severity := reportSeverity(report)
+	target := s.Target(severity, stage)
+	if target <= 0 {
+		return nil, false
+	}
+	return &slaStatus{
+		Stage:     stage,
+		Severity:  severity,
+		Target:    target,
+		EnteredAt: enteredAt,
+		ExitedAt:  exitedAt,
+		calendar:  s.calendar,
+	}, true
+}
+
+// describeSLAStatus explains the target of the report and the time remaining, or by how long
+// the target was missed.
+func describeSLAStatus(status *slaStatus, now time.Time) string {
+	remaining := status.Remaining(now)
+	msg := fmt.Sprintf("%s target for %s severity: %s, ", slaStageNames[status.Stage], status.Severity, formatSLATarget(status.Target))
+	if remaining < 0 {
+		msg += "overdue by " + status.FormatDuration(-remaining)
+	} else {
+		msg += status.FormatDuration(remaining) + " remaining"
+	}
+	if status.calendar != nil {
+		msg += " (business time)"
+	}
+	return msg
+}
+
+// formatSLATarget formats a target as configured, eg: 4h, 2d or 1d12h.
+func formatSLATarget(target time.Duration) string {
+	return (*slaCalendar)(nil).FormatDuration(target)
+}
+
+// defaultSLATargets converts the SLA settings in days into the default targets of each stage.
+func (c *configuration) defaultSLATargets() map[slaStage]time.Duration {
+	return map[slaStage]time.Duration{
+		slaStageTriage:  time.Duration(c.HackeroneSLANew) * 24 * time.Hour,
+		slaStageBounty:  time.Duration(c.HackeroneSLABounty) * 24 * time.Hour,
+		slaStageResolve: time.Duration(c.HackeroneSLATriaged) * 24 * time.Hour,
+		// The first response is only measured when it has a target
+		slaStageFirstResponse: time.Duration(c.HackeroneSLAFirstResponse) * 24 * time.Hour,
+	}
+}
+
+// newSLAPolicyFromSettings computes the SLA policy and calendar from the SLA settings.
+func (c *configuration) newSLAPolicyFromSettings() (*slaPolicy, error) {
+	policy, err := newSLAPolicy(c.HackeroneSLAPolicies, c.defaultSLATargets())
+	if err != nil {
+		return nil, err
+	}
+
+	calendar, err := newSLACalendar(c.HackeroneSLAWorkingDays, c.HackeroneSLAWorkingHours, c.HackeroneSLATimezone, c.HackeroneSLAHolidays)
+	if err != nil {
+		return nil, err
+	}
+	policy.calendar = calendar
+
+	warning, err := parseSLAWarning(c.HackeroneSLAWarningThreshold)
+	if err != nil {
+		return nil, err
+	}
+	policy.warning = warning
+
+	escalations, err := parseSLAEscalations(c.HackeroneSLAEscalations)
+	if err != nil {
+		return nil, err
+	}
+	policy.escalations = escalations
+
+	return policy, nil
+}
+
+// getSLAPolicy returns the SLA policy computed when the configuration changed, or the default
+// targets when it was not computed.
+func (c *configuration) getSLAPolicy() *slaPolicy {
+	if c.slaPolicy != nil {
+		return c.slaPolicy
+	}
+	return &slaPolicy{defaults: c.defaultSLATargets()}
+}
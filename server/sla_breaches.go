@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SLABreachesKey holds the SLA breaches announced in the subscribed channels, by report and stage.
+	SLABreachesKey = "sla-breaches"
+	// SLAWarningsKey holds the reports announced at risk of missing their SLA, by report and stage.
+	SLAWarningsKey = "sla-warnings"
+
+	slaResolvedColor = "#3DB887"
+)
+
+// SLABreach records that a report missed the SLA of a stage and when it was announced.
+type SLABreach struct {
+	ReportID string
+	Stage    slaStage
+	// BreachedAt is when the breach was first announced.
+	BreachedAt int64
+	// NotifiedAt is when the breach was last announced, either as a new breach or as a reminder.
+	NotifiedAt int64
+	// EscalationLevel is the number of escalation levels the breach was escalated to.
+	EscalationLevel int
+	// Scope is the subscription the breach was announced to when it overrides the SLA settings,
+	// empty for the subscriptions using the SLA settings.
+	Scope string `json:",omitempty"`
+}
+
+// SLAWarning records that a report was announced at risk of missing the SLA of a stage.
+type SLAWarning struct {
+	ReportID string
+	Stage    slaStage
+	WarnedAt int64
+	// Scope is the subscription the warning was announced to when it overrides the SLA settings,
+	// empty for the subscriptions using the SLA settings.
+	Scope string `json:",omitempty"`
+}
+
+// slaEvaluation is a report along with where it stands in an SLA stage.
+type slaEvaluation struct {
+	report Report
+	status *slaStatus
+	// escalationLevel is the number of escalation levels a resolved breach was escalated to.
+	escalationLevel int
+}
+
+func slaBreachKey(reportID string, stage slaStage) string {
+	return reportID + "/" + string(stage)
+}
+
+func decodeSLABreaches(value []byte) (map[string]*SLABreach, error) {
+	breaches := map[string]*SLABreach{}
+	if value == nil {
+		return breaches, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&breaches); err != nil {
+		return nil, errors.Wrap(err, "could not properly decode SLA breaches key")
+	}
+
+	return breaches, nil
+}
+
+func (p *Plugin) getSLABreaches() (map[string]*SLABreach, error) {
+	value, appErr := p.API.KVGet(SLABreachesKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get SLA breaches from KVStore")
+	}
+
+	return decodeSLABreaches(value)
+}
+
+// modifySLABreaches atomically applies modify to the SLA breaches, modify returns whether the
+// breaches changed.
+func (p *Plugin) modifySLABreaches(modify func(breaches map[string]*SLABreach) bool) error {
+	return p.atomicModify(SLABreachesKey, func(value []byte) ([]byte, error) {
+		breaches, err := decodeSLABreaches(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !modify(breaches) {
+			return nil, nil
+		}
+
+		b, err := json.Marshal(breaches)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting SLA breaches to json")
+		}
+		return b, nil
+	})
+}
+
+func decodeSLAWarnings(value []byte) (map[string]*SLAWarning, error) {
+	warnings := map[string]*SLAWarning{}
+	if value == nil {
+		return warnings, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&warnings); err != nil {
+		return nil, errors.Wrap(err, "could not properly decode SLA warnings key")
+	}
+
+	return warnings, nil
+}
+
+// modifySLAWarnings atomically applies modify to the SLA warnings, modify returns whether the
+// warnings changed.
+func (p *Plugin) modifySLAWarnings(modify func(warnings map[string]*SLAWarning) bool) error {
+	return p.atomicModify(SLAWarningsKey, func(value []byte) ([]byte, error) {
+		warnings, err := decodeSLAWarnings(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !modify(warnings) {
+			return nil, nil
+		}
+
+		b, err := json.Marshal(warnings)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting SLA warnings to json")
+		}
+		return b, nil
+	})
+}
+
+// trackSLAWarnings compares the reports which are currently at risk of missing the SLA of the
+// stage with the warnings already announced to the scope, and returns the reports to warn about.
+// Warnings of reports which are not at risk anymore, because they missed the SLA or left the
+// stage, are forgotten.
+func (p *Plugin) trackSLAWarnings(scope *slaScope, stage slaStage, atRisk []slaEvaluation, now time.Time) ([]slaEvaluation, error) {
+	newWarnings := []slaEvaluation{}
+	err := p.modifySLAWarnings(func(warnings map[string]*SLAWarning) bool {
+		newWarnings = []slaEvaluation{}
+		current := map[string]bool{}
+		changed := false
+		for _, e := range atRisk {
+			key := scope.breachKey(e.report.Id, stage)
+			current[key] = true
+			if _, ok := warnings[key]; ok {
+				continue
+			}
+			warnings[key] = &SLAWarning{
+				ReportID: e.report.Id,
+				Stage:    stage,
+				WarnedAt: model.GetMillisForTime(now),
+				Scope:    scope.id,
+			}
+			newWarnings = append(newWarnings, e)
+			changed = true
+		}
+		for key, warning := range warnings {
+			if warning.Stage == stage && warning.Scope == scope.id && !current[key] {
+				delete(warnings, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store SLA warnings")
+	}
+
+	return newWarnings, nil
+}
+
+// trackSLABreaches compares the reports which currently miss the SLA of the stage with the breaches
+// already announced to the scope. It returns the new breaches, the breaches due for a reminder,
+// and the reports which left the stage after missing its SLA.
+func (p *Plugin) trackSLABreaches(scope *slaScope, stage slaStage, breached []slaEvaluation, now time.Time) ([]slaEvaluation, []slaEvaluation, []slaEvaluation, error) {
+	breaches, err := p.getSLABreaches()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	reminderInterval := time.Duration(p.getConfiguration().HackeroneSLABreachReminderDays) * oneDay
+	nowMillis := model.GetMillisForTime(now)
+	newBreaches, reminders, resolved := []slaEvaluation{}, []slaEvaluation{}, []slaEvaluation{}
+	current := map[string]bool{}
+	for _, e := range breached {
+		key := scope.breachKey(e.report.Id, stage)
+		current[key] = true
+		breach, ok := breaches[key]
+		switch {
+		case !ok:
+			newBreaches = append(newBreaches, e)
+		case reminderInterval > 0 && now.Sub(model.GetTimeForMillis(breach.NotifiedAt)) >= reminderInterval:
+			reminders = append(reminders, e)
+		}
+	}
+
+	// Breached reports missing from the current list either left the stage, or were not listed,
+	// eg: because their severity changed. They are fetched together to tell them apart, and those
+	// which moved to a state the SLA checks do not list, or cannot be found anymore, are forgotten
+	// rather than checked forever.
+	missing := map[string]*SLABreach{}
+	for key, breach := range breaches {
+		if breach.Stage != stage || breach.Scope != scope.id || current[key] {
+			continue
+		}
+		missing[key] = breach
+	}
+	forgotten := map[string]bool{}
+	if len(missing) > 0 {
+		var left []slaEvaluation
+		left, forgotten, err = p.checkMissingSLABreaches(scope, stage, missing, now)
+		if err != nil {
+			p.API.LogWarn("Error while fetching the reports of SLA breaches", "error", err.Error())
+		}
+		resolved = append(resolved, left...)
+	}
+
+	err = p.modifySLABreaches(func(breaches map[string]*SLABreach) bool {
+		for _, e := range newBreaches {
+			breaches[scope.breachKey(e.report.Id, stage)] = &SLABreach{
+				ReportID:   e.report.Id,
+				Stage:      stage,
+				BreachedAt: nowMillis,
+				NotifiedAt: nowMillis,
+				Scope:      scope.id,
+			}
+		}
+		for _, e := range reminders {
+			if breach, ok := breaches[scope.breachKey(e.report.Id, stage)]; ok {
+				breach.NotifiedAt = nowMillis
+			}
+		}
+		for key := range forgotten {
+			delete(breaches, key)
+		}
+		return len(newBreaches) > 0 || len(reminders) > 0 || len(forgotten) > 0
+	})
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "could not store SLA breaches")
+	}
+
+	return newBreaches, reminders, resolved, nil
+}
+
+// checkMissingSLABreaches fetches the reports of the breaches, by key, which are missing from the
+// reports currently breaching the stage. It returns the reports which left the stage, and the
+// keys of the breaches to forget.
+func (p *Plugin) checkMissingSLABreaches(scope *slaScope, stage slaStage, missing map[string]*SLABreach, now time.Time) ([]slaEvaluation, map[string]bool, error) {
+	reportIDs := []string{}
+	for _, breach := range missing {
+		reportIDs = append(reportIDs, breach.ReportID)
+	}
+	sort.Strings(reportIDs)
+	reports, err := p.fetchSLAReportsByID(reportIDs, stage)
+	if err != nil {
+		return nil, map[string]bool{}, err
+	}
+
+	resolved := []slaEvaluation{}
+	forgotten := map[string]bool{}
+	for key, breach := range missing {
+		report, ok := reports[breach.ReportID]
+		if !ok {
+			// The report cannot be found in the program anymore
+			forgotten[key] = true
+			continue
+		}
+
+		status, ok := scope.policy.Evaluate(&report, stage)
+		switch {
+		case !ok:
+			forgotten[key] = true
+		case !status.ExitedAt.IsZero():
+			resolved = append(resolved, slaEvaluation{report: report, status: status, escalationLevel: breach.EscalationLevel})
+			forgotten[key] = true
+		case !listedInSLAStage(&report, stage):
+			forgotten[key] = true
+		case !status.Breached(now):
+			forgotten[key] = true
+		}
+	}
+	return resolved, forgotten, nil
+}
+
+// pruneSLABreachScopes forgets the breaches and warnings announced to subscriptions which were
+// removed, opted out of the SLA notifications or do not override the SLA settings anymore.
+func (p *Plugin) pruneSLABreachScopes(scopes []*slaScope) error {
+	active := map[string]bool{}
+	for _, scope := range scopes {
+		active[scope.id] = true
+	}
+	err := p.modifySLABreaches(func(breaches map[string]*SLABreach) bool {
+		changed := false
+		for key, breach := range breaches {
+			if !active[breach.Scope] {
+				delete(breaches, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+	return p.modifySLAWarnings(func(warnings map[string]*SLAWarning) bool {
+		changed := false
+		for key, warning := range warnings {
+			if !active[warning.Scope] {
+				delete(warnings, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// describeSLAResolution explains by how long a report which left a stage missed its target.
+func describeSLAResolution(status *slaStatus) string {
+	return fmt.Sprintf("Left the %s stage after %s, %s over the %s target for %s severity",
+		status.Stage, status.FormatDuration(status.Elapsed(status.ExitedAt)), status.FormatDuration(-status.Remaining(status.ExitedAt)),
+		formatSLATarget(status.Target), status.Severity)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_trackSLABreaches(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"))
+	mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"), mock.Anything, mock.Anything)
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{HackeroneSLANew: 1, HackeroneSLABounty: 1, HackeroneSLABreachReminderDays: 7})
+	stubHackeroneReports(p, map[string]string{
+		"2": `{"data":{"id":"2","attributes":{"state":"triaged","created_at":"2021-10-01T00:00:00Z","triaged_at":"2021-10-05T00:00:00Z"}}}`,
+		"3": `{"data":{"id":"3","attributes":{"state":"new","created_at":"2021-10-01T00:00:00Z"}}}`,
+		"5": `{"data":{"id":"5","attributes":{"state":"resolved","created_at":"2021-10-01T00:00:00Z","triaged_at":"2021-10-02T00:00:00Z","closed_at":"2021-10-12T00:00:00Z"}}}`,
+		"6": `{"data":{"id":"6","attributes":{"state":"needs-more-info","created_at":"2021-10-01T00:00:00Z","triaged_at":"2021-10-02T00:00:00Z"}}}`,
+	})
+	stub := p.httpClient.Transport
+	requests := []*http.Request{}
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests = append(requests, r)
+		return stub.RoundTrip(r)
+	})
+
+	evaluate := func(id string, createdAt string) slaEvaluation {
+		report := Report{Id: id}
+		report.Attributes.CreatedAt = createdAt
+		status, ok := p.getConfiguration().getSLAPolicy().Evaluate(&report, slaStageTriage)
+		require.True(t, ok)
+		return slaEvaluation{report: report, status: status}
+	}
+	ids := func(evaluations []slaEvaluation) []string {
+		result := []string{}
+		for _, e := range evaluations {
+			result = append(result, e.report.Id)
+		}
+		return result
+	}
+
+	global := &slaScope{policy: p.getConfiguration().getSLAPolicy()}
+	start := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	first := []slaEvaluation{evaluate("1", "2021-10-01T00:00:00Z"), evaluate("2", "2021-10-01T00:00:00Z"), evaluate("3", "2021-10-01T00:00:00Z")}
+
+	t.Run("New breaches are announced once", func(t *testing.T) {
+		newBreaches, reminders, resolved, err := p.trackSLABreaches(global, slaStageTriage, first, start)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1", "2", "3"}, ids(newBreaches))
+		assert.Empty(t, reminders)
+		assert.Empty(t, resolved)
+
+		newBreaches, reminders, resolved, err = p.trackSLABreaches(global, slaStageTriage, first, start.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, newBreaches)
+		assert.Empty(t, reminders)
+		assert.Empty(t, resolved)
+	})
+	t.Run("Reports which moved on are resolved after breach", func(t *testing.T) {
+		// Report 2 was triaged, report 3 is still new but was not listed, report 1 is not found anymore
+		requests = nil
+		newBreaches, reminders, resolved, err := p.trackSLABreaches(global, slaStageTriage, []slaEvaluation{evaluate("4", "2021-10-01T00:00:00Z")}, start.Add(48*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, []string{"1", "2", "3"}, requests[0].URL.Query()["filter[id][]"])
+		assert.Equal(t, []string{"4"}, ids(newBreaches))
+		assert.Empty(t, reminders)
+		assert.Equal(t, []string{"2"}, ids(resolved))
+		assert.Equal(t, "Left the triage stage after 4d, 3d over the 1d target for none severity", describeSLAResolution(resolved[0].status))
+
+		breaches, err := p.getSLABreaches()
+		require.NoError(t, err)
+		assert.Len(t, breaches, 2)
+		assert.Contains(t, breaches, slaBreachKey("3", slaStageTriage))
+		assert.Contains(t, breaches, slaBreachKey("4", slaStageTriage))
+	})
+	t.Run("Still open breaches are reminded periodically", func(t *testing.T) {
+		open := []slaEvaluation{evaluate("3", "2021-10-01T00:00:00Z"), evaluate("4", "2021-10-01T00:00:00Z")}
+		_, reminders, _, err := p.trackSLABreaches(global, slaStageTriage, open, start.Add(7*24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, ids(reminders))
+
+		_, reminders, _, err = p.trackSLABreaches(global, slaStageTriage, open, start.Add(8*24*time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, reminders)
+
+		breaches, err := p.getSLABreaches()
+		require.NoError(t, err)
+		assert.Equal(t, model.GetMillisForTime(start), breaches[slaBreachKey("3", slaStageTriage)].BreachedAt)
+		assert.Equal(t, model.GetMillisForTime(start.Add(7*24*time.Hour)), breaches[slaBreachKey("3", slaStageTriage)].NotifiedAt)
+	})
+	t.Run("Breaches of reports which are not listed anymore are forgotten", func(t *testing.T) {
+		evaluateBounty := func(id string) slaEvaluation {
+			report := Report{Id: id}
+			report.Attributes.State = "triaged"
+			report.Attributes.CreatedAt = "2021-10-01T00:00:00Z"
+			report.Attributes.TriagedAt = "2021-10-02T00:00:00Z"
+			status, ok := global.policy.Evaluate(&report, slaStageBounty)
+			require.True(t, ok)
+			return slaEvaluation{report: report, status: status}
+		}
+		newBreaches, _, _, err := p.trackSLABreaches(global, slaStageBounty, []slaEvaluation{evaluateBounty("5"), evaluateBounty("6")}, start)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"5", "6"}, ids(newBreaches))
+
+		// Report 5 was resolved without a bounty, report 6 waits for more information
+		_, _, resolved, err := p.trackSLABreaches(global, slaStageBounty, []slaEvaluation{}, start.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"5"}, ids(resolved))
+
+		breaches, err := p.getSLABreaches()
+		require.NoError(t, err)
+		assert.NotContains(t, breaches, slaBreachKey("5", slaStageBounty))
+		assert.NotContains(t, breaches, slaBreachKey("6", slaStageBounty))
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	holidayLayout = "2006-01-02"
+	oneDay        = 24 * time.Hour
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// slaCalendar defines the working time during which SLA clocks run. A nil calendar runs the
+// clocks around the clock.
+type slaCalendar struct {
+	location *time.Location
+	// workingDays lists the days of the week SLA clocks run on.
+	workingDays map[time.Weekday]bool
+	// start and end of the working hours, as offsets from midnight.
+	start time.Duration
+	end   time.Duration
+	// holidays are dates, in the calendar's time zone, on which SLA clocks do not run.
+	holidays map[string]bool
+}
+
+// newSLACalendar parses the SLA calendar settings. It returns nil when none of the settings is
+// set, as SLA clocks then run around the clock.
+func newSLACalendar(days string, hours string, timezone string, holidays string) (*slaCalendar, error) {
+	if strings.TrimSpace(days) == "" && strings.TrimSpace(hours) == "" && strings.TrimSpace(holidays) == "" {
+		return nil, nil
+	}
+
+	calendar := &slaCalendar{
+		location: time.UTC,
+		start:    0,
+		end:      oneDay,
+		holidays: map[string]bool{},
+	}
+
+	if timezone = strings.TrimSpace(timezone); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Errorf("invalid SLA time zone `%s`, use a time zone such as Europe/Berlin or America/New_York", timezone)
+		}
+		calendar.location = location
+	}
+
+	workingDays, err := parseWorkingDays(days)
+	if err != nil {
+		return nil, err
+	}
+	calendar.workingDays = workingDays
+
+	if hours = strings.TrimSpace(hours); hours != "" {
+		parts := strings.SplitN(hours, "-", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid SLA working hours `%s`, use a range such as 09:00-17:00", hours)
+		}
+		start, err := parseTimeOfDay(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, errors.Errorf("invalid SLA working hours `%s`, the working hours must end after they start", hours)
+		}
+		calendar.start, calendar.end = start, end
+	}
+
+	for _, line := range strings.Split(holidays, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// The date may be followed by the name of the holiday
+		date, err := time.Parse(holidayLayout, fields[0])
+		if err != nil {
+			return nil, errors.Errorf("invalid SLA holiday `%s`, use dates such as 2021-12-25", fields[0])
+		}
+		calendar.holidays[date.Format(holidayLayout)] = true
+	}
+
+	return calendar, nil
+}
+
+// parseWorkingDays parses days of the week such as `mon-fri` or `mon,tue,thu`. Every day is a
+// working day when days is empty.
+func parseWorkingDays(days string) (map[time.Weekday]bool, error) {
+	workingDays := map[time.Weekday]bool{}
+	days = strings.ToLower(strings.ReplaceAll(days, " ", ""))
+	if days == "" {
+		for _, d := range weekdays {
+			workingDays[d] = true
+		}
+		return workingDays, nil
+	}
+
+	for _, term := range strings.Split(days, ",") {
+		bounds := strings.SplitN(term, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, errors.Errorf("invalid SLA working day `%s`, use days such as mon-fri or mon,tue,thu", term)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, errors.Errorf("invalid SLA working day `%s`, use days such as mon-fri or mon,tue,thu", term)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			workingDays[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return workingDays, nil
+}
+
+// parseTimeOfDay parses a time of day such as 09:00 or 17:30, and 24:00 for the end of the day.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 2 {
+		hours, hErr := strconv.Atoi(parts[0])
+		minutes, mErr := strconv.Atoi(parts[1])
+		offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		if hErr == nil && mErr == nil && hours >= 0 && minutes >= 0 && minutes < 60 && offset <= oneDay {
+			return offset, nil
+		}
+	}
+	return 0, errors.Errorf("invalid time of day `%s`, use a time such as 09:00", value)
+}
+
+// dayLength returns the working time of a working day.
+func (c *slaCalendar) dayLength() time.Duration {
+	if c == nil {
+		return oneDay
+	}
+	return c.end - c.start
+}
+
+// isWorkingDay reports whether SLA clocks run on the day starting at midnight.
+func (c *slaCalendar) isWorkingDay(midnight time.Time) bool {
+	return c.workingDays[midnight.Weekday()] && !c.holidays[midnight.Format(holidayLayout)]
+}
+
+// workingTarget converts an SLA target into working time: each day of the target counts as a
+// working day, and the remaining hours as working hours up to a working day, so a longer target
+// never converts to less working time.
+func (c *slaCalendar) workingTarget(target time.Duration) time.Duration {
+	days := target / oneDay
+	hours := target % oneDay
+	if hours > c.dayLength() {
+		hours = c.dayLength()
+	}
+	return days*c.dayLength() + hours
+}
+
+// WorkingTime returns the working time between from and to.
+func (c *slaCalendar) WorkingTime(from time.Time, to time.Time) time.Duration {
+	if c == nil {
+		return to.Sub(from)
+	}
+	if !to.After(from) {
+		return -c.WorkingTime(to, from)
+	}
+
+	var total time.Duration
+	from, to = from.In(c.location), to.In(c.location)
+	for midnight := startOfDay(from); midnight.Before(to); midnight = nextDay(midnight) {
+		if !c.isWorkingDay(midnight) {
+			continue
+		}
+		start, end := c.workingHours(midnight)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// Deadline returns when the working time after from reaches the target.
+func (c *slaCalendar) Deadline(from time.Time, target time.Duration) time.Time {
+	if c == nil {
+		return from.Add(target)
+	}
+	if len(c.workingDays) == 0 {
+		return time.Time{}
+	}
+
+	remaining := c.workingTarget(target)
+	from = from.In(c.location)
+	for midnight := startOfDay(from); ; midnight = nextDay(midnight) {
+		if !c.isWorkingDay(midnight) {
+			continue
+		}
+		start, end := c.workingHours(midnight)
+		if start.Before(from) {
+			start = from
+		}
+		if !end.After(start) {
+			continue
+		}
+		available := end.Sub(start)
+		if available >= remaining {
+			return start.Add(remaining)
+		}
+		remaining -= available
+	}
+}
+
+// workingHours returns the start and end of the working hours of the day starting at midnight.
+func (c *slaCalendar) workingHours(midnight time.Time) (time.Time, time.Time) {
+	// The bounds are built from the time of day rather than added to midnight, as days are
+	// shorter or longer when the clocks change.
+	y, m, d := midnight.Date()
+	start := time.Date(y, m, d, int(c.start/time.Hour), int(c.start%time.Hour/time.Minute), 0, 0, c.location)
+	end := time.Date(y, m, d, int(c.end/time.Hour), int(c.end%time.Hour/time.Minute), 0, 0, c.location)
+	return start, end
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func nextDay(midnight time.Time) time.Time {
+	y, m, d := midnight.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, midnight.Location())
+}
+
+// FormatDuration formats working time in working days, hours and minutes, eg: 2d4h.
+func (c *slaCalendar) FormatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	days := d / c.dayLength()
+	d -= days * c.dayLength()
+	hours := d / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+
+	result := ""
+	if days > 0 {
+		result += strconv.Itoa(int(days)) + "d"
+	}
+	if hours > 0 {
+		result += strconv.Itoa(int(hours)) + "h"
+	}
+	if minutes > 0 || result == "" {
+		result += strconv.Itoa(int(minutes)) + "m"
+	}
+	return sign + result
+}
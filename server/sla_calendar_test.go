@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newSLACalendar(t *testing.T) {
+	t.Run("No calendar", func(t *testing.T) {
+		calendar, err := newSLACalendar("", "", "Europe/Berlin", "")
+		require.NoError(t, err)
+		assert.Nil(t, calendar)
+	})
+	t.Run("Working days, hours and holidays", func(t *testing.T) {
+		calendar, err := newSLACalendar("Mon-Thu, sat", "09:00-17:30", "Europe/Berlin", "2021-12-24 Christmas Eve\n# comment\n\n2021-12-31")
+		require.NoError(t, err)
+		assert.Equal(t, map[time.Weekday]bool{time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Saturday: true}, calendar.workingDays)
+		assert.Equal(t, 8*time.Hour+30*time.Minute, calendar.dayLength())
+		assert.Equal(t, "Europe/Berlin", calendar.location.String())
+		assert.True(t, calendar.holidays["2021-12-24"])
+		assert.True(t, calendar.holidays["2021-12-31"])
+	})
+	t.Run("Ranges wrap around the week", func(t *testing.T) {
+		calendar, err := newSLACalendar("fri-mon", "", "", "")
+		require.NoError(t, err)
+		assert.Equal(t, map[time.Weekday]bool{time.Friday: true, time.Saturday: true, time.Sunday: true, time.Monday: true}, calendar.workingDays)
+	})
+
+	for _, tt := range []struct{ days, hours, timezone, holidays string }{
+		{days: "monday-friday"},
+		{days: "mon-xyz"},
+		{hours: "9-17"},
+		{hours: "09:00"},
+		{hours: "17:00-09:00"},
+		{hours: "09:00-25:00"},
+		{days: "mon-fri", timezone: "Mars/Olympus"},
+		{holidays: "25/12/2021"},
+	} {
+		t.Run("Invalid", func(t *testing.T) {
+			_, err := newSLACalendar(tt.days, tt.hours, tt.timezone, tt.holidays)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_slaCalendar_WorkingTime(t *testing.T) {
+	calendar, err := newSLACalendar("mon-fri", "09:00-17:00", "UTC", "2021-10-13")
+	require.NoError(t, err)
+
+	// 2021-10-08 is a Friday and 2021-10-13 a Wednesday holiday
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want time.Duration
+	}{
+		{name: "within a working day", from: "2021-10-11T10:00:00Z", to: "2021-10-11T12:30:00Z", want: 2*time.Hour + 30*time.Minute},
+		{name: "before and after working hours", from: "2021-10-11T06:00:00Z", to: "2021-10-11T20:00:00Z", want: 8 * time.Hour},
+		{name: "over the weekend", from: "2021-10-08T16:00:00Z", to: "2021-10-11T10:00:00Z", want: 2 * time.Hour},
+		{name: "over a holiday", from: "2021-10-12T09:00:00Z", to: "2021-10-14T09:00:00Z", want: 8 * time.Hour},
+		{name: "during the weekend", from: "2021-10-09T10:00:00Z", to: "2021-10-10T10:00:00Z", want: 0},
+		{name: "reversed", from: "2021-10-11T12:00:00Z", to: "2021-10-11T10:00:00Z", want: -2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			to, _ := time.Parse(time.RFC3339, tt.to)
+			assert.Equal(t, tt.want, calendar.WorkingTime(from, to))
+		})
+	}
+
+	t.Run("Time zone", func(t *testing.T) {
+		berlin, err := newSLACalendar("mon-fri", "09:00-17:00", "Europe/Berlin", "")
+		require.NoError(t, err)
+		// 07:00-15:00 UTC are the working hours in Berlin during summer time
+		from, _ := time.Parse(time.RFC3339, "2021-10-11T05:00:00Z")
+		to, _ := time.Parse(time.RFC3339, "2021-10-11T08:00:00Z")
+		assert.Equal(t, time.Hour, berlin.WorkingTime(from, to))
+	})
+	t.Run("Daylight saving time changeover", func(t *testing.T) {
+		berlin, err := newSLACalendar("mon-sun", "09:00-17:00", "Europe/Berlin", "")
+		require.NoError(t, err)
+		// Summer time starts at 02:00 on 2021-03-28, the working hours are 07:00-15:00 UTC
+		from, _ := time.Parse(time.RFC3339, "2021-03-28T06:00:00Z")
+		to, _ := time.Parse(time.RFC3339, "2021-03-28T08:00:00Z")
+		assert.Equal(t, time.Hour, berlin.WorkingTime(from, to))
+		assert.Equal(t, "2021-03-28T08:00:00Z", berlin.Deadline(from, time.Hour).UTC().Format(time.RFC3339))
+		assert.Equal(t, 8*time.Hour, berlin.WorkingTime(from, from.Add(12*time.Hour)))
+	})
+	t.Run("No calendar", func(t *testing.T) {
+		from := time.Date(2021, 10, 9, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, 48*time.Hour, (*slaCalendar)(nil).WorkingTime(from, from.Add(48*time.Hour)))
+	})
+}
+
+func Test_slaCalendar_Deadline(t *testing.T) {
+	calendar, err := newSLACalendar("mon-fri", "09:00-17:00", "UTC", "2021-10-13")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		from   string
+		target time.Duration
+		want   string
+	}{
+		{name: "hours within the day", from: "2021-10-11T10:00:00Z", target: 4 * time.Hour, want: "2021-10-11T14:00:00Z"},
+		{name: "hours over the next day", from: "2021-10-11T15:00:00Z", target: 4 * time.Hour, want: "2021-10-12T11:00:00Z"},
+		{name: "submitted at night", from: "2021-10-11T22:00:00Z", target: time.Hour, want: "2021-10-12T10:00:00Z"},
+		{name: "days count working days", from: "2021-10-08T12:00:00Z", target: 2 * oneDay, want: "2021-10-12T12:00:00Z"},
+		{name: "skips holidays", from: "2021-10-12T12:00:00Z", target: oneDay, want: "2021-10-14T12:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			deadline := calendar.Deadline(from, tt.target)
+			assert.Equal(t, tt.want, deadline.UTC().Format(time.RFC3339))
+			assert.Equal(t, calendar.workingTarget(tt.target), calendar.WorkingTime(from, deadline))
+		})
+	}
+}
+
+func Test_slaCalendar_workingTarget(t *testing.T) {
+	calendar, err := newSLACalendar("mon-fri", "09:00-17:00", "UTC", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		target time.Duration
+		want   time.Duration
+	}{
+		{target: 4 * time.Hour, want: 4 * time.Hour},
+		{target: 8 * time.Hour, want: 8 * time.Hour},
+		{target: 12 * time.Hour, want: 8 * time.Hour},
+		{target: 23 * time.Hour, want: 8 * time.Hour},
+		{target: oneDay, want: 8 * time.Hour},
+		{target: 25 * time.Hour, want: 9 * time.Hour},
+		{target: 36 * time.Hour, want: 16 * time.Hour},
+		{target: 47 * time.Hour, want: 16 * time.Hour},
+		{target: 2 * oneDay, want: 16 * time.Hour},
+	}
+	previous := time.Duration(0)
+	for _, tt := range tests {
+		t.Run(tt.target.String(), func(t *testing.T) {
+			got := calendar.workingTarget(tt.target)
+			assert.Equal(t, tt.want, got)
+			assert.GreaterOrEqual(t, int64(got), int64(previous))
+			previous = got
+		})
+	}
+	assert.Equal(t, 36*time.Hour, (*slaCalendar)(nil).workingTarget(36*time.Hour))
+}
+
+func Test_slaCalendar_FormatDuration(t *testing.T) {
+	calendar, err := newSLACalendar("mon-fri", "09:00-17:00", "UTC", "")
+	require.NoError(t, err)
+	assert.Equal(t, "1d2h", calendar.FormatDuration(10*time.Hour))
+	assert.Equal(t, "-30m", calendar.FormatDuration(-30*time.Minute))
+	assert.Equal(t, "1d12h", (*slaCalendar)(nil).FormatDuration(36*time.Hour))
+	assert.Equal(t, "0m", (*slaCalendar)(nil).FormatDuration(0))
+}
+
+func Test_slaStatus_businessTime(t *testing.T) {
+	calendar, err := newSLACalendar("mon-fri", "09:00-17:00", "UTC", "")
+	require.NoError(t, err)
+	policy := &slaPolicy{defaults: map[slaStage]time.Duration{slaStageTriage: 2 * oneDay}, calendar: calendar}
+
+	// Submitted on Friday noon, the two working days end on Tuesday noon
+	report := &Report{}
+	report.Attributes.CreatedAt = "2021-10-08T12:00:00Z"
+	status, ok := policy.Evaluate(report, slaStageTriage)
+	require.True(t, ok)
+
+	monday := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	assert.False(t, status.Breached(monday))
+	assert.Equal(t, 8*time.Hour, status.Remaining(monday))
+	assert.Equal(t, "Triage target for none severity: 2d, 1d remaining (business time)", describeSLAStatus(status, monday))
+
+	wednesday := time.Date(2021, 10, 13, 10, 0, 0, 0, time.UTC)
+	assert.True(t, status.Breached(wednesday))
+	assert.Equal(t, "Triage target for none severity: 2d, overdue by 6h (business time)", describeSLAStatus(status, wednesday))
+	assert.Equal(t, time.Date(2021, 10, 12, 12, 0, 0, 0, time.UTC), status.Deadline().UTC())
+}
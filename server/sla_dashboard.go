@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// slaDashboardMaxListed bounds the rows of the SLA dashboard, the most urgent reports first.
+	slaDashboardMaxListed   = 50
+	slaDashboardTitleLength = 60
+	// slaDashboardMaxPages bounds the reports fetched per stage by the SLA dashboard.
+	slaDashboardMaxPages = 10
+)
+
+// reportAssignee returns the name of the user or group the report is assigned to, empty when
+// it is not assigned.
+func reportAssignee(report *Report) string {
+	assignee := report.Relationships.Assignee.Data
+	if assignee.Type == "user" {
+		return assignee.Attributes.Username
+	}
+	return assignee.Attributes.Name
+}
+
+// fetchSLADashboardReports fetches the reports still open in the stage, page by page, and reports
+// whether some of them were left out after slaDashboardMaxPages pages.
+func (p *Plugin) fetchSLADashboardReports(stage slaStage) ([]Report, bool, error) {
+	if stage == slaStageFirstResponse {
+		// Reports awaiting a first response are all fetched by ID
+		reports, err := p.fetchSLAReports(stage, 0)
+		return reports, false, err
+	}
+
+	// Every report which entered the stage before now is still open in it
+	filters := getDeadlineReportFilter(stage, 0)
+	reports := []Report{}
+	for page := 1; page <= slaDashboardMaxPages; page++ {
+		batch, err := p.fetchReportsPage(filters, page)
+		if err != nil {
+			return nil, false, err
+		}
+		reports = append(reports, batch...)
+		if len(batch) < reportsPageSize {
+			return reports, false, nil
+		}
+	}
+	return reports, true, nil
+}
+
+// getSLADashboard evaluates the open reports of the stages, most urgent first, and reports
+// whether some reports were left out. With atRisk, only the reports at risk of missing their SLA
+// are returned.
+func (p *Plugin) getSLADashboard(stages []slaStage, atRisk bool, now time.Time) ([]slaEvaluation, bool, error) {
+	policy := p.getConfiguration().getSLAPolicy()
+	evaluations := []slaEvaluation{}
+	truncated := false
+	for _, stage := range stages {
+		if policy.MinTarget(stage) <= 0 {
+			continue
+		}
+		reports, more, err := p.fetchSLADashboardReports(stage)
+		if err != nil {
+			return nil, false, err
+		}
+		truncated = truncated || more
+
+		for i := range reports {
+			status, ok := policy.Evaluate(&reports[i], stage)
+			if !ok || !status.ExitedAt.IsZero() {
+				continue
+			}
+			if atRisk && (status.Breached(now) || !policy.AtRisk(status, now)) {
+				continue
+			}
+			evaluations = append(evaluations, slaEvaluation{report: reports[i], status: status})
+		}
+	}
+
+	sort.SliceStable(evaluations, func(i, j int) bool {
+		return evaluations[i].status.Remaining(now) < evaluations[j].status.Remaining(now)
+	})
+	return evaluations, truncated, nil
+}
+
+func (p *Plugin) executeSLA(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
+	_, flags := parseCommandFlags(split)
+	stages := slaStages
+	if value, ok := flags["stage"]; ok {
+		stage := slaStage(strings.ToLower(value))
+		if !containsStage(slaStages, stage) {
+			msg := fmt.Sprintf("Unknown stage `%s`. Available stages are: %s. Run the command, eg: `/hackerone sla --stage triage`.", value, slaStageList())
+			return p.sendEphemeralError(args, msg), nil
+		}
+		stages = []slaStage{stage}
+	}
+	atRisk := flags["at-risk"] == "true"
+
+	now := time.Now()
+	evaluations, truncated, err := p.getSLADashboard(stages, atRisk, now)
+	if err != nil {
+		msg := fmt.Sprintf("Something went wrong while getting the reports from Hackerone API. Error: %s\n", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	truncatedNote := ""
+	if truncated {
+		truncatedNote = fmt.Sprintf("\nOnly %d reports of each stage were checked, some open reports may be missing.", slaDashboardMaxPages*reportsPageSize)
+	}
+
+	if len(evaluations) == 0 {
+		msg := "No open reports found matching the filter criteria you have specified."
+		if atRisk && p.getConfiguration().getSLAPolicy().warning == nil {
+			msg += " Reports are only at risk once an SLA warning threshold is configured."
+		}
+		return p.sendEphemeralResponse(args, msg+truncatedNote), nil
+	}
+
+	msg := "##### SLA of the open reports:\n\n"
+	if atRisk {
+		msg = "##### Open reports at risk of missing their SLA:\n\n"
+	}
+	msg += "| Report | Stage | Severity | Age | SLA Target | Remaining | Assignee |\n"
+	msg += "| ----------- | ----------- | ----------- | ----------- | ----------- | ----------- | ----------- |\n"
+	for i, e := range evaluations {
+		if i == slaDashboardMaxListed {
+			msg += fmt.Sprintf("\nOnly the %d most urgent of %d reports are shown. Use `--stage` or `--at-risk` to narrow down the list.", slaDashboardMaxListed, len(evaluations))
+			break
+		}
+		msg += fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s |\n",
+			p.formatSLADashboardReport(&evaluations[i].report),
+			slaStageNames[e.status.Stage],
+			e.status.Severity,
+			e.status.FormatDuration(e.status.Elapsed(now)),
+			formatSLATarget(e.status.Target),
+			formatSLARemaining(e.status, now),
+			formatSLADashboardAssignee(&evaluations[i].report),
+		)
+	}
+	if p.getConfiguration().getSLAPolicy().calendar != nil {
+		msg += "\nAges and remaining times are in business time."
+	}
+	msg += truncatedNote
+
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+// formatSLADashboardReport links to the report with its redacted and shortened title.
+func (p *Plugin) formatSLADashboardReport(report *Report) string {
+	title, _ := p.redact(report.Attributes.Title)
+	title, _ = truncateText(title, slaDashboardTitleLength)
+	return fmt.Sprintf("[#%s %s](%s)", sanitizeInline(report.Id), sanitizeInline(title), hackeroneURL("reports/", report.Id))
+}
+
+func formatSLADashboardAssignee(report *Report) string {
+	assignee := reportAssignee(report)
+	if assignee == "" {
+		return "Unassigned"
+	}
+	return sanitizeInline(assignee)
+}
+
+// formatSLARemaining formats the time left before the deadline, or by how long it was missed.
+func formatSLARemaining(status *slaStatus, now time.Time) string {
+	remaining := status.Remaining(now)
+	if remaining < 0 {
+		return "**overdue by " + status.FormatDuration(-remaining) + "**"
+	}
+	return status.FormatDuration(remaining)
+}
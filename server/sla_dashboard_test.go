@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_executeSLA(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	var message string
+	mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		message = args.Get(1).(*model.Post).Message
+	}).Return(&model.Post{})
+	p.SetAPI(mockPluginAPI)
+	config := &configuration{
+		HackeroneSLANew:              3,
+		HackeroneSLAPolicies:         "critical: triage=4h",
+		HackeroneSLAWarningThreshold: "2h",
+	}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+
+	now := time.Now()
+	assigned := strings.Replace(newReportJSON("3", "low", now.Add(-time.Hour)), `"relationships":{`, `"relationships":{"assignee":{"data":{"type":"user","attributes":{"username":"alice"}}},`, 1)
+	stubHackeroneReportList(p,
+		newReportJSON("1", "critical", now.Add(-3*time.Hour)),
+		newReportJSON("2", "critical", now.Add(-10*time.Hour)),
+		assigned,
+	)
+	args := &model.CommandArgs{UserId: "user1", ChannelId: "channel1"}
+
+	t.Run("Reports are sorted by urgency", func(t *testing.T) {
+		_, appErr := p.executeSLA(args, []string{"--stage", "triage"})
+		require.Nil(t, appErr)
+
+		lines := strings.Split(strings.TrimSpace(message), "\n")
+		require.Len(t, lines, 7)
+		assert.Contains(t, lines[4], "[#2 Report 2](https://hackerone.com/reports/2)")
+		assert.Contains(t, lines[4], "| Triage | critical | 10h | 4h | **overdue by 6h** | Unassigned |")
+		assert.Contains(t, lines[5], "[#1 Report 1]")
+		assert.Contains(t, lines[5], "| 3h | 4h | 59m | Unassigned |")
+		assert.Contains(t, lines[6], "[#3 Report 3]")
+		assert.Contains(t, lines[6], "| low | 1h | 3d | 2d22h59m | alice |")
+	})
+	t.Run("Only reports at risk", func(t *testing.T) {
+		_, appErr := p.executeSLA(args, []string{"--stage", "triage", "--at-risk"})
+		require.Nil(t, appErr)
+
+		assert.Contains(t, message, "at risk of missing their SLA")
+		assert.Contains(t, message, "[#1 Report 1]")
+		assert.NotContains(t, message, "[#2 Report 2]")
+		assert.NotContains(t, message, "[#3 Report 3]")
+	})
+	t.Run("Reports are fetched page by page", func(t *testing.T) {
+		page := make([]string, reportsPageSize)
+		for i := range page {
+			page[i] = newReportJSON(fmt.Sprint(i+10), "low", now.Add(-time.Hour))
+		}
+		full := `{"data":[` + strings.Join(page, ",") + `]}`
+		last := `{"data":[` + newReportJSON("1", "critical", now.Add(-3*time.Hour)) + `]}`
+		requested := []string{}
+		p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			number := r.URL.Query().Get("page[number]")
+			requested = append(requested, number)
+			body := full
+			if number == "2" {
+				body = last
+			}
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+		})
+		defer stubHackeroneReportList(p)
+
+		_, appErr := p.executeSLA(args, []string{"--stage", "triage", "--at-risk"})
+		require.Nil(t, appErr)
+		assert.Equal(t, []string{"1", "2"}, requested)
+		assert.Contains(t, message, "[#1 Report 1]")
+		assert.NotContains(t, message, "some open reports may be missing")
+
+		// The dashboard tells when the reports do not fit in the pages it fetches
+		last = full
+		requested = []string{}
+		_, appErr = p.executeSLA(args, []string{"--stage", "triage", "--at-risk"})
+		require.Nil(t, appErr)
+		assert.Len(t, requested, slaDashboardMaxPages)
+		assert.Contains(t, message, fmt.Sprintf("Only %d reports of each stage were checked, some open reports may be missing.", slaDashboardMaxPages*reportsPageSize))
+	})
+	t.Run("Unknown stage", func(t *testing.T) {
+		_, appErr := p.executeSLA(args, []string{"--stage", "disclosure"})
+		require.Nil(t, appErr)
+		assert.Contains(t, message, "Unknown stage `disclosure`")
+	})
+}
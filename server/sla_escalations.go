@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	EscalationTargetUser = "user"
+
+	// escalationGroupMembersLimit bounds the members of a group receiving an escalation.
+	escalationGroupMembersLimit = 100
+)
+
+// slaEscalationTarget is a channel, user or group an escalation is posted to.
+type slaEscalationTarget struct {
+	Type string
+	// Name is the user or group name, or team/channel for channels.
+	Name string
+}
+
+func (t slaEscalationTarget) String() string {
+	return t.Type + ":" + t.Name
+}
+
+// slaEscalationLevel is posted to its targets once a report missed its SLA for longer than delay.
+type slaEscalationLevel struct {
+	delay   time.Duration
+	targets []slaEscalationTarget
+}
+
+// parseSLAEscalations parses the escalation levels, one level per line made of the stage, the
+// delay after the breach and the targets, eg:
+// triage 0 channel:security/triage
+// triage 1d user:alice group:security-leads
+func parseSLAEscalations(text string) (map[slaStage][]*slaEscalationLevel, error) {
+	escalations := map[slaStage][]*slaEscalationLevel{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, errors.Errorf("invalid SLA escalation `%s`: expected the stage, the delay after the breach and the targets, eg: `triage 1d user:alice group:security-leads`", line)
+		}
+
+		stage := slaStage(strings.ToLower(fields[0]))
+		if !containsStage(slaStages, stage) {
+			return nil, errors.Errorf("invalid SLA escalation `%s`: unknown stage `%s`. Available stages are: %s", line, fields[0], slaStageList())
+		}
+
+		level := &slaEscalationLevel{}
+		if fields[1] != "0" {
+			delay, err := parseDuration(fields[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid SLA escalation `%s`", line)
+			}
+			level.delay = delay
+		}
+
+		for _, field := range fields[2:] {
+			target, err := parseEscalationTarget(field)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid SLA escalation `%s`", line)
+			}
+			level.targets = append(level.targets, target)
+		}
+
+		escalations[stage] = append(escalations[stage], level)
+	}
+
+	for _, levels := range escalations {
+		sort.SliceStable(levels, func(i, j int) bool { return levels[i].delay < levels[j].delay })
+	}
+	return escalations, nil
+}
+
+// parseEscalationTarget parses a target using the subjects of the permissions commands:
+// channel:team/channel, user:username or @username, and group:name.
+func parseEscalationTarget(value string) (slaEscalationTarget, error) {
+	if strings.HasPrefix(value, "@") {
+		return slaEscalationTarget{Type: EscalationTargetUser, Name: strings.TrimPrefix(value, "@")}, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		targetType, name := strings.ToLower(parts[0]), strings.TrimLeft(parts[1], "@~")
+		switch targetType {
+		case EscalationTargetUser, GrantTypeGroup:
+			return slaEscalationTarget{Type: targetType, Name: name}, nil
+		case GrantTypeChannel:
+			if i := strings.Index(name, "/"); i > 0 && i < len(name)-1 {
+				return slaEscalationTarget{Type: targetType, Name: name}, nil
+			}
+			return slaEscalationTarget{}, errors.Errorf("the channel `%s` must include its team, eg: `channel:myteam/security`", name)
+		}
+	}
+
+	return slaEscalationTarget{}, errors.Errorf("unknown target `%s`. Use `channel:<team>/<channel>`, `user:<username>` or `group:<name>`", value)
+}
+
+// escalateSLABreaches posts the breached reports to the targets of each escalation level they
+// reached since the last run. The level reached by each report is stored with its breach so
+// that restarts do not escalate reports again.
+func (p *Plugin) escalateSLABreaches(check slaCheck, breached []slaEvaluation, now time.Time) error {
+	policy := p.getConfiguration().getSLAPolicy()
+	levels := policy.escalations[check.stage]
+	if len(levels) == 0 || len(breached) == 0 {
+		return nil
+	}
+
+	due := map[int][]slaEvaluation{}
+	err := p.modifySLABreaches(func(breaches map[string]*SLABreach) bool {
+		due = map[int][]slaEvaluation{}
+		for _, e := range breached {
+			breach, ok := breaches[slaBreachKey(e.report.Id, check.stage)]
+			if !ok {
+				continue
+			}
+			overdue := -e.status.Remaining(now)
+			for i := breach.EscalationLevel; i < len(levels) && overdue >= levels[i].delay; i++ {
+				due[i] = append(due[i], e)
+				breach.EscalationLevel = i + 1
+			}
+		}
+		return len(due) > 0
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store SLA escalations")
+	}
+
+	for i, level := range levels {
+		evaluations, ok := due[i]
+		if !ok {
+			continue
+		}
+		notification := &reportNotification{
+			Title:       fmt.Sprintf(":rotating_light: SLA Escalation (level %d) - %s:", i+1, check.label),
+			Description: fmt.Sprintf("These reports missed the SLA of their severity by more than %s and are escalated to you.", formatSLATarget(level.delay)),
+			Color:       slaBreachColor,
+		}
+		p.notifyEscalationTargets(level, evaluations, notification, func(status *slaStatus) string { return describeSLAStatus(status, now) })
+	}
+
+	return nil
+}
+
+// notifyResolvedEscalations tells the targets of the escalation levels a report reached that
+// the report finally left the stage.
+func (p *Plugin) notifyResolvedEscalations(check slaCheck, resolved []slaEvaluation) {
+	levels := p.getConfiguration().getSLAPolicy().escalations[check.stage]
+	for i, level := range levels {
+		evaluations := []slaEvaluation{}
+		for _, e := range resolved {
+			if e.escalationLevel > i {
+				evaluations = append(evaluations, e)
+			}
+		}
+		if len(evaluations) == 0 {
+			continue
+		}
+		notification := &reportNotification{
+			Title:       ":white_check_mark: Resolved after SLA Escalation - " + check.label + ":",
+			Description: "These reports escalated to you have finally been " + check.resolvedVerb + ".",
+			Color:       slaResolvedColor,
+		}
+		p.notifyEscalationTargets(level, evaluations, notification, describeSLAResolution)
+	}
+}
+
+// notifyEscalationTargets posts the reports in the channels of the level, and in a direct message
+// to its users and to the members of its groups.
+func (p *Plugin) notifyEscalationTargets(level *slaEscalationLevel, evaluations []slaEvaluation, notification *reportNotification, describe func(status *slaStatus) string) {
+	notification.Notes = map[string]string{}
+	attachments := []*model.SlackAttachment{}
+	for _, e := range evaluations {
+		notification.Notes[e.report.Id] = describe(e.status)
+		attachments = append(attachments, p.getNotifiedReportAttachment(e.report, notification))
+	}
+	message := "#### " + notification.Title + "\n" + notification.Description + "\n\n"
+
+	for _, target := range level.targets {
+		channelIDs, err := p.resolveEscalationTarget(target)
+		if err != nil {
+			p.API.LogWarn("Unable to resolve an SLA escalation target", "target", target.String(), "error", err.Error())
+			continue
+		}
+		for _, channelID := range channelIDs {
+			p.sendPostByChannelId(channelID, message, attachments)
+		}
+	}
+}
+
+// resolveEscalationTarget returns the channels to post an escalation to: the channel itself, or
+// the direct message channels of the user or of the group members.
+func (p *Plugin) resolveEscalationTarget(target slaEscalationTarget) ([]string, error) {
+	userIDs := []string{}
+	switch target.Type {
+	case GrantTypeChannel:
+		parts := strings.SplitN(target.Name, "/", 2)
+		channel, appErr := p.API.GetChannelByNameForTeamName(parts[0], parts[1], false)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "could not find the channel `%s`", target.Name)
+		}
+		return []string{channel.Id}, nil
+	case EscalationTargetUser:
+		user, appErr := p.API.GetUserByUsername(target.Name)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "could not find the user `%s`", target.Name)
+		}
+		userIDs = append(userIDs, user.Id)
+	case GrantTypeGroup:
+		group, appErr := p.API.GetGroupByName(target.Name)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "could not find the group `%s`", target.Name)
+		}
+		members, appErr := p.API.GetGroupMemberUsers(group.Id, 0, escalationGroupMembersLimit)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "could not get the members of the group `%s`", target.Name)
+		}
+		for _, member := range members {
+			userIDs = append(userIDs, member.Id)
+		}
+	}
+
+	channelIDs := []string{}
+	for _, userID := range userIDs {
+		channel, appErr := p.API.GetDirectChannel(userID, p.BotUserID)
+		if appErr != nil {
+			p.API.LogWarn("Unable to get direct channel", "user_id", userID, "error", appErr.Error())
+			continue
+		}
+		channelIDs = append(channelIDs, channel.Id)
+	}
+	return channelIDs, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSLAEscalations(t *testing.T) {
+	t.Run("Valid escalations", func(t *testing.T) {
+		escalations, err := parseSLAEscalations("# Security team first\ntriage 1d user:alice group:security-leads\ntriage 0 channel:security/triage\n\nresolve 2w @bob")
+		require.NoError(t, err)
+
+		require.Len(t, escalations[slaStageTriage], 2)
+		assert.Equal(t, time.Duration(0), escalations[slaStageTriage][0].delay)
+		assert.Equal(t, []slaEscalationTarget{{Type: GrantTypeChannel, Name: "security/triage"}}, escalations[slaStageTriage][0].targets)
+		assert.Equal(t, 24*time.Hour, escalations[slaStageTriage][1].delay)
+		assert.Equal(t, []slaEscalationTarget{{Type: EscalationTargetUser, Name: "alice"}, {Type: GrantTypeGroup, Name: "security-leads"}}, escalations[slaStageTriage][1].targets)
+
+		assert.Empty(t, escalations[slaStageBounty])
+		require.Len(t, escalations[slaStageResolve], 1)
+		assert.Equal(t, 14*24*time.Hour, escalations[slaStageResolve][0].delay)
+		assert.Equal(t, []slaEscalationTarget{{Type: EscalationTargetUser, Name: "bob"}}, escalations[slaStageResolve][0].targets)
+	})
+
+	for name, text := range map[string]string{
+		"Missing targets":      "triage 1d",
+		"Unknown stage":        "disclosure 1d user:alice",
+		"Invalid delay":        "triage soon user:alice",
+		"Channel without team": "triage 1d channel:triage",
+		"Unknown target":       "triage 1d team:security",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseSLAEscalations(text)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_escalateSLABreaches(t *testing.T) {
+	p := &Plugin{}
+	p.BotUserID = "bot"
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("GetChannelByNameForTeamName", "security", "triage", false).Return(&model.Channel{Id: "triage-channel"}, nil)
+	mockPluginAPI.On("GetUserByUsername", "alice").Return(&model.User{Id: "alice-id"}, nil)
+	mockPluginAPI.On("GetGroupByName", "leads").Return(&model.Group{Id: "leads-id"}, nil)
+	mockPluginAPI.On("GetGroupMemberUsers", "leads-id", 0, escalationGroupMembersLimit).Return([]*model.User{{Id: "carol-id"}}, nil)
+	mockPluginAPI.On("GetDirectChannel", mock.AnythingOfType("string"), "bot").Return(func(userID, _ string) *model.Channel {
+		return &model.Channel{Id: "dm-" + userID}
+	}, nil)
+
+	posts := map[string][]string{}
+	mockPluginAPI.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		posts[post.ChannelId] = append(posts[post.ChannelId], post.Message)
+		return post
+	}, nil)
+	p.SetAPI(mockPluginAPI)
+
+	config := &configuration{HackeroneSLANew: 1, HackeroneSLAEscalations: "triage 0 channel:security/triage\ntriage 2d user:alice group:leads"}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+
+	report := Report{Id: "1"}
+	report.Attributes.CreatedAt = "2021-10-01T00:00:00Z"
+	status, ok := policy.Evaluate(&report, slaStageTriage)
+	require.True(t, ok)
+	breached := []slaEvaluation{{report: report, status: status}}
+	check := slaChecks[0]
+
+	global := &slaScope{policy: policy}
+	start := time.Date(2021, 10, 2, 12, 0, 0, 0, time.UTC)
+	_, _, _, err = p.trackSLABreaches(global, slaStageTriage, breached, start)
+	require.NoError(t, err)
+
+	t.Run("Levels are reached as the breach gets older", func(t *testing.T) {
+		require.NoError(t, p.escalateSLABreaches(check, breached, start))
+		assert.Len(t, posts["triage-channel"], 1)
+		assert.Contains(t, posts["triage-channel"][0], "SLA Escalation (level 1) - New Reports")
+		assert.Empty(t, posts["dm-alice-id"])
+
+		require.NoError(t, p.escalateSLABreaches(check, breached, start.Add(2*24*time.Hour)))
+		assert.Len(t, posts["triage-channel"], 1)
+		require.Len(t, posts["dm-alice-id"], 1)
+		assert.Contains(t, posts["dm-alice-id"][0], "SLA Escalation (level 2) - New Reports")
+		assert.Len(t, posts["dm-carol-id"], 1)
+
+		breaches, err := p.getSLABreaches()
+		require.NoError(t, err)
+		assert.Equal(t, 2, breaches[slaBreachKey("1", slaStageTriage)].EscalationLevel)
+	})
+	t.Run("Escalated breaches are not escalated again", func(t *testing.T) {
+		require.NoError(t, p.escalateSLABreaches(check, breached, start.Add(5*24*time.Hour)))
+		assert.Len(t, posts["triage-channel"], 1)
+		assert.Len(t, posts["dm-alice-id"], 1)
+		assert.Len(t, posts["dm-carol-id"], 1)
+	})
+	t.Run("Targets are told when escalated reports are resolved", func(t *testing.T) {
+		resolved := []slaEvaluation{{report: report, status: status, escalationLevel: 1}}
+		p.notifyResolvedEscalations(check, resolved)
+		require.Len(t, posts["triage-channel"], 2)
+		assert.Contains(t, posts["triage-channel"][1], "Resolved after SLA Escalation - New Reports")
+		assert.Len(t, posts["dm-alice-id"], 1)
+	})
+}
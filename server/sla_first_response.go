@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FirstResponsesKey holds when each report was filed and first answered by the team.
+	FirstResponsesKey = "first-responses"
+
+	// firstResponseRetention is how long reports are kept in the first responses, from their
+	// first response or from their filing while they are not answered.
+	firstResponseRetention = 365 * 24 * time.Hour
+)
+
+// FirstResponse records when a report was filed and when the team first answered it, zero
+// while it was not answered.
+type FirstResponse struct {
+	ReportID    string
+	FiledAt     int64
+	RespondedAt int64
+}
+
+func decodeFirstResponses(value []byte) (map[string]*FirstResponse, error) {
+	responses := map[string]*FirstResponse{}
+	if value == nil {
+		return responses, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(value)).Decode(&responses); err != nil {
+		return nil, errors.Wrap(err, "could not properly decode first responses key")
+	}
+
+	return responses, nil
+}
+
+func (p *Plugin) getFirstResponses() (map[string]*FirstResponse, error) {
+	value, appErr := p.API.KVGet(FirstResponsesKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get first responses from KVStore")
+	}
+
+	return decodeFirstResponses(value)
+}
+
+// modifyFirstResponses atomically applies modify to the first responses, modify returns whether
+// the first responses changed.
+func (p *Plugin) modifyFirstResponses(modify func(responses map[string]*FirstResponse) bool) error {
+	return p.atomicModify(FirstResponsesKey, func(value []byte) ([]byte, error) {
+		responses, err := decodeFirstResponses(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !modify(responses) {
+			return nil, nil
+		}
+
+		b, err := json.Marshal(responses)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting first responses to json")
+		}
+		return b, nil
+	})
+}
+
+// isTeamResponse reports whether the activity answers the reporter: an activity visible to the
+// reporter, by someone else than the reporter.
+func isTeamResponse(activity *Activity, report *Report) bool {
+	if activity.Attributes.Internal || activity.Attributes.AutomatedResponse || activity.ActivityType == "activity-bug-filed" {
+		return false
+	}
+	actor := activity.Relationships.Actor.Data.Attributes.Username
+	return actor != "" && actor != report.Relationships.Reporter.Data.Attributes.Username
+}
+
+// recordFirstResponse tracks the first response to the reports filed since the activities are
+// polled. report is nil when it could not be fetched, and the activity then only records filings.
+func (p *Plugin) recordFirstResponse(activity Activity, report *Report) error {
+	createdAt := parseReportTime(activity.Attributes.CreatedAt)
+	if createdAt.IsZero() {
+		return nil
+	}
+	reportID := activity.Attributes.ReportID
+	filed := activity.ActivityType == "activity-bug-filed"
+	if !filed && (report == nil || !isTeamResponse(&activity, report)) {
+		return nil
+	}
+
+	at := model.GetMillisForTime(createdAt)
+	return p.modifyFirstResponses(func(responses map[string]*FirstResponse) bool {
+		response, ok := responses[reportID]
+		switch {
+		case filed && !ok:
+			responses[reportID] = &FirstResponse{ReportID: reportID, FiledAt: at}
+		case !filed && ok && response.RespondedAt == 0:
+			response.RespondedAt = at
+		default:
+			return false
+		}
+
+		// Reports are only kept for a while so that the key does not grow forever
+		expiry := model.GetMillisForTime(createdAt.Add(-firstResponseRetention))
+		for id, r := range responses {
+			if r.expired(expiry) {
+				delete(responses, id)
+			}
+		}
+		return true
+	})
+}
+
+// expired reports whether the report was answered, or filed while it is not answered, before
+// expiry.
+func (r *FirstResponse) expired(expiry int64) bool {
+	if r.RespondedAt != 0 {
+		return r.RespondedAt < expiry
+	}
+	return r.FiledAt < expiry
+}
+
+// fetchSLAReports returns the reports still in the stage which entered it longer than
+// enteredBefore ago. Reports awaiting a first response come from the recorded filings as the
+// Hackerone API cannot filter them.
+func (p *Plugin) fetchSLAReports(stage slaStage, enteredBefore time.Duration) ([]Report, error) {
+	if stage != slaStageFirstResponse {
+		return p.fetchReports(getDeadlineReportFilter(stage, enteredBefore))
+	}
+
+	responses, err := p.getFirstResponses()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	filedBefore := model.GetMillisForTime(now.Add(-enteredBefore))
+	expiry := model.GetMillisForTime(now.Add(-firstResponseRetention))
+	reportIDs := []string{}
+	stale := []string{}
+	for _, response := range responses {
+		switch {
+		case response.RespondedAt != 0 || response.FiledAt > filedBefore:
+		case response.expired(expiry):
+			stale = append(stale, response.ReportID)
+		default:
+			reportIDs = append(reportIDs, response.ReportID)
+		}
+	}
+	sort.Strings(reportIDs)
+
+	fetched, err := p.fetchReportsByID(reportIDs)
+	if err != nil {
+		return nil, err
+	}
+	reports := []Report{}
+	for _, report := range fetched {
+		response, ok := responses[report.Id]
+		if !ok {
+			continue
+		}
+		if report.Attributes.ClosedAt != "" {
+			// Closed reports will not be answered anymore
+			stale = append(stale, report.Id)
+			continue
+		}
+		report.firstResponse = response
+		reports = append(reports, report)
+	}
+
+	if len(stale) > 0 {
+		if err := p.forgetFirstResponses(stale); err != nil {
+			p.API.LogWarn("Unable to forget the first responses of closed or expired reports", "error", err.Error())
+		}
+	}
+	return reports, nil
+}
+
+// forgetFirstResponses removes the reports which are still not answered from the first responses.
+func (p *Plugin) forgetFirstResponses(reportIDs []string) error {
+	return p.modifyFirstResponses(func(responses map[string]*FirstResponse) bool {
+		changed := false
+		for _, id := range reportIDs {
+			if response, ok := responses[id]; ok && response.RespondedAt == 0 {
+				delete(responses, id)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// fetchSLAReportsByID fetches the reports with the given IDs along with what is needed to evaluate
+// the stage, by report ID. Reports which cannot be found in the program are left out.
+func (p *Plugin) fetchSLAReportsByID(reportIDs []string, stage slaStage) (map[string]Report, error) {
+	reports, err := p.fetchReportsByID(reportIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses map[string]*FirstResponse
+	if stage == slaStageFirstResponse {
+		if responses, err = p.getFirstResponses(); err != nil {
+			return nil, err
+		}
+	}
+
+	byID := map[string]Report{}
+	for _, report := range reports {
+		report.firstResponse = responses[report.Id]
+		byID[report.Id] = report
+	}
+	return byID, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newActivity(activityType string, reportID string, actor string, createdAt string) Activity {
+	activity := Activity{ActivityType: activityType}
+	activity.Attributes.ReportID = reportID
+	activity.Attributes.CreatedAt = createdAt
+	activity.Relationships.Actor.Data.Attributes.Username = actor
+	return activity
+}
+
+func Test_recordFirstResponse(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+
+	report := &Report{Id: "1"}
+	report.Relationships.Reporter.Data.Attributes.Username = "hacker"
+	internal := newActivity("activity-comment", "1", "triager", "2021-10-01T02:00:00Z")
+	internal.Attributes.Internal = true
+	automated := newActivity("activity-comment", "1", "triager", "2021-10-01T03:00:00Z")
+	automated.Attributes.AutomatedResponse = true
+
+	// Activities on reports filed before the activities were polled are ignored
+	require.NoError(t, p.recordFirstResponse(newActivity("activity-comment", "1", "triager", "2021-10-01T00:30:00Z"), report))
+	responses, err := p.getFirstResponses()
+	require.NoError(t, err)
+	assert.Empty(t, responses)
+
+	for _, activity := range []Activity{
+		newActivity("activity-bug-filed", "1", "hacker", "2021-10-01T00:00:00Z"),
+		newActivity("activity-comment", "1", "hacker", "2021-10-01T01:00:00Z"),
+		internal,
+		automated,
+	} {
+		require.NoError(t, p.recordFirstResponse(activity, report))
+	}
+	responses, err = p.getFirstResponses()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*FirstResponse{"1": {ReportID: "1", FiledAt: 1633046400000}}, responses)
+
+	require.NoError(t, p.recordFirstResponse(newActivity("activity-bug-triaged", "1", "triager", "2021-10-01T04:00:00Z"), report))
+	require.NoError(t, p.recordFirstResponse(newActivity("activity-comment", "1", "triager", "2021-10-01T05:00:00Z"), report))
+	responses, err = p.getFirstResponses()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1633060800000), responses["1"].RespondedAt)
+
+	// Reports not answered within the retention are forgotten
+	require.NoError(t, p.recordFirstResponse(newActivity("activity-bug-filed", "2", "hacker", "2021-09-30T00:00:00Z"), report))
+	require.NoError(t, p.recordFirstResponse(newActivity("activity-bug-filed", "3", "hacker", "2022-10-01T00:00:00Z"), report))
+	responses, err = p.getFirstResponses()
+	require.NoError(t, err)
+	assert.Contains(t, responses, "1")
+	assert.NotContains(t, responses, "2")
+	assert.Contains(t, responses, "3")
+}
+
+func Test_slaPolicy_EvaluateFirstResponse(t *testing.T) {
+	now := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	policy, err := newSLAPolicy("critical: first-response=4h", map[slaStage]time.Duration{
+		slaStageTriage: 3 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour, policy.MinTarget(slaStageFirstResponse))
+
+	report := Report{Id: "1"}
+	report.Relationships.Severity.Data.Attributes.Rating = "critical"
+	_, ok := policy.Evaluate(&report, slaStageFirstResponse)
+	assert.False(t, ok, "reports whose filing was not recorded are not measured")
+
+	report.firstResponse = &FirstResponse{ReportID: "1", FiledAt: model.GetMillisForTime(now.Add(-5 * time.Hour))}
+	status, ok := policy.Evaluate(&report, slaStageFirstResponse)
+	require.True(t, ok)
+	assert.True(t, status.Breached(now))
+	assert.Equal(t, "First response target for critical severity: 4h, overdue by 1h", describeSLAStatus(status, now))
+
+	report.firstResponse.RespondedAt = model.GetMillisForTime(now.Add(-2 * time.Hour))
+	status, ok = policy.Evaluate(&report, slaStageFirstResponse)
+	require.True(t, ok)
+	assert.Equal(t, 3*time.Hour, status.Elapsed(now))
+	assert.False(t, status.Breached(now))
+
+	// Severities without a first response target are not measured
+	report.Relationships.Severity.Data.Attributes.Rating = "low"
+	_, ok = policy.Evaluate(&report, slaStageFirstResponse)
+	assert.False(t, ok)
+}
+
+func Test_fetchSLAReportsFirstResponse(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	reports := map[string]string{
+		"1": `{"id":"1","attributes":{"state":"new","created_at":"2021-10-01T00:00:00Z"}}`,
+		"2": `{"id":"2","attributes":{"state":"new","created_at":"2021-10-01T00:00:00Z"}}`,
+		"4": `{"id":"4","attributes":{"state":"duplicate","created_at":"2021-10-01T00:00:00Z","closed_at":"2021-10-01T01:00:00Z"}}`,
+	}
+	requests := []*http.Request{}
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests = append(requests, r)
+		body := ""
+		if r.URL.Path == "/v1/reports" {
+			list := []string{}
+			for _, id := range r.URL.Query()["filter[id][]"] {
+				if report, ok := reports[id]; ok {
+					list = append(list, report)
+				}
+			}
+			body = `{"data":[` + strings.Join(list, ",") + `]}`
+		} else {
+			body = `{"data":` + reports[strings.TrimPrefix(r.URL.Path, "/v1/reports/")] + `}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	now := time.Now()
+	require.NoError(t, p.modifyFirstResponses(func(responses map[string]*FirstResponse) bool {
+		responses["1"] = &FirstResponse{ReportID: "1", FiledAt: model.GetMillisForTime(now.Add(-5 * time.Hour))}
+		responses["2"] = &FirstResponse{ReportID: "2", FiledAt: model.GetMillisForTime(now.Add(-5 * time.Hour)), RespondedAt: model.GetMillisForTime(now.Add(-time.Hour))}
+		responses["3"] = &FirstResponse{ReportID: "3", FiledAt: model.GetMillisForTime(now.Add(-time.Hour))}
+		responses["4"] = &FirstResponse{ReportID: "4", FiledAt: model.GetMillisForTime(now.Add(-5 * time.Hour))}
+		responses["5"] = &FirstResponse{ReportID: "5", FiledAt: model.GetMillisForTime(now.Add(-firstResponseRetention - time.Hour))}
+		return true
+	}))
+
+	result, err := p.fetchSLAReports(slaStageFirstResponse, 4*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, "1", result[0].Id)
+	require.NotNil(t, result[0].firstResponse)
+
+	// The unanswered reports are fetched at once
+	require.Len(t, requests, 1)
+	assert.Equal(t, []string{"1", "4"}, requests[0].URL.Query()["filter[id][]"])
+
+	// Closed and expired reports which were not answered are forgotten
+	responses, err := p.getFirstResponses()
+	require.NoError(t, err)
+	assert.Contains(t, responses, "1")
+	assert.Contains(t, responses, "2")
+	assert.Contains(t, responses, "3")
+	assert.NotContains(t, responses, "4")
+	assert.NotContains(t, responses, "5")
+
+	byID, err := p.fetchSLAReportsByID([]string{"2", "3"}, slaStageFirstResponse)
+	require.NoError(t, err)
+	require.Len(t, byID, 1)
+	require.NotNil(t, byID["2"].firstResponse)
+	assert.NotZero(t, byID["2"].firstResponse.RespondedAt)
+
+	t.Run("Nothing to fetch", func(t *testing.T) {
+		delete(store.values, FirstResponsesKey)
+		requests = nil
+		result, err = p.fetchSLAReports(slaStageFirstResponse, 4*time.Hour)
+		require.NoError(t, err)
+		assert.Empty(t, result)
+		assert.Empty(t, requests)
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SLAOverrideOff opts a subscription out of the SLA notifications.
+	SLAOverrideOff = "off"
+	// SLAOverrideDefault removes the SLA overrides of a subscription.
+	SLAOverrideDefault = "default"
+)
+
+// slaScope is a set of subscriptions sharing an SLA policy, along with the breaches announced to
+// them. The global scope, with an empty ID, holds the subscriptions using the SLA settings, and
+// each subscription overriding them has its own scope named after the subscription.
+type slaScope struct {
+	id            string
+	policy        *slaPolicy
+	subscriptions []*Subscription
+}
+
+// breachKey returns the key of the breach of the report in the stage for the subscriptions of
+// the scope. Breaches of the global scope keep the keys used before scopes were introduced.
+func (s *slaScope) breachKey(reportID string, stage slaStage) string {
+	if s.id == "" {
+		return slaBreachKey(reportID, stage)
+	}
+	return slaBreachKey(reportID, stage) + "/" + s.id
+}
+
+// matches reports whether the SLA status of the report concerns the scope. The global scope
+// tracks every report, as escalations are not bound to subscriptions, while the scope of a
+// subscription only tracks the reports the subscription notifies about.
+func (s *slaScope) matches(report *Report) bool {
+	if s.id == "" {
+		return true
+	}
+	for _, sub := range s.subscriptions {
+		if subscriptionMatches(sub, report) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluates reports whether the reports of the stage must be checked for the scope. The global
+// scope is checked even without subscriptions, as its breaches are escalated.
+func (s *slaScope) evaluates(stage slaStage) bool {
+	if s.id != "" && len(s.subscriptions) == 0 {
+		return false
+	}
+	return s.policy.MinTarget(stage) > 0
+}
+
+// parseSLAOverride normalizes the SLA overrides of a subscription given on a single line, with
+// the severities separated by semicolons, eg: `critical: triage=4h; high: triage=1d`.
+func parseSLAOverride(value string) (string, error) {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(value, ";", "\n"), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", errors.New("the SLA override is empty. Use targets per severity such as `critical: triage=4h bounty=2d; high: triage=1d`, `off` or `default`")
+	}
+
+	text := strings.Join(lines, "\n")
+	if _, err := newSLAPolicy(text, nil); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// formatSLAOverride shows the SLA overrides of a subscription on a single line.
+func formatSLAOverride(text string) string {
+	return strings.ReplaceAll(text, "\n", "; ")
+}
+
+// withOverrides returns the policy with the targets of the overrides replacing its own targets
+// for the same severities and stages. The calendar and warning threshold are kept, but the
+// escalations only apply to the global policy.
+func (s *slaPolicy) withOverrides(text string) (*slaPolicy, error) {
+	overrides, err := newSLAPolicy(text, s.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &slaPolicy{
+		targets:  map[string]map[slaStage]time.Duration{},
+		defaults: s.defaults,
+		calendar: s.calendar,
+		warning:  s.warning,
+	}
+	for _, policy := range []*slaPolicy{s, overrides} {
+		for severity, targets := range policy.targets {
+			if _, ok := merged.targets[severity]; !ok {
+				merged.targets[severity] = map[slaStage]time.Duration{}
+			}
+			for stage, target := range targets {
+				merged.targets[severity][stage] = target
+			}
+		}
+	}
+	return merged, nil
+}
+
+// getSLAScopes groups the subscriptions per SLA policy: the global scope, then a scope for each
+// subscription overriding the SLA. Subscriptions opted out of the SLA notifications are left out,
+// and those whose overrides became invalid fall back to the global scope.
+func (p *Plugin) getSLAScopes(subs []*Subscription) []*slaScope {
+	policy := p.getConfiguration().getSLAPolicy()
+	global := &slaScope{policy: policy}
+	scopes := []*slaScope{global}
+	for _, sub := range subs {
+		switch {
+		case sub.SLADisabled:
+			continue
+		case sub.SLAPolicies != "":
+			overridden, err := policy.withOverrides(sub.SLAPolicies)
+			if err == nil {
+				scopes = append(scopes, &slaScope{id: sub.ID, policy: overridden, subscriptions: []*Subscription{sub}})
+				continue
+			}
+			p.API.LogWarn("Invalid SLA overrides of a subscription, using the SLA settings instead", "subscription_id", sub.ID, "error", err.Error())
+		}
+		global.subscriptions = append(global.subscriptions, sub)
+	}
+	return scopes
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSLAOverride(t *testing.T) {
+	text, err := parseSLAOverride(" critical:  triage=4h bounty=2d;high: triage=1d ; ")
+	require.NoError(t, err)
+	assert.Equal(t, "critical: triage=4h bounty=2d\nhigh: triage=1d", text)
+	assert.Equal(t, "critical: triage=4h bounty=2d; high: triage=1d", formatSLAOverride(text))
+
+	for _, value := range []string{"", " ; ", "critical: triage=soon", "urgent: triage=4h", "critical: disclosure=4h"} {
+		_, err = parseSLAOverride(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func Test_slaPolicy_withOverrides(t *testing.T) {
+	policy, err := newSLAPolicy("critical: triage=4h bounty=2d", map[slaStage]time.Duration{
+		slaStageTriage: 3 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	policy.warning = &slaWarning{percent: 80}
+
+	merged, err := policy.withOverrides("critical: triage=12h\nhigh: triage=1d")
+	require.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, merged.targets["critical"][slaStageTriage])
+	assert.Equal(t, 2*24*time.Hour, merged.targets["critical"][slaStageBounty])
+	assert.Equal(t, 24*time.Hour, merged.targets["high"][slaStageTriage])
+	assert.Equal(t, policy.warning, merged.warning)
+
+	// The policy itself is left untouched
+	assert.Equal(t, 4*time.Hour, policy.targets["critical"][slaStageTriage])
+	assert.NotContains(t, policy.targets, "high")
+}
+
+func Test_getSLAScopes(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	p.SetAPI(mockPluginAPI)
+	config := &configuration{HackeroneSLANew: 3, HackeroneSLAPolicies: "critical: triage=4h"}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+
+	subs := []*Subscription{
+		{ID: "sub1", ChannelID: "channel1"},
+		{ID: "sub2", ChannelID: "channel2", SLAPolicies: "critical: triage=12h"},
+		{ID: "sub3", ChannelID: "channel3", SLADisabled: true},
+		{ID: "sub4", ChannelID: "channel4", SLAPolicies: "critical: triage=soon"},
+	}
+	scopes := p.getSLAScopes(subs)
+
+	require.Len(t, scopes, 2)
+	assert.Equal(t, "", scopes[0].id)
+	assert.Equal(t, policy, scopes[0].policy)
+	assert.Equal(t, []*Subscription{subs[0], subs[3]}, scopes[0].subscriptions, "invalid overrides fall back to the SLA settings")
+	assert.Equal(t, "sub2", scopes[1].id)
+	assert.Equal(t, 12*time.Hour, scopes[1].policy.targets["critical"][slaStageTriage])
+	assert.Equal(t, []*Subscription{subs[1]}, scopes[1].subscriptions)
+	mockPluginAPI.AssertNumberOfCalls(t, "LogWarn", 1)
+
+	// The global scope is evaluated without subscriptions, for its escalations
+	scopes = p.getSLAScopes(subs[1:3])
+	require.Len(t, scopes, 2)
+	assert.Empty(t, scopes[0].subscriptions)
+	assert.True(t, scopes[0].evaluates(slaStageTriage))
+	assert.False(t, (&slaScope{id: "sub5", policy: policy}).evaluates(slaStageTriage))
+}
+
+func Test_notifyMissedDeadlineReportsPerScope(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	config := &configuration{
+		HackeroneSLANew:              3,
+		HackeroneSLABounty:           7,
+		HackeroneSLATriaged:          15,
+		HackeroneSLAPolicies:         "critical: triage=4h",
+		HackeroneSLAWarningThreshold: "2h",
+	}
+	policy, err := config.newSLAPolicyFromSettings()
+	require.NoError(t, err)
+	config.slaPolicy = policy
+	p.setConfiguration(config)
+	require.NoError(t, p.StoreSubscriptions([]*Subscription{
+		{ID: "sub1", ChannelID: "channel1"},
+		{ID: "sub2", ChannelID: "channel2", SLAPolicies: "critical: triage=12h"},
+		{ID: "sub3", ChannelID: "channel3", SLADisabled: true},
+	}))
+
+	now := time.Now()
+	stubHackeroneReportList(p,
+		newReportJSON("1", "critical", now.Add(-11*time.Hour)),
+		newReportJSON("2", "critical", now.Add(-13*time.Hour)),
+	)
+
+	posts := map[string][]*model.Post{}
+	mockPluginAPI.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		posts[post.ChannelId] = append(posts[post.ChannelId], post)
+	}).Return(&model.Post{}, nil)
+	titles := func(post *model.Post) []string {
+		result := []string{}
+		for _, attachment := range post.Attachments() {
+			result = append(result, attachment.Title)
+		}
+		return result
+	}
+
+	require.NoError(t, p.notifyMissedDeadlineReports())
+
+	require.Len(t, posts["channel1"], 1)
+	assert.Contains(t, posts["channel1"][0].Message, "Missed SLA Deadline - New Reports")
+	assert.Equal(t, []string{"Report 1", "Report 2"}, titles(posts["channel1"][0]))
+
+	require.Len(t, posts["channel2"], 2)
+	assert.Contains(t, posts["channel2"][0].Message, "Missed SLA Deadline - New Reports")
+	assert.Equal(t, []string{"Report 2"}, titles(posts["channel2"][0]))
+	fields := posts["channel2"][0].Attachments()[0].Fields
+	assert.Contains(t, fields[len(fields)-1].Value.(string), "Triage target for critical severity: 12h")
+	assert.Contains(t, posts["channel2"][1].Message, "SLA Deadline at Risk - New Reports")
+	assert.Equal(t, []string{"Report 1"}, titles(posts["channel2"][1]))
+
+	assert.Empty(t, posts["channel3"], "subscriptions opted out of the SLA are not notified")
+
+	breaches, err := p.getSLABreaches()
+	require.NoError(t, err)
+	assert.Len(t, breaches, 3)
+	assert.Equal(t, "sub2", breaches[slaBreachKey("2", slaStageTriage)+"/sub2"].Scope)
+
+	t.Run("Breaches of removed overrides are forgotten", func(t *testing.T) {
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{
+			{ID: "sub1", ChannelID: "channel1"},
+			{ID: "sub2", ChannelID: "channel2", SLADisabled: true},
+		}))
+		posts = map[string][]*model.Post{}
+		require.NoError(t, p.notifyMissedDeadlineReports())
+		assert.Empty(t, posts)
+
+		breaches, err = p.getSLABreaches()
+		require.NoError(t, err)
+		assert.Len(t, breaches, 2)
+		assert.NotContains(t, breaches, slaBreachKey("2", slaStageTriage)+"/sub2")
+	})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newSLAPolicy(t *testing.T) {
+	defaults := (&configuration{HackeroneSLANew: 3, HackeroneSLABounty: 7, HackeroneSLATriaged: 15}).defaultSLATargets()
+
+	t.Run("Targets per severity", func(t *testing.T) {
+		policy, err := newSLAPolicy("critical: triage=4h bounty=1d resolve=7d\n\n# slower for low\nLow: triage=1w", defaults)
+		require.NoError(t, err)
+		assert.Equal(t, 4*time.Hour, policy.Target("critical", slaStageTriage))
+		assert.Equal(t, 7*24*time.Hour, policy.Target("critical", slaStageResolve))
+		assert.Equal(t, 7*24*time.Hour, policy.Target("low", slaStageTriage))
+		assert.Equal(t, 7*24*time.Hour, policy.Target("low", slaStageBounty), "missing stages use the default target")
+		assert.Equal(t, 3*24*time.Hour, policy.Target("medium", slaStageTriage), "missing severities use the default target")
+		assert.Equal(t, 4*time.Hour, policy.MinTarget(slaStageTriage))
+		assert.Equal(t, 7*24*time.Hour, policy.MinTarget(slaStageResolve))
+	})
+
+	for _, text := range []string{
+		"urgent: triage=4h",
+		"critical",
+		"critical: ",
+		"critical: triage=soon",
+		"critical: review=4h",
+		"critical: triage",
+		"critical: triage=4h\ncritical: bounty=1d",
+	} {
+		t.Run("Invalid "+text, func(t *testing.T) {
+			_, err := newSLAPolicy(text, defaults)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_slaStatus_Breached(t *testing.T) {
+	now := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	policy, err := newSLAPolicy("critical: triage=4h", map[slaStage]time.Duration{slaStageTriage: 3 * 24 * time.Hour})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		severity  string
+		createdAt string
+		want      bool
+	}{
+		{name: "critical within target", severity: "critical", createdAt: "2021-10-11T09:00:00.000Z", want: false},
+		{name: "critical past target", severity: "critical", createdAt: "2021-10-11T07:00:00.000Z", want: true},
+		{name: "low within default target", severity: "low", createdAt: "2021-10-09T12:00:00.000Z", want: false},
+		{name: "low past default target", severity: "low", createdAt: "2021-10-08T11:00:00.000Z", want: true},
+		{name: "unrated past default target", severity: "", createdAt: "2021-10-01T00:00:00.000Z", want: true},
+		{name: "invalid creation date", severity: "critical", createdAt: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &Report{}
+			report.Attributes.CreatedAt = tt.createdAt
+			report.Relationships.Severity.Data.Attributes.Rating = tt.severity
+			status, ok := policy.Evaluate(report, slaStageTriage)
+			assert.Equal(t, tt.want, ok && status.Breached(now))
+		})
+	}
+}
+
+func Test_slaPolicy_Evaluate(t *testing.T) {
+	now := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	policy, err := newSLAPolicy("critical: triage=4h bounty=2d resolve=7d", map[slaStage]time.Duration{
+		slaStageTriage:  3 * 24 * time.Hour,
+		slaStageBounty:  7 * 24 * time.Hour,
+		slaStageResolve: 15 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	type attributes struct {
+		state, createdAt, triagedAt, bountyAwardedAt, closedAt string
+	}
+	tests := []struct {
+		name         string
+		stage        slaStage
+		severity     string
+		report       attributes
+		wantInStage  bool
+		wantOpen     bool
+		wantElapsed  time.Duration
+		wantBreached bool
+	}{
+		{
+			name: "triage: new report within target", stage: slaStageTriage, severity: "critical",
+			report:      attributes{state: "new", createdAt: "2021-10-11T09:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 3 * time.Hour,
+		},
+		{
+			name: "triage: new report past target", stage: slaStageTriage, severity: "critical",
+			report:      attributes{state: "new", createdAt: "2021-10-11T07:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 5 * time.Hour, wantBreached: true,
+		},
+		{
+			name: "triage: triaged report left the stage", stage: slaStageTriage, severity: "critical",
+			report:      attributes{state: "triaged", createdAt: "2021-10-01T00:00:00Z", triagedAt: "2021-10-01T06:00:00Z"},
+			wantInStage: true, wantElapsed: 6 * time.Hour, wantBreached: true,
+		},
+		{
+			name: "triage: report closed before triage", stage: slaStageTriage, severity: "low",
+			report:      attributes{state: "spam", createdAt: "2021-10-01T00:00:00Z", closedAt: "2021-10-02T00:00:00Z"},
+			wantInStage: true, wantElapsed: 24 * time.Hour,
+		},
+		{
+			name: "bounty: measured from triage, not submission", stage: slaStageBounty, severity: "critical",
+			report:      attributes{state: "triaged", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-10T12:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 24 * time.Hour,
+		},
+		{
+			name: "bounty: awaiting bounty past target", stage: slaStageBounty, severity: "critical",
+			report:      attributes{state: "triaged", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-08T12:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 3 * 24 * time.Hour, wantBreached: true,
+		},
+		{
+			name: "bounty: resolved without a bounty", stage: slaStageBounty, severity: "medium",
+			report:      attributes{state: "resolved", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z", closedAt: "2021-10-05T12:00:00Z"},
+			wantInStage: true, wantElapsed: 4 * 24 * time.Hour,
+		},
+		{
+			name: "bounty: awarded", stage: slaStageBounty, severity: "medium",
+			report:      attributes{state: "triaged", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z", bountyAwardedAt: "2021-10-03T12:00:00Z"},
+			wantInStage: true, wantElapsed: 2 * 24 * time.Hour,
+		},
+		{
+			name: "bounty: closed as duplicate", stage: slaStageBounty, severity: "medium",
+			report:      attributes{state: "duplicate", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z", closedAt: "2021-10-02T12:00:00Z"},
+			wantInStage: true, wantElapsed: 24 * time.Hour,
+		},
+		{
+			name: "bounty: not triaged yet", stage: slaStageBounty, severity: "critical",
+			report: attributes{state: "new", createdAt: "2021-09-01T00:00:00Z"},
+		},
+		{
+			name: "resolve: measured from triage", stage: slaStageResolve, severity: "high",
+			report:      attributes{state: "triaged", createdAt: "2021-01-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z", bountyAwardedAt: "2021-10-02T12:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 10 * 24 * time.Hour,
+		},
+		{
+			name: "resolve: open past target", stage: slaStageResolve, severity: "critical",
+			report:      attributes{state: "triaged", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z"},
+			wantInStage: true, wantOpen: true, wantElapsed: 10 * 24 * time.Hour, wantBreached: true,
+		},
+		{
+			name: "resolve: resolved", stage: slaStageResolve, severity: "critical",
+			report:      attributes{state: "resolved", createdAt: "2021-09-01T00:00:00Z", triagedAt: "2021-10-01T12:00:00Z", closedAt: "2021-10-04T12:00:00Z"},
+			wantInStage: true, wantElapsed: 3 * 24 * time.Hour,
+		},
+		{
+			name: "resolve: not triaged yet", stage: slaStageResolve, severity: "critical",
+			report: attributes{state: "new", createdAt: "2021-09-01T00:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &Report{}
+			report.Attributes.State = tt.report.state
+			report.Attributes.CreatedAt = tt.report.createdAt
+			report.Attributes.TriagedAt = tt.report.triagedAt
+			report.Attributes.BountyAwardedAt = tt.report.bountyAwardedAt
+			report.Attributes.ClosedAt = tt.report.closedAt
+			report.Relationships.Severity.Data.Attributes.Rating = tt.severity
+
+			status, ok := policy.Evaluate(report, tt.stage)
+			require.Equal(t, tt.wantInStage, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.wantOpen, status.ExitedAt.IsZero())
+			assert.Equal(t, tt.wantElapsed, status.Elapsed(now))
+			assert.Equal(t, tt.wantBreached, status.Breached(now))
+		})
+	}
+}
+
+func Test_getDeadlineReportFilter(t *testing.T) {
+	tests := []struct {
+		stage       slaStage
+		wantTimeKey string
+		want        map[string]string
+	}{
+		{
+			stage:       slaStageTriage,
+			wantTimeKey: "created_at__lt",
+			want:        map[string]string{"state": "new", "triaged_at__null": "true"},
+		},
+		{
+			stage:       slaStageBounty,
+			wantTimeKey: "triaged_at__lt",
+			want:        map[string]string{"state": "triaged", "bounty_awarded_at__null": "true"},
+		},
+		{
+			stage:       slaStageResolve,
+			wantTimeKey: "triaged_at__lt",
+			want:        map[string]string{"state": "triaged", "closed_at__null": "true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.stage), func(t *testing.T) {
+			filters := getDeadlineReportFilter(tt.stage, 4*time.Hour)
+			enteredBefore, err := time.Parse(time.RFC3339, filters[tt.wantTimeKey])
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(-4*time.Hour), enteredBefore, time.Minute)
+
+			delete(filters, tt.wantTimeKey)
+			assert.Equal(t, tt.want, filters)
+		})
+	}
+}
+
+// matchesDeadlineReportFilter reports whether the report matches the state and null conditions
+// of the filters, the time conditions aside.
+func matchesDeadlineReportFilter(report *Report, filters map[string]string) bool {
+	fields := map[string]string{
+		"triaged_at__null":        report.Attributes.TriagedAt,
+		"bounty_awarded_at__null": report.Attributes.BountyAwardedAt,
+		"closed_at__null":         report.Attributes.ClosedAt,
+	}
+	for key, value := range filters {
+		switch {
+		case key == "state":
+			if report.Attributes.State != value {
+				return false
+			}
+		case strings.HasSuffix(key, "__null"):
+			if (fields[key] == "") != (value == "true") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func Test_getDeadlineReportFilter_stageInterval(t *testing.T) {
+	newReport := func(state string, triagedAt string, bountyAwardedAt string, closedAt string) *Report {
+		report := newMetricsReport("1", "high", state, "2021-10-01T00:00:00Z", triagedAt, bountyAwardedAt, closedAt)
+		return &report
+	}
+	reports := map[string]*Report{
+		"new":                       newReport("new", "", "", ""),
+		"triaged":                   newReport("triaged", "2021-10-02T00:00:00Z", "", ""),
+		"triaged with a bounty":     newReport("triaged", "2021-10-02T00:00:00Z", "2021-10-03T00:00:00Z", ""),
+		"resolved without a bounty": newReport("resolved", "2021-10-02T00:00:00Z", "", "2021-10-04T00:00:00Z"),
+		"resolved with a bounty":    newReport("resolved", "2021-10-02T00:00:00Z", "2021-10-03T00:00:00Z", "2021-10-04T00:00:00Z"),
+		"closed as duplicate":       newReport("duplicate", "2021-10-02T00:00:00Z", "", "2021-10-03T00:00:00Z"),
+		"closed as spam":            newReport("spam", "", "", "2021-10-03T00:00:00Z"),
+	}
+	for _, stage := range []slaStage{slaStageTriage, slaStageBounty, slaStageResolve} {
+		filters := getDeadlineReportFilter(stage, 0)
+		for name, report := range reports {
+			_, exitedAt, inStage := stageInterval(report, stage)
+			assert.Equal(t, inStage && exitedAt.IsZero(), matchesDeadlineReportFilter(report, filters), "%s report in the %s stage", name, stage)
+		}
+	}
+}
+
+func Test_parseSLAWarning(t *testing.T) {
+	warning, err := parseSLAWarning("")
+	require.NoError(t, err)
+	assert.Nil(t, warning)
+
+	warning, err = parseSLAWarning("24h")
+	require.NoError(t, err)
+	assert.Equal(t, &slaWarning{before: 24 * time.Hour}, warning)
+
+	warning, err = parseSLAWarning(" 80% ")
+	require.NoError(t, err)
+	assert.Equal(t, &slaWarning{percent: 80}, warning)
+
+	for _, value := range []string{"soon", "0%", "100%", "x%"} {
+		_, err = parseSLAWarning(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func Test_slaPolicy_AtRisk(t *testing.T) {
+	now := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	defaults := map[slaStage]time.Duration{slaStageTriage: 10 * time.Hour}
+	tests := []struct {
+		name      string
+		warning   *slaWarning
+		createdAt string
+		want      bool
+	}{
+		{name: "no warning", warning: nil, createdAt: "2021-10-11T03:00:00Z", want: false},
+		{name: "before the time threshold", warning: &slaWarning{before: 2 * time.Hour}, createdAt: "2021-10-11T05:00:00Z", want: false},
+		{name: "within the time threshold", warning: &slaWarning{before: 2 * time.Hour}, createdAt: "2021-10-11T03:00:00Z", want: true},
+		{name: "below the share threshold", warning: &slaWarning{percent: 80}, createdAt: "2021-10-11T05:00:00Z", want: false},
+		{name: "above the share threshold", warning: &slaWarning{percent: 80}, createdAt: "2021-10-11T04:00:00Z", want: true},
+		{name: "already breached", warning: &slaWarning{percent: 80}, createdAt: "2021-10-11T01:00:00Z", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &slaPolicy{defaults: defaults, warning: tt.warning}
+			report := &Report{}
+			report.Attributes.CreatedAt = tt.createdAt
+			status, ok := policy.Evaluate(report, slaStageTriage)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, policy.AtRisk(status, now))
+		})
+	}
+
+	t.Run("Warning horizon", func(t *testing.T) {
+		assert.Equal(t, 10*time.Hour, (&slaPolicy{defaults: defaults}).WarningHorizon(slaStageTriage))
+		assert.Equal(t, 8*time.Hour, (&slaPolicy{defaults: defaults, warning: &slaWarning{before: 2 * time.Hour}}).WarningHorizon(slaStageTriage))
+		assert.Equal(t, 0*time.Hour, (&slaPolicy{defaults: defaults, warning: &slaWarning{before: 24 * time.Hour}}).WarningHorizon(slaStageTriage))
+		assert.Equal(t, 8*time.Hour, (&slaPolicy{defaults: defaults, warning: &slaWarning{percent: 80}}).WarningHorizon(slaStageTriage))
+	})
+}
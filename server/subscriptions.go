@@ -4,6 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -12,6 +17,9 @@ import (
 
 const (
 	SubscriptionsKey = "subscriptions"
+	// Reports currently matching a query subscription are stored per subscription, eg:
+	// query-members-<subscription id>
+	queryMembersKeyPrefix = "query-members-"
 )
 
 type Subscription struct {
@@ -19,6 +27,30 @@ type Subscription struct {
 	ChannelID string
 	CreatorID string
 	ReportID  string
+	// Query restricts the subscription to the reports matching a saved report filter, eg:
+	// state=triaged severity>=high
+	Query string
+	// SummaryOnly subscriptions never receive detailed vulnerability information.
+	SummaryOnly bool
+	// Paused subscriptions receive no notifications until they are resumed or, when set,
+	// until PausedUntil in milliseconds.
+	Paused      bool
+	PausedUntil int64
+	// SLAPolicies overrides the SLA targets per severity for the channel, in the format of the
+	// SLA policies setting.
+	SLAPolicies string
+	// SLADisabled subscriptions receive no SLA notifications.
+	SLADisabled bool
+}
+
+// IsAllReports reports whether the subscription notifies about every report.
+func (s *Subscription) IsAllReports() bool {
+	return s.ReportID == "" && s.Query == ""
+}
+
+// IsPaused reports whether the subscription is silenced at the given time in milliseconds.
+func (s *Subscription) IsPaused(now int64) bool {
+	return s.Paused && (s.PausedUntil == 0 || now < s.PausedUntil)
 }
 
 type Subscriptions struct {
@@ -30,18 +62,21 @@ func generateUUIDName() string {
 	return (id.String())
 }
 
-func (p *Plugin) Subscribe(userID string, channelID string, reportID string) error {
+func (p *Plugin) Subscribe(userID string, channelID string, reportID string, query string, summaryOnly bool) error {
 	sub := &Subscription{
-		ID:        generateUUIDName(),
-		ChannelID: channelID,
-		CreatorID: userID,
-		ReportID:  reportID,
+		ID:          generateUUIDName(),
+		ChannelID:   channelID,
+		CreatorID:   userID,
+		ReportID:    reportID,
+		Query:       query,
+		SummaryOnly: summaryOnly,
 	}
 
 	if err := p.AddSubscription(sub); err != nil {
 		return err
 	}
 
+	p.seedQueryMembers(sub)
 	return nil
 }
 
@@ -61,55 +96,66 @@ func (p *Plugin) GetSubscriptionsByChannel(channelID string) ([]*Subscription, e
 	return filteredSubs, nil
 }
 
+// AddSubscription stores the subscription unless it conflicts with an existing subscription
+// of the channel. A subscription to all reports replaces the channel's single report subscriptions.
 func (p *Plugin) AddSubscription(sub *Subscription) error {
-	subs, err := p.GetSubscriptions()
+	var replaced []*Subscription
+	err := p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		var newSubs []*Subscription
+		var err error
+		newSubs, replaced, err = addSubscription(subs, sub)
+		return newSubs, err
+	})
 	if err != nil {
-		return errors.Wrap(err, "could not get subscriptions")
+		return err
+	}
+
+	for _, v := range replaced {
+		p.deleteQueryMembers(v.ID)
 	}
+	return nil
+}
+
+// addSubscription returns subs with sub added, applying the rules of AddSubscription, along with
+// the subscriptions it replaced.
+func addSubscription(subs []*Subscription, sub *Subscription) ([]*Subscription, []*Subscription, error) {
+	replaced := []*Subscription{}
 	for _, v := range subs {
 		if v.ChannelID == sub.ChannelID {
 			if len(sub.ReportID) > 0 && len(v.ReportID) > 0 && v.ReportID == sub.ReportID {
-				return errors.New(fmt.Sprintf("This channel is already subscribed to receive notifications for the report ID: %s", v.ReportID))
+				return nil, nil, errors.New(fmt.Sprintf("This channel is already subscribed to receive notifications for the report ID: %s", v.ReportID))
+			}
+
+			if len(sub.Query) > 0 && v.Query == sub.Query {
+				return nil, nil, errors.New(fmt.Sprintf("This channel is already subscribed to receive notifications for the query: %s", v.Query))
 			}
 
-			if len(v.ReportID) == 0 {
-				return errors.New("This channel is already subscribed to receive notifications for all reports")
+			if v.IsAllReports() {
+				return nil, nil, errors.New("This channel is already subscribed to receive notifications for all reports")
 			}
 
 			// If user is trying to add subscription for all reports when existing subscription exists for individual reports, then delete the previous subscriptions
-			if len(sub.ReportID) == 0 {
+			if sub.IsAllReports() {
 				newSubs := []*Subscription{}
 
 				for _, newSub := range subs {
 					if sub.ChannelID != newSub.ChannelID {
 						newSubs = append(newSubs, newSub)
+					} else {
+						replaced = append(replaced, newSub)
 					}
 				}
 				subs = newSubs
 				break
 			}
-
 		}
 	}
 
-	subs = append(subs, sub)
-
-	err = p.StoreSubscriptions(subs)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return append(subs, sub), replaced, nil
 }
 
-func (p *Plugin) GetSubscriptions() ([]*Subscription, error) {
+func decodeSubscriptions(value []byte) ([]*Subscription, error) {
 	var subscriptions []*Subscription
-
-	value, appErr := p.API.KVGet(SubscriptionsKey)
-	if appErr != nil {
-		return nil, errors.Wrap(appErr, "could not get subscriptions from KVStore")
-	}
-
 	if value == nil {
 		return []*Subscription{}, nil
 	}
@@ -122,6 +168,15 @@ func (p *Plugin) GetSubscriptions() ([]*Subscription, error) {
 	return subscriptions, nil
 }
 
+func (p *Plugin) GetSubscriptions() ([]*Subscription, error) {
+	value, appErr := p.API.KVGet(SubscriptionsKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get subscriptions from KVStore")
+	}
+
+	return decodeSubscriptions(value)
+}
+
 func (p *Plugin) StoreSubscriptions(s []*Subscription) error {
 	b, err := json.Marshal(s)
 	if err != nil {
@@ -135,31 +190,110 @@ func (p *Plugin) StoreSubscriptions(s []*Subscription) error {
 	return nil
 }
 
+// modifySubscriptions atomically replaces the stored subscriptions with the result of modify,
+// so that concurrent changes from other users or cluster nodes are never lost.
+func (p *Plugin) modifySubscriptions(modify func(subs []*Subscription) ([]*Subscription, error)) error {
+	return p.atomicModify(SubscriptionsKey, func(value []byte) ([]byte, error) {
+		subs, err := decodeSubscriptions(value)
+		if err != nil {
+			return nil, err
+		}
+
+		subs, err = modify(subs)
+		if err != nil || subs == nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(subs)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while converting subscriptions to json")
+		}
+		return b, nil
+	})
+}
+
 func (p *Plugin) Unsubscribe(id string) error {
-	subs, err := p.GetSubscriptions()
+	err := p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		newSubs := []*Subscription{}
+		for _, sub := range subs {
+			if sub.ID != id {
+				newSubs = append(newSubs, sub)
+			}
+		}
+		return newSubs, nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "could not get subscriptions")
+		return errors.Wrap(err, "could not store subscriptions")
 	}
 
-	newSubs := []*Subscription{}
+	p.deleteQueryMembers(id)
+	return nil
+}
 
-	for _, sub := range subs {
-		if sub.ID != id {
-			newSubs = append(newSubs, sub)
+// checkSubscriptionConflict returns an error if the subscription overlaps with another
+// subscription of the same channel.
+func checkSubscriptionConflict(subs []*Subscription, sub *Subscription) error {
+	for _, v := range subs {
+		if v.ID == sub.ID || v.ChannelID != sub.ChannelID {
+			continue
 		}
-	}
 
-	if err := p.StoreSubscriptions(newSubs); err != nil {
-		return errors.Wrap(err, "could not store subscriptions")
+		if len(sub.ReportID) > 0 && v.ReportID == sub.ReportID {
+			return errors.New(fmt.Sprintf("This channel is already subscribed to receive notifications for the report ID: %s", v.ReportID))
+		}
+
+		if len(sub.Query) > 0 && v.Query == sub.Query {
+			return errors.New(fmt.Sprintf("This channel is already subscribed to receive notifications for the query: %s", v.Query))
+		}
+
+		if v.IsAllReports() {
+			return errors.New("This channel is already subscribed to receive notifications for all reports")
+		}
+
+		if sub.IsAllReports() {
+			return errors.New("This channel is already subscribed to individual reports or queries. Please delete those subscriptions before subscribing to all reports")
+		}
 	}
 
 	return nil
 }
 
+// UpdateSubscription atomically applies update to the subscription with the given ID and
+// returns the updated subscription.
+func (p *Plugin) UpdateSubscription(id string, update func(sub *Subscription) error) (*Subscription, error) {
+	var updated *Subscription
+	err := p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		for i, v := range subs {
+			if v.ID != id {
+				continue
+			}
+
+			sub := *v
+			if err := update(&sub); err != nil {
+				return nil, err
+			}
+			if err := checkSubscriptionConflict(subs, &sub); err != nil {
+				return nil, err
+			}
+
+			subs[i] = &sub
+			updated = &sub
+			return subs, nil
+		}
+
+		return nil, errors.Errorf("could not find the subscription `%s`. You can run the command '/hackerone subscriptions list' to get the subscriptionId", id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
 func (p *Plugin) executeSubscriptions(args *model.CommandArgs, split []string) (*model.CommandResponse, *model.AppError) {
 	if 0 >= len(split) {
-		msg := "Invalid subscribe command. Available commands are 'list', 'add' and 'delete'."
-		return p.sendEphemeralResponse(args, msg), nil
+		msg := "Invalid subscribe command. Available commands are 'list', 'add', 'edit', 'pause', 'resume', 'delete', 'export' and 'import'."
+		return p.sendEphemeralError(args, msg), nil
 	}
 
 	command := split[0]
@@ -167,34 +301,105 @@ func (p *Plugin) executeSubscriptions(args *model.CommandArgs, split []string) (
 	switch {
 	case command == "list":
 		return p.handleSubscriptionsList(args)
+	case command == "export":
+		return p.handleSubscriptionsExport(args)
+	case command == "import":
+		positional, flags := parseCommandFlags(split[1:], "dry-run", "replace")
+		dryRun, err := parseBoolFlag(flags, "dry-run")
+		if err != nil {
+			return p.sendEphemeralError(args, "Invalid import option: "+err.Error()), nil
+		}
+		replace, err := parseBoolFlag(flags, "replace")
+		if err != nil {
+			return p.sendEphemeralError(args, "Invalid import option: "+err.Error()), nil
+		}
+		fileID := ""
+		if len(positional) > 0 {
+			fileID = positional[0]
+		}
+		return p.handleSubscriptionsImport(args, fileID, dryRun, replace)
+	case command == "edit" || command == "pause" || command == "resume":
+		split, sla, hasSLA := splitTrailingFlag(split, "sla")
+		split, query, hasQuery := splitTrailingFlag(split, "query")
+		positional, flags := parseCommandFlags(split[1:])
+		if hasQuery {
+			flags["query"] = query
+		}
+		if hasSLA {
+			flags["sla"] = sla
+		}
+		if len(positional) == 0 {
+			msg := fmt.Sprintf("Please specify the subscriptionId to %s. You can run the command '/hackerone subscriptions list' to get the subscriptionId.", command)
+			return p.sendEphemeralError(args, msg), nil
+		}
+		switch command {
+		case "edit":
+			return p.handleSubscriptionEdit(args, positional[0], flags)
+		case "pause":
+			return p.handleSubscriptionPause(args, positional[0], flags["until"])
+		default:
+			return p.handleSubscriptionResume(args, positional[0])
+		}
 	case command == "add":
+		split, query, hasQuery := splitTrailingFlag(split, "query")
+		if hasQuery {
+			if len(split) >= 2 {
+				msg := "A subscription notifies about either a report or the reports matching a query, please specify only one of the reportId and `--query`."
+				return p.sendEphemeralError(args, msg), nil
+			}
+			return p.handleSubscribesAdd(args, "", query)
+		}
 		reportId := ""
 		if len(split) >= 2 {
 			reportId = split[1]
 		}
-		return p.handleSubscribesAdd(args, reportId)
+		return p.handleSubscribesAdd(args, reportId, "")
 	case command == "delete":
 		if len(split) < 2 {
 			msg := "Please specify the subscriptionId to be removed. You can run the command '/hackerone subscriptions list' to get the subscriptionId."
-			return p.sendEphemeralResponse(args, msg), nil
+			return p.sendEphemeralError(args, msg), nil
 		} else {
 			return p.handleUnsubscribe(args, split[1])
 		}
 	default:
-		msg := "Unknown subcommand for subscribe command. Available commands are 'list', 'add' and 'delete'."
-		return p.sendEphemeralResponse(args, msg), nil
+		msg := "Unknown subcommand for subscribe command. Available commands are 'list', 'add', 'edit', 'pause', 'resume', 'delete', 'export' and 'import'."
+		return p.sendEphemeralError(args, msg), nil
 	}
 }
 
-func (p *Plugin) handleSubscribesAdd(args *model.CommandArgs, reportID string) (*model.CommandResponse, *model.AppError) {
-	err := p.Subscribe(args.UserId, args.ChannelId, reportID)
+func (p *Plugin) handleSubscribesAdd(args *model.CommandArgs, reportID string, query string) (*model.CommandResponse, *model.AppError) {
+	if query != "" {
+		parsed, err := parseReportQuery(query)
+		if err != nil {
+			return p.sendEphemeralError(args, "Invalid query: "+err.Error()), nil
+		}
+		query = parsed.String()
+	}
+
+	title := ""
+	if reportID != "" {
+		report, err := p.validateReportID(reportID)
+		if err != nil {
+			return p.sendEphemeralError(args, err.Error()), nil
+		}
+		title = report.Attributes.Title
+	}
+
+	// Subscriptions in public channels are downgraded to summaries when details are restricted
+	summaryOnly := !p.canShowDetails(args.ChannelId)
+	err := p.Subscribe(args.UserId, args.ChannelId, reportID, query, summaryOnly)
 	if err != nil {
 		msg := err.Error()
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 	msg := "Subscription successful for all Hackerone reports."
 	if len(reportID) > 0 {
-		msg = "Subscription successful for Hackerone report id: " + reportID
+		msg = fmt.Sprintf("Subscription successful for Hackerone report id: %s - %s", reportID, sanitizeInline(title))
+	} else if len(query) > 0 {
+		msg = "Subscription successful for Hackerone reports matching the query: `" + query + "`"
+	}
+	if summaryOnly {
+		msg += "\nThis is a public channel and report details are only shown in private channels and direct messages, hence notifications will only include a summary of the reports."
 	}
 	return p.sendEphemeralResponse(args, msg), nil
 }
@@ -203,34 +408,518 @@ func (p *Plugin) handleUnsubscribe(args *model.CommandArgs, ID string) (*model.C
 	err := p.Unsubscribe(ID)
 	if err != nil {
 		msg := fmt.Sprintf("Something went wrong while unsubscribing. Error: %s\n", err.Error())
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 	msg := "Successfully unsubscribed! The specified channel will not receive Hackerone notifications."
 	return p.sendEphemeralResponse(args, msg), nil
 }
 
+// handleSubscriptionEdit changes the reports a subscription notifies about (--report <id|all> or
+// --query <query>), whether it includes report details (--summary-only true|false) and its SLA
+// targets (--sla <overrides|off|default>).
+func (p *Plugin) handleSubscriptionEdit(args *model.CommandArgs, id string, flags map[string]string) (*model.CommandResponse, *model.AppError) {
+	if len(flags) == 0 {
+		msg := "Please specify what to change, eg: `/hackerone subscriptions edit <subscriptionId> --report 1317168` or `--report all`, `--summary-only true` or `--summary-only false`, or `--query state=triaged severity>=high` and `--sla critical: triage=4h; high: triage=1d` as the last options."
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	// A subscription follows either a report or a query
+	_, hasReport := flags["report"]
+	_, hasQuery := flags["query"]
+	if hasReport && hasQuery {
+		msg := "A subscription notifies about either a report or the reports matching a query, please specify only one of `--report` and `--query`."
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	// The report is checked before the atomic update, which may be retried
+	if reportID, ok := flags["report"]; ok && reportID != "all" {
+		if _, err := p.validateReportID(reportID); err != nil {
+			return p.sendEphemeralError(args, err.Error()), nil
+		}
+	}
+
+	sub, err := p.UpdateSubscription(id, func(sub *Subscription) error {
+		for name, value := range flags {
+			switch name {
+			case "report":
+				if value == "all" {
+					value = ""
+				}
+				sub.ReportID = value
+				sub.Query = ""
+			case "query":
+				query, err := parseReportQuery(value)
+				if err != nil {
+					return errors.Wrap(err, "Invalid query")
+				}
+				sub.Query = query.String()
+				sub.ReportID = ""
+			case "summary-only":
+				summaryOnly, err := strconv.ParseBool(value)
+				if err != nil {
+					return errors.Errorf("invalid value `%s` for --summary-only, use true or false", value)
+				}
+				if !summaryOnly && !p.canShowDetails(sub.ChannelID) {
+					return errors.New("Report details are only shown in private channels and direct messages, hence subscriptions of public channels must remain summary only")
+				}
+				sub.SummaryOnly = summaryOnly
+			case "sla":
+				switch strings.ToLower(strings.TrimSpace(value)) {
+				case SLAOverrideOff:
+					sub.SLAPolicies, sub.SLADisabled = "", true
+				case SLAOverrideDefault:
+					sub.SLAPolicies, sub.SLADisabled = "", false
+				default:
+					overrides, err := parseSLAOverride(value)
+					if err != nil {
+						return errors.Wrap(err, "Invalid SLA override")
+					}
+					sub.SLAPolicies, sub.SLADisabled = overrides, false
+				}
+			default:
+				return errors.Errorf("unknown option `--%s`. Available options are --report, --query, --summary-only and --sla", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return p.sendEphemeralError(args, err.Error()), nil
+	}
+	if _, ok := flags["query"]; ok {
+		p.seedQueryMembers(sub)
+	}
+	if _, ok := flags["report"]; ok {
+		// The subscription does not follow a query anymore
+		p.deleteQueryMembers(sub.ID)
+	}
+
+	msg := fmt.Sprintf("Subscription `%s` updated. It now notifies about %s", sub.ID, describeSubscriptionTarget(sub))
+	if sub.SummaryOnly {
+		msg += " without report details"
+	}
+	msg += describeSubscriptionSLA(sub)
+	return p.sendEphemeralResponse(args, msg+"."), nil
+}
+
+// handleSubscriptionPause silences a subscription, until it is resumed or until the given time.
+func (p *Plugin) handleSubscriptionPause(args *model.CommandArgs, id string, until string) (*model.CommandResponse, *model.AppError) {
+	var pausedUntil int64
+	if until != "" {
+		t, err := parseUntil(until, time.Now())
+		if err != nil {
+			return p.sendEphemeralError(args, "Invalid --until option: "+err.Error()), nil
+		}
+		pausedUntil = model.GetMillisForTime(t)
+	}
+
+	_, err := p.UpdateSubscription(id, func(sub *Subscription) error {
+		sub.Paused = true
+		sub.PausedUntil = pausedUntil
+		return nil
+	})
+	if err != nil {
+		return p.sendEphemeralError(args, err.Error()), nil
+	}
+
+	msg := fmt.Sprintf("Subscription `%s` paused until it is resumed with `/hackerone subscriptions resume %s`.", id, id)
+	if pausedUntil > 0 {
+		msg = fmt.Sprintf("Subscription `%s` paused until %s UTC.", id, formatMillis(pausedUntil))
+	}
+	return p.sendEphemeralResponse(args, msg), nil
+}
+
+func (p *Plugin) handleSubscriptionResume(args *model.CommandArgs, id string) (*model.CommandResponse, *model.AppError) {
+	_, err := p.UpdateSubscription(id, func(sub *Subscription) error {
+		sub.Paused = false
+		sub.PausedUntil = 0
+		return nil
+	})
+	if err != nil {
+		return p.sendEphemeralError(args, err.Error()), nil
+	}
+
+	return p.sendEphemeralResponse(args, fmt.Sprintf("Subscription `%s` resumed.", id)), nil
+}
+
+// describeSubscriptionSLA describes the SLA notifications of a subscription which overrides the
+// SLA settings, empty when it uses them.
+func describeSubscriptionSLA(sub *Subscription) string {
+	switch {
+	case sub.SLADisabled:
+		return " without SLA notifications"
+	case sub.SLAPolicies != "":
+		return " with the SLA targets `" + formatSLAOverride(sub.SLAPolicies) + "`"
+	default:
+		return ""
+	}
+}
+
+// describeSubscriptionStatus shows whether a subscription is active or paused in the list.
+func describeSubscriptionStatus(sub *Subscription, now int64) string {
+	if !sub.IsPaused(now) {
+		return "Active"
+	}
+	if sub.PausedUntil > 0 {
+		return "Paused until " + formatMillis(sub.PausedUntil) + " UTC"
+	}
+	return "Paused"
+}
+
 func (p *Plugin) handleSubscriptionsList(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	subs, err := p.GetSubscriptions()
 	msg := ""
 	if err != nil {
 		msg = fmt.Sprintf("Something went wrong while checking for subscriptions. Error: %s\n", err.Error())
-		return p.sendEphemeralResponse(args, msg), nil
+		return p.sendEphemeralError(args, msg), nil
 	}
 	if len(subs) == 0 {
 		msg = "Currently there are no channels subscribed to receive Hackerone notifications."
 	} else {
 		msg = "##### Channels subscribed to receive Hackerone notifications:\n\n"
-		msg += "| Channel | Type | Subscription ID |\n"
-		msg += "| ----------- | ----------- | ----------- | \n"
+		msg += "| Channel | Type | Status | Created by | Subscription ID |\n"
+		msg += "| ----------- | ----------- | ----------- | ----------- | ----------- |\n"
+		now := model.GetMillis()
+		usernames := map[string]string{}
 		for _, v := range subs {
-			channel, _ := p.API.GetChannel(v.ChannelID)
+			channelName := fmt.Sprintf("Unknown channel (`%s`)", v.ChannelID)
+			if channel, appErr := p.API.GetChannel(v.ChannelID); appErr == nil {
+				channelName = "~" + channel.Name
+				if channel.DeleteAt > 0 {
+					channelName += " (archived)"
+				}
+			}
+			subType := "All Reports"
 			if len(v.ReportID) > 0 {
-				msg += fmt.Sprintf("| ~%s | Report ID =%s | %s |\n", channel.Name, v.ReportID, v.ID)
-			} else {
-				msg += fmt.Sprintf("| ~%s | All Reports | %s |\n", channel.Name, v.ID)
+				subType = "Report ID =" + v.ReportID
+			} else if len(v.Query) > 0 {
+				subType = "Query: `" + v.Query + "`"
+			}
+			if v.SummaryOnly {
+				subType += " (summary only)"
 			}
+			if v.SLADisabled || v.SLAPolicies != "" {
+				subType += " (" + strings.TrimSpace(describeSubscriptionSLA(v)) + ")"
+			}
+			msg += fmt.Sprintf("| %s | %s | %s | %s | %s |\n", channelName, subType, describeSubscriptionStatus(v, now), p.displayUsername(v.CreatorID, usernames), v.ID)
 		}
 	}
 	return p.sendEphemeralResponse(args, msg), nil
 
 }
+
+// isChannelGone reports whether the channel was archived or permanently deleted. Other errors,
+// such as a temporary database failure, are not treated as a deleted channel.
+func isChannelGone(channel *model.Channel, appErr *model.AppError) bool {
+	if appErr != nil {
+		return appErr.StatusCode == http.StatusNotFound
+	}
+	return channel.DeleteAt > 0
+}
+
+// removeChannelSubscriptions removes every subscription of an archived or deleted channel and
+// lets the creator of each subscription know.
+func (p *Plugin) removeChannelSubscriptions(channelID string, channelName string) error {
+	removed := []*Subscription{}
+	err := p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		removed = []*Subscription{}
+		newSubs := []*Subscription{}
+		for _, sub := range subs {
+			if sub.ChannelID == channelID {
+				removed = append(removed, sub)
+			} else {
+				newSubs = append(newSubs, sub)
+			}
+		}
+		if len(removed) == 0 {
+			return nil, nil
+		}
+		return newSubs, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not remove the subscriptions of the channel")
+	}
+
+	for _, sub := range removed {
+		p.API.LogInfo("Removed the subscription of an archived or deleted channel", "subscription_id", sub.ID, "channel_id", channelID)
+		p.deleteQueryMembers(sub.ID)
+		if sub.CreatorID == "" {
+			continue
+		}
+		msg := fmt.Sprintf("The channel %s was archived or deleted, hence your subscription to %s was removed.", channelName, describeSubscriptionTarget(sub))
+		p.sendDirectMessage(sub.CreatorID, msg)
+	}
+
+	return nil
+}
+
+// cleanupSubscriptions removes the subscriptions of channels that were archived or deleted,
+// including channels removed while the plugin was disabled, and those of closed reports.
+func (p *Plugin) cleanupSubscriptions() error {
+	subs, err := p.GetSubscriptions()
+	if err != nil {
+		p.API.LogWarn("Error while cleaning up subscriptions", "error", err.Error())
+		return errors.Wrap(err, "could not get subscriptions")
+	}
+
+	checked := map[string]bool{}
+	for _, sub := range subs {
+		if checked[sub.ChannelID] {
+			continue
+		}
+		checked[sub.ChannelID] = true
+
+		channel, appErr := p.API.GetChannel(sub.ChannelID)
+		if !isChannelGone(channel, appErr) {
+			continue
+		}
+
+		channelName := "`" + sub.ChannelID + "`"
+		if channel != nil {
+			channelName = "~" + channel.Name
+		}
+		if err := p.removeChannelSubscriptions(sub.ChannelID, channelName); err != nil {
+			p.API.LogWarn("Error while removing the subscriptions of a deleted channel", "channel_id", sub.ChannelID, "error", err.Error())
+		}
+	}
+
+	if err := p.expireClosedReportSubscriptions(); err != nil {
+		p.API.LogWarn("Error while removing the subscriptions of closed reports", "error", err.Error())
+	}
+
+	return nil
+}
+
+var reportIDPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// validateReportID makes sure the report exists in the configured program before subscribing to it,
+// so that a typo does not silently create a subscription which never notifies.
+func (p *Plugin) validateReportID(reportID string) (*Report, error) {
+	program := p.getConfiguration().HackeroneProgramHandle
+	if !reportIDPattern.MatchString(reportID) {
+		return nil, errors.Errorf("Invalid report ID `%s`, Hackerone report IDs are numbers such as 1317168", sanitizeInline(reportID))
+	}
+
+	report, err := p.fetchReport(reportID)
+	if isHackeroneNotFound(err) {
+		return nil, errors.Errorf("Could not find the report ID %s in the Hackerone program %s", reportID, program)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Something went wrong while checking the report ID %s on Hackerone", reportID)
+	}
+
+	handle := report.Relationships.Program.Data.Attributes.Handle
+	if handle != "" && !strings.EqualFold(handle, program) {
+		return nil, errors.Errorf("Could not find the report ID %s in the Hackerone program %s", reportID, program)
+	}
+
+	return &report, nil
+}
+
+// closedReportExpired reports whether a report was closed at least the given number of days ago.
+func closedReportExpired(report *Report, days int, now time.Time) bool {
+	if report.Attributes.ClosedAt == "" {
+		return false
+	}
+	closedAt, err := time.Parse(time.RFC3339, report.Attributes.ClosedAt)
+	if err != nil {
+		return false
+	}
+	return !now.Before(closedAt.AddDate(0, 0, days))
+}
+
+// expireClosedReportSubscriptions removes the single-report subscriptions whose report was closed
+// for longer than configured, and tells their channel.
+func (p *Plugin) expireClosedReportSubscriptions() error {
+	days := p.getConfiguration().HackeroneClosedReportSubscriptionDays
+	if days <= 0 {
+		return nil
+	}
+
+	subs, err := p.GetSubscriptions()
+	if err != nil {
+		return errors.Wrap(err, "could not get subscriptions")
+	}
+
+	now := time.Now()
+	expired := map[string]bool{}
+	closed := map[string]bool{}
+	for _, sub := range subs {
+		if sub.ReportID == "" {
+			continue
+		}
+		isClosed, ok := closed[sub.ReportID]
+		if !ok {
+			report, err := p.fetchReport(sub.ReportID)
+			if err != nil {
+				p.API.LogWarn("Error while checking if a subscribed report is closed", "report_id", sub.ReportID, "error", err.Error())
+				continue
+			}
+			isClosed = closedReportExpired(&report, days, now)
+			closed[sub.ReportID] = isClosed
+		}
+		if isClosed {
+			expired[sub.ID] = true
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	removed := []*Subscription{}
+	err = p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+		removed = []*Subscription{}
+		newSubs := []*Subscription{}
+		for _, sub := range subs {
+			if expired[sub.ID] {
+				removed = append(removed, sub)
+			} else {
+				newSubs = append(newSubs, sub)
+			}
+		}
+		if len(removed) == 0 {
+			return nil, nil
+		}
+		return newSubs, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not remove the subscriptions of closed reports")
+	}
+
+	for _, sub := range removed {
+		p.API.LogInfo("Removed the subscription of a closed report", "subscription_id", sub.ID, "report_id", sub.ReportID)
+		msg := fmt.Sprintf("The Hackerone report id: %s was closed more than %d days ago, hence the subscription of this channel to it was removed.", sub.ReportID, days)
+		p.sendPostByChannelId(sub.ChannelID, msg, nil)
+	}
+
+	return nil
+}
+
+// describeSubscriptionTarget explains which reports a subscription notifies about.
+func describeSubscriptionTarget(sub *Subscription) string {
+	if len(sub.ReportID) > 0 {
+		return "the Hackerone report id: " + sub.ReportID
+	}
+	if len(sub.Query) > 0 {
+		return "the Hackerone reports matching `" + sub.Query + "`"
+	}
+	return "all Hackerone reports"
+}
+
+func queryMembersKey(subscriptionID string) string {
+	return queryMembersKeyPrefix + subscriptionID
+}
+
+// deleteQueryMembers forgets the reports matching the query of a removed subscription.
+func (p *Plugin) deleteQueryMembers(subscriptionID string) {
+	if appErr := p.API.KVDelete(queryMembersKey(subscriptionID)); appErr != nil {
+		p.API.LogWarn("Unable to delete the reports matching the query of a subscription", "subscription_id", subscriptionID, "error", appErr.Error())
+	}
+}
+
+// updateQueryMembership records whether the report currently matches the query of the
+// subscription, and returns whether it matched before.
+func (p *Plugin) updateQueryMembership(subscriptionID string, reportID string, matches bool) (bool, error) {
+	wasMember := false
+	err := p.atomicModify(queryMembersKey(subscriptionID), func(value []byte) ([]byte, error) {
+		members := []string{}
+		if value != nil {
+			if err := json.Unmarshal(value, &members); err != nil {
+				return nil, errors.Wrap(err, "could not properly decode query members key")
+			}
+		}
+
+		wasMember = contains(members, reportID)
+		if wasMember == matches {
+			return nil, nil
+		}
+
+		newMembers := []string{}
+		for _, member := range members {
+			if member != reportID {
+				newMembers = append(newMembers, member)
+			}
+		}
+		if matches {
+			newMembers = append(newMembers, reportID)
+		}
+		return json.Marshal(newMembers)
+	})
+	return wasMember, err
+}
+
+// seedQueryMembers records the reports matching the query of a new or edited subscription, so
+// that the channel is told when any of them stops matching.
+func (p *Plugin) seedQueryMembers(sub *Subscription) {
+	if sub.Query == "" {
+		return
+	}
+
+	query, err := parseReportQuery(sub.Query)
+	if err != nil {
+		return
+	}
+
+	reports, err := p.fetchReports(query.Filters())
+	if err != nil {
+		p.API.LogWarn("Unable to fetch the reports matching the query of a subscription", "subscription_id", sub.ID, "error", err.Error())
+		return
+	}
+
+	members := []string{}
+	for i := range reports {
+		if query.Matches(&reports[i]) {
+			members = append(members, reports[i].Id)
+		}
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return
+	}
+	if appErr := p.API.KVSet(queryMembersKey(sub.ID), b); appErr != nil {
+		p.API.LogWarn("Unable to store the reports matching the query of a subscription", "subscription_id", sub.ID, "error", appErr.Error())
+	}
+}
+
+// matchActivity reports whether an activity on the report should reach the subscription.
+// Query subscriptions are re-evaluated with the current state of the report: they also receive
+// the activity which makes a report stop matching, along with a note, and then stop following it.
+func (p *Plugin) matchActivity(sub *Subscription, reportID string, report *Report) (bool, string) {
+	switch {
+	case sub.Query != "":
+		if report == nil {
+			return false, ""
+		}
+		query, err := parseReportQuery(sub.Query)
+		if err != nil {
+			p.API.LogWarn("Invalid subscription query", "subscription_id", sub.ID, "error", err.Error())
+			return false, ""
+		}
+		matches := query.Matches(report)
+		wasMember, err := p.updateQueryMembership(sub.ID, reportID, matches)
+		if err != nil {
+			p.API.LogWarn("Unable to update the reports matching the query of a subscription", "subscription_id", sub.ID, "error", err.Error())
+		}
+		if !matches && wasMember {
+			return true, "This report no longer matches the query `" + sub.Query + "` of this channel's subscription, further activity on it will not be posted here."
+		}
+		return matches, ""
+	case sub.ReportID != "":
+		return sub.ReportID == reportID, ""
+	default:
+		return true, ""
+	}
+}
+
+// matchReport reports whether a report listed by an SLA notification belongs to the subscription.
+func matchReport(sub *Subscription, report *Report) bool {
+	switch {
+	case sub.Query != "":
+		query, err := parseReportQuery(sub.Query)
+		return err == nil && query.Matches(report)
+	case sub.ReportID != "":
+		return sub.ReportID == report.Id
+	default:
+		return true
+	}
+}
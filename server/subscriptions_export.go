@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	subscriptionsExportVersion = 1
+	// subscriptionsImportMaxSize bounds the size of the file read by the import command.
+	subscriptionsImportMaxSize = 1024 * 1024
+)
+
+// SubscriptionsExport is the JSON document written by `subscriptions export` and read by
+// `subscriptions import`. Channels are referenced by team and channel name so the file can be
+// imported on another Mattermost instance.
+type SubscriptionsExport struct {
+	Version       int                     `json:"version"`
+	ExportedAt    string                  `json:"exported_at"`
+	Subscriptions []*ExportedSubscription `json:"subscriptions"`
+}
+
+type ExportedSubscription struct {
+	Team        string `json:"team"`
+	Channel     string `json:"channel"`
+	ReportID    string `json:"report_id,omitempty"`
+	Query       string `json:"query,omitempty"`
+	SummaryOnly bool   `json:"summary_only,omitempty"`
+	Paused      bool   `json:"paused,omitempty"`
+	PausedUntil int64  `json:"paused_until,omitempty"`
+	SLAPolicies string `json:"sla_policies,omitempty"`
+	SLADisabled bool   `json:"sla_disabled,omitempty"`
+}
+
+func (e *ExportedSubscription) channelPath() string {
+	return e.Team + "/" + e.Channel
+}
+
+// exportSubscriptions converts the subscriptions to the export format. Subscriptions of direct
+// and group messages, or of channels that cannot be found, cannot be referenced by name and are
+// returned as skipped.
+func (p *Plugin) exportSubscriptions(subs []*Subscription) (*SubscriptionsExport, []string) {
+	export := &SubscriptionsExport{
+		Version:       subscriptionsExportVersion,
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		Subscriptions: []*ExportedSubscription{},
+	}
+	skipped := []string{}
+	teamNames := map[string]string{}
+	for _, sub := range subs {
+		channel, appErr := p.API.GetChannel(sub.ChannelID)
+		if appErr != nil || channel.TeamId == "" {
+			skipped = append(skipped, sub.ID)
+			continue
+		}
+
+		teamName, ok := teamNames[channel.TeamId]
+		if !ok {
+			team, appErr := p.API.GetTeam(channel.TeamId)
+			if appErr != nil {
+				skipped = append(skipped, sub.ID)
+				continue
+			}
+			teamName = team.Name
+			teamNames[channel.TeamId] = teamName
+		}
+
+		export.Subscriptions = append(export.Subscriptions, &ExportedSubscription{
+			Team:        teamName,
+			Channel:     channel.Name,
+			ReportID:    sub.ReportID,
+			Query:       sub.Query,
+			SummaryOnly: sub.SummaryOnly,
+			Paused:      sub.Paused,
+			PausedUntil: sub.PausedUntil,
+			SLAPolicies: sub.SLAPolicies,
+			SLADisabled: sub.SLADisabled,
+		})
+	}
+
+	return export, skipped
+}
+
+// handleSubscriptionsExport sends the JSON export to the user in a direct message, as it lists
+// private channels which must not be shared with the channel the command was run from.
+func (p *Plugin) handleSubscriptionsExport(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	subs, err := p.GetSubscriptions()
+	if err != nil {
+		msg := fmt.Sprintf("Something went wrong while checking for subscriptions. Error: %s\n", err.Error())
+		return p.sendEphemeralError(args, msg), nil
+	}
+
+	export, skipped := p.exportSubscriptions(subs)
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return p.sendEphemeralError(args, "Something went wrong while exporting the subscriptions. Error: "+err.Error()), nil
+	}
+
+	channel, appErr := p.API.GetDirectChannel(args.UserId, p.BotUserID)
+	if appErr != nil {
+		return p.sendEphemeralError(args, "Something went wrong while sending the export. Error: "+appErr.Error()), nil
+	}
+
+	filename := fmt.Sprintf("hackerone-subscriptions-%s.json", time.Now().UTC().Format("2006-01-02"))
+	fileInfo, appErr := p.API.UploadFile(b, channel.Id, filename)
+	if appErr != nil {
+		return p.sendEphemeralError(args, "Something went wrong while uploading the export. Error: "+appErr.Error()), nil
+	}
+
+	message := fmt.Sprintf("Export of %d Hackerone subscriptions. Upload this file in a channel and run `/hackerone subscriptions import --dry-run` there to check it, then `/hackerone subscriptions import` to import it.", len(export.Subscriptions))
+	if len(skipped) > 0 {
+		message += fmt.Sprintf("\nSubscriptions of direct messages, group messages and unknown channels cannot be exported and were skipped: %s", strings.Join(skipped, ", "))
+	}
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: channel.Id,
+		Message:   message,
+		FileIds:   []string{fileInfo.Id},
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return p.sendEphemeralError(args, "Something went wrong while sending the export. Error: "+appErr.Error()), nil
+	}
+
+	return p.sendEphemeralResponse(args, fmt.Sprintf("Exported %d subscriptions. The file was sent to you in a direct message.", len(export.Subscriptions))), nil
+}
+
+// findImportFile returns the file given by its ID or, by default, the latest JSON file the user
+// uploaded in the channel.
+func (p *Plugin) findImportFile(args *model.CommandArgs, fileID string) (*model.FileInfo, error) {
+	if fileID != "" {
+		info, appErr := p.API.GetFileInfo(fileID)
+		if appErr != nil {
+			return nil, errors.Errorf("could not find the file `%s`", fileID)
+		}
+		if info.CreatorId != args.UserId {
+			return nil, errors.New("only files you uploaded yourself can be imported")
+		}
+		return info, nil
+	}
+
+	infos, appErr := p.API.GetFileInfos(0, 20, &model.GetFileInfosOptions{
+		UserIds:        []string{args.UserId},
+		ChannelIds:     []string{args.ChannelId},
+		SortBy:         model.FileinfoSortByCreated,
+		SortDescending: true,
+	})
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "could not get the files of the channel")
+	}
+	for _, info := range infos {
+		if strings.EqualFold(filepath.Ext(info.Name), ".json") {
+			return info, nil
+		}
+	}
+
+	return nil, errors.New("could not find a JSON file uploaded by you in this channel. Please upload the file created by `/hackerone subscriptions export` first")
+}
+
+func (p *Plugin) readSubscriptionsExport(info *model.FileInfo) (*SubscriptionsExport, error) {
+	if info.Size > subscriptionsImportMaxSize {
+		return nil, errors.Errorf("the file `%s` is too large to be imported", info.Name)
+	}
+
+	data, appErr := p.API.GetFile(info.Id)
+	if appErr != nil {
+		return nil, errors.Wrapf(appErr, "could not read the file `%s`", info.Name)
+	}
+
+	export := &SubscriptionsExport{}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(export); err != nil {
+		return nil, errors.Errorf("the file `%s` is not a valid subscriptions export", info.Name)
+	}
+	if export.Version != subscriptionsExportVersion {
+		return nil, errors.Errorf("the file `%s` has an unsupported export version %d", info.Name, export.Version)
+	}
+
+	return export, nil
+}
+
+// importSubscriptions resolves the channels of the exported subscriptions and adds them to subs
+// with the rules of AddSubscription. Subscriptions to a report which failed the checks of
+// `subscriptions add`, given by reportErrors, are skipped. A subscription to all reports which
+// would replace the subscriptions of its channel is skipped as a conflict, unless replace is set.
+// It returns the resulting subscriptions, a line per exported subscription describing the outcome,
+// the imported subscriptions and the existing subscriptions which were replaced.
+func (p *Plugin) importSubscriptions(subs []*Subscription, export *SubscriptionsExport, userID string, resolved map[string]*model.Channel, reportErrors map[string]error, replace bool) ([]*Subscription, []string, []*Subscription, []*Subscription) {
+	results := []string{}
+	imported := []*Subscription{}
+	replaced := []*Subscription{}
+	for _, e := range export.Subscriptions {
+		target := fmt.Sprintf("%s (%s)", sanitizeInline(e.channelPath()), describeSubscriptionTarget(&Subscription{ReportID: sanitizeInline(e.ReportID), Query: sanitizeInline(e.Query)}))
+		channel := resolved[e.channelPath()]
+		if channel == nil {
+			results = append(results, fmt.Sprintf("* %s: the channel could not be found", target))
+			continue
+		}
+		if err := reportErrors[e.ReportID]; err != nil {
+			results = append(results, fmt.Sprintf("* %s: %s", target, err.Error()))
+			continue
+		}
+
+		query := ""
+		if e.Query != "" {
+			parsed, err := parseReportQuery(e.Query)
+			if err != nil {
+				results = append(results, fmt.Sprintf("* %s: invalid query: %s", target, err.Error()))
+				continue
+			}
+			query = parsed.String()
+		}
+
+		slaPolicies := ""
+		if e.SLAPolicies != "" && !e.SLADisabled {
+			parsed, err := parseSLAOverride(e.SLAPolicies)
+			if err != nil {
+				results = append(results, fmt.Sprintf("* %s: invalid SLA override: %s", target, err.Error()))
+				continue
+			}
+			slaPolicies = parsed
+		}
+
+		sub := &Subscription{
+			ID:          generateUUIDName(),
+			ChannelID:   channel.Id,
+			CreatorID:   userID,
+			ReportID:    e.ReportID,
+			Query:       query,
+			SummaryOnly: e.SummaryOnly || !p.canShowDetails(channel.Id),
+			Paused:      e.Paused,
+			PausedUntil: e.PausedUntil,
+			SLAPolicies: slaPolicies,
+			SLADisabled: e.SLADisabled,
+		}
+		newSubs, replacedSubs, err := addSubscription(subs, sub)
+		if err != nil {
+			results = append(results, fmt.Sprintf("* %s: %s", target, err.Error()))
+			continue
+		}
+
+		if len(replacedSubs) > 0 {
+			descriptions := []string{}
+			for _, v := range replacedSubs {
+				descriptions = append(descriptions, fmt.Sprintf("`%s` (%s)", v.ID, describeSubscriptionTarget(v)))
+			}
+			if !replace {
+				results = append(results, fmt.Sprintf("* %s: would replace the subscriptions %s of the channel, add `--replace` to replace them", target, strings.Join(descriptions, ", ")))
+				continue
+			}
+			target += ", replacing " + strings.Join(descriptions, ", ")
+		}
+
+		subs = newSubs
+		replaced = append(replaced, replacedSubs...)
+		imported = append(imported, sub)
+		results = append(results, fmt.Sprintf("* %s: imported", target))
+	}
+
+	return subs, results, imported, replaced
+}
+
+// handleSubscriptionsImport imports the subscriptions of a file created by the export command.
+// With --dry-run, it only reports what would be imported, and --replace lets subscriptions to all
+// reports replace the subscriptions of their channel.
+func (p *Plugin) handleSubscriptionsImport(args *model.CommandArgs, fileID string, dryRun bool, replace bool) (*model.CommandResponse, *model.AppError) {
+	info, err := p.findImportFile(args, fileID)
+	if err != nil {
+		return p.sendEphemeralError(args, "Unable to import subscriptions: "+err.Error()), nil
+	}
+
+	export, err := p.readSubscriptionsExport(info)
+	if err != nil {
+		return p.sendEphemeralError(args, "Unable to import subscriptions: "+err.Error()), nil
+	}
+
+	// Channels are resolved once, outside of the atomic update which may be retried
+	resolved := map[string]*model.Channel{}
+	for _, e := range export.Subscriptions {
+		if _, ok := resolved[e.channelPath()]; ok {
+			continue
+		}
+		channel, appErr := p.API.GetChannelByNameForTeamName(e.Team, e.Channel, false)
+		if appErr != nil {
+			channel = nil
+		}
+		resolved[e.channelPath()] = channel
+	}
+
+	// Report IDs are checked as `subscriptions add` does, once per report and outside of the
+	// atomic update as well
+	reportErrors := map[string]error{}
+	for _, e := range export.Subscriptions {
+		if e.ReportID == "" || resolved[e.channelPath()] == nil {
+			continue
+		}
+		if _, ok := reportErrors[e.ReportID]; ok {
+			continue
+		}
+		_, reportErrors[e.ReportID] = p.validateReportID(e.ReportID)
+	}
+
+	var results []string
+	var imported []*Subscription
+	if dryRun {
+		subs, err := p.GetSubscriptions()
+		if err != nil {
+			return p.sendEphemeralError(args, "Unable to import subscriptions: "+err.Error()), nil
+		}
+		_, results, imported, _ = p.importSubscriptions(subs, export, args.UserId, resolved, reportErrors, replace)
+	} else {
+		var replaced []*Subscription
+		err = p.modifySubscriptions(func(subs []*Subscription) ([]*Subscription, error) {
+			subs, results, imported, replaced = p.importSubscriptions(subs, export, args.UserId, resolved, reportErrors, replace)
+			if len(imported) == 0 {
+				return nil, nil
+			}
+			return subs, nil
+		})
+		if err != nil {
+			return p.sendEphemeralError(args, "Unable to import subscriptions: "+err.Error()), nil
+		}
+		for _, sub := range replaced {
+			p.deleteQueryMembers(sub.ID)
+		}
+		for _, sub := range imported {
+			p.seedQueryMembers(sub)
+		}
+	}
+
+	msg := fmt.Sprintf("Imported %d of %d subscriptions from `%s`:\n", len(imported), len(export.Subscriptions), info.Name)
+	if dryRun {
+		msg = fmt.Sprintf("Dry run: %d of %d subscriptions from `%s` would be imported:\n", len(imported), len(export.Subscriptions), info.Name)
+	}
+	msg += strings.Join(results, "\n")
+	return p.sendEphemeralResponse(args, msg), nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exportSubscriptions(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", TeamId: "team1", Name: "security"}, nil)
+	mockPluginAPI.On("GetChannel", "dm").Return(&model.Channel{Id: "dm", Type: model.ChannelTypeDirect, Name: "user1__user2"}, nil)
+	mockPluginAPI.On("GetChannel", "missing").Return(nil, model.NewAppError("GetChannel", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound))
+	mockPluginAPI.On("GetTeam", "team1").Return(&model.Team{Id: "team1", Name: "engineering"}, nil).Once()
+	p.SetAPI(mockPluginAPI)
+
+	export, skipped := p.exportSubscriptions([]*Subscription{
+		{ID: "sub1", ChannelID: "channel1", ReportID: "1234", SummaryOnly: true},
+		{ID: "sub2", ChannelID: "dm"},
+		{ID: "sub3", ChannelID: "missing"},
+		{ID: "sub4", ChannelID: "channel1", Paused: true, PausedUntil: 5000},
+		{ID: "sub5", ChannelID: "channel1", SLAPolicies: "critical: triage=4h"},
+	})
+
+	assert.Equal(t, []string{"sub2", "sub3"}, skipped)
+	assert.Equal(t, subscriptionsExportVersion, export.Version)
+	assert.Equal(t, []*ExportedSubscription{
+		{Team: "engineering", Channel: "security", ReportID: "1234", SummaryOnly: true},
+		{Team: "engineering", Channel: "security", Paused: true, PausedUntil: 5000},
+		{Team: "engineering", Channel: "security", SLAPolicies: "critical: triage=4h"},
+	}, export.Subscriptions)
+	mockPluginAPI.AssertExpectations(t)
+}
+
+func Test_handleSubscriptionsImport(t *testing.T) {
+	export, _ := json.Marshal(&SubscriptionsExport{
+		Version: subscriptionsExportVersion,
+		Subscriptions: []*ExportedSubscription{
+			{Team: "engineering", Channel: "security", ReportID: "1234"},
+			{Team: "engineering", Channel: "security", ReportID: "5678"},
+			{Team: "engineering", Channel: "incident", SLAPolicies: "critical:  triage=4h; high: triage=1d"},
+			{Team: "engineering", Channel: "removed"},
+			{Team: "engineering", Channel: "security", SLAPolicies: "critical: triage=soon"},
+			{Team: "engineering", Channel: "triage"},
+			{Team: "engineering", Channel: "security", ReportID: "9999"},
+			{Team: "engineering", Channel: "security", Query: "state=triaged"},
+		},
+	})
+	args := &model.CommandArgs{UserId: "user1", ChannelId: "current"}
+
+	setup := func() (*Plugin, *memoryKVStore, *string) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		store := newMemoryKVStore(mockPluginAPI)
+		mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+		mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"), mock.Anything, mock.Anything)
+		p.SetAPI(mockPluginAPI)
+		p.setConfiguration(&configuration{HackeroneProgramHandle: "security"})
+		stubHackeroneReports(p, map[string]string{
+			"1234":        `{"data":{"id":"1234","attributes":{"title":"XSS in search"}}}`,
+			"5678":        `{"data":{"id":"5678","attributes":{"title":"SSRF in webhooks"}}}`,
+			"/v1/reports": `{"data":[{"id":"77","attributes":{"state":"triaged"}},{"id":"78","attributes":{"state":"new"}}]}`,
+		})
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{
+			{ID: "existing", ChannelID: "security", ReportID: "5678"},
+			{ID: "paused", ChannelID: "triage", ReportID: "42", Paused: true},
+		}))
+
+		mockPluginAPI.On("GetFileInfos", 0, 20, mock.MatchedBy(func(opt *model.GetFileInfosOptions) bool {
+			return opt.UserIds[0] == "user1" && opt.ChannelIds[0] == "current"
+		})).Return([]*model.FileInfo{
+			{Id: "image", Name: "screenshot.png"},
+			{Id: "file1", Name: "hackerone-subscriptions.json", Size: int64(len(export))},
+		}, nil)
+		mockPluginAPI.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", Name: "hackerone-subscriptions.json", CreatorId: "user1", Size: int64(len(export))}, nil)
+		mockPluginAPI.On("GetFile", "file1").Return(export, nil)
+		mockPluginAPI.On("GetChannelByNameForTeamName", "engineering", "security", false).Return(&model.Channel{Id: "security", Type: model.ChannelTypePrivate}, nil)
+		mockPluginAPI.On("GetChannelByNameForTeamName", "engineering", "incident", false).Return(&model.Channel{Id: "incident", Type: model.ChannelTypePrivate}, nil)
+		mockPluginAPI.On("GetChannelByNameForTeamName", "engineering", "triage", false).Return(&model.Channel{Id: "triage", Type: model.ChannelTypePrivate}, nil)
+		mockPluginAPI.On("GetChannelByNameForTeamName", "engineering", "removed", false).Return(nil, model.NewAppError("GetChannelByNameForTeamName", "app.channel.get_by_name.missing.app_error", nil, "", http.StatusNotFound))
+		mockPluginAPI.On("GetChannel", mock.AnythingOfType("string")).Return(&model.Channel{Type: model.ChannelTypePrivate}, nil)
+		message := ""
+		mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			message = args.Get(1).(*model.Post).Message
+		}).Return(&model.Post{})
+		return p, store, &message
+	}
+
+	t.Run("Dry run", func(t *testing.T) {
+		p, store, message := setup()
+		p.executeSubscriptions(args, []string{"import", "--dry-run"})
+
+		assert.Contains(t, *message, "Dry run: 3 of 8 subscriptions from `hackerone-subscriptions.json` would be imported")
+		assert.Contains(t, *message, "engineering/security (the Hackerone report id: 1234): imported")
+		assert.Contains(t, *message, "engineering/security (the Hackerone report id: 5678): This channel is already subscribed to receive notifications for the report ID: 5678")
+		assert.Contains(t, *message, "engineering/incident (all Hackerone reports): imported")
+		assert.Contains(t, *message, "engineering/removed (all Hackerone reports): the channel could not be found")
+		assert.Contains(t, *message, "engineering/security (all Hackerone reports): invalid SLA override")
+		assert.Contains(t, *message, "engineering/triage (all Hackerone reports): would replace the subscriptions `paused` (the Hackerone report id: 42) of the channel, add `--replace` to replace them")
+		assert.Contains(t, *message, "engineering/security (the Hackerone report id: 9999): Could not find the report ID 9999 in the Hackerone program security")
+		assert.Contains(t, *message, "engineering/security (the Hackerone reports matching `state=triaged`): imported")
+
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		assert.Len(t, subs, 2)
+		assert.Len(t, store.values, 1)
+	})
+	t.Run("Dry run of a given file", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"import", "--dry-run", "file1"})
+
+		assert.Contains(t, *message, "Dry run: 3 of 8 subscriptions from `hackerone-subscriptions.json` would be imported")
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		assert.Len(t, subs, 2)
+	})
+	t.Run("Invalid flag value", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"import", "--dry-run=yes"})
+
+		assert.Equal(t, "Invalid import option: invalid value `yes` for `--dry-run`, it takes no value", *message)
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		assert.Len(t, subs, 2)
+	})
+	t.Run("Import", func(t *testing.T) {
+		p, store, message := setup()
+		p.executeSubscriptions(args, []string{"import"})
+
+		assert.Contains(t, *message, "Imported 3 of 8 subscriptions")
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		require.Len(t, subs, 5)
+		assert.Equal(t, "paused", subs[1].ID)
+		assert.Equal(t, "security", subs[2].ChannelID)
+		assert.Equal(t, "1234", subs[2].ReportID)
+		assert.Equal(t, "user1", subs[2].CreatorID)
+		assert.Equal(t, "incident", subs[3].ChannelID)
+		assert.Equal(t, "critical: triage=4h\nhigh: triage=1d", subs[3].SLAPolicies)
+		assert.Equal(t, "state=triaged", subs[4].Query)
+		assert.Equal(t, `["77"]`, string(store.values[queryMembersKey(subs[4].ID)]))
+	})
+	t.Run("Import replacing the subscriptions of a channel", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"import", "--replace"})
+
+		assert.Contains(t, *message, "Imported 4 of 8 subscriptions")
+		assert.Contains(t, *message, "engineering/triage (all Hackerone reports), replacing `paused` (the Hackerone report id: 42): imported")
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		require.Len(t, subs, 5)
+		assert.Equal(t, "triage", subs[3].ChannelID)
+		assert.True(t, subs[3].IsAllReports())
+	})
+}
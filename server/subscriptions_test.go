@@ -0,0 +1,425 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cleanupSubscriptions(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+	require.NoError(t, p.StoreSubscriptions([]*Subscription{
+		{ID: "sub1", ChannelID: "active", CreatorID: "user1"},
+		{ID: "sub2", ChannelID: "archived", CreatorID: "user1", ReportID: "1234"},
+		{ID: "sub3", ChannelID: "deleted", CreatorID: "user2"},
+		{ID: "sub4", ChannelID: "unavailable", CreatorID: "user2"},
+	}))
+
+	mockPluginAPI.On("GetChannel", "active").Return(&model.Channel{Id: "active", Name: "security"}, nil)
+	mockPluginAPI.On("GetChannel", "archived").Return(&model.Channel{Id: "archived", Name: "incident", DeleteAt: 1000}, nil)
+	mockPluginAPI.On("GetChannel", "deleted").Return(nil, model.NewAppError("GetChannel", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound))
+	mockPluginAPI.On("GetChannel", "unavailable").Return(nil, model.NewAppError("GetChannel", "app.channel.get.find.app_error", nil, "", http.StatusInternalServerError))
+	mockPluginAPI.On("LogInfo", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockPluginAPI.On("GetDirectChannel", mock.AnythingOfType("string"), "bot").Return(
+		func(userID string, botID string) *model.Channel {
+			return &model.Channel{Id: "dm-" + userID}
+		}, nil)
+	mockPluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm-user1" && strings.Contains(post.Message, "~incident") && strings.Contains(post.Message, "report id: 1234")
+	})).Return(&model.Post{}, nil).Once()
+	mockPluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm-user2" && strings.Contains(post.Message, "`deleted`")
+	})).Return(&model.Post{}, nil).Once()
+
+	_, err := p.updateQueryMembership("sub3", "1", true)
+	require.NoError(t, err)
+
+	require.NoError(t, p.cleanupSubscriptions())
+
+	subs, err := p.GetSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subs, 2)
+	assert.Equal(t, "sub1", subs[0].ID)
+	assert.Equal(t, "sub4", subs[1].ID)
+	assert.NotContains(t, store.values, queryMembersKey("sub3"))
+	mockPluginAPI.AssertExpectations(t)
+}
+
+func Test_MessageHasBeenPosted(t *testing.T) {
+	t.Run("Regular posts are ignored", func(t *testing.T) {
+		p := &Plugin{}
+		mockPluginAPI := &plugintest.API{}
+		p.SetAPI(mockPluginAPI)
+		p.MessageHasBeenPosted(nil, &model.Post{ChannelId: "channel1", Message: "hello"})
+		mockPluginAPI.AssertNotCalled(t, "KVGet", mock.Anything)
+	})
+	t.Run("Archiving a channel removes its subscriptions", func(t *testing.T) {
+		p := &Plugin{BotUserID: "bot"}
+		mockPluginAPI := &plugintest.API{}
+		newMemoryKVStore(mockPluginAPI)
+		p.SetAPI(mockPluginAPI)
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{
+			{ID: "sub1", ChannelID: "channel1", CreatorID: "user1"},
+			{ID: "sub2", ChannelID: "channel2", CreatorID: "user1"},
+		}))
+
+		mockPluginAPI.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Name: "incident", DeleteAt: 1000}, nil)
+		mockPluginAPI.On("LogInfo", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		mockPluginAPI.On("GetDirectChannel", "user1", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		mockPluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.ChannelId == "dm" && strings.Contains(post.Message, "~incident")
+		})).Return(&model.Post{}, nil)
+
+		p.MessageHasBeenPosted(nil, &model.Post{ChannelId: "channel1", Type: model.PostTypeChannelDeleted})
+
+		subs, err := p.GetSubscriptions()
+		require.NoError(t, err)
+		require.Len(t, subs, 1)
+		assert.Equal(t, "sub2", subs[0].ID)
+	})
+}
+
+func Test_handleSubscriptionsList(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+	require.NoError(t, p.StoreSubscriptions([]*Subscription{
+		{ID: "sub1", ChannelID: "channel1", CreatorID: "user1"},
+		{ID: "sub2", ChannelID: "archived", ReportID: "1234", Paused: true},
+		{ID: "sub3", ChannelID: "missing", Paused: true, PausedUntil: model.GetMillisForTime(time.Date(2100, 1, 2, 15, 4, 0, 0, time.UTC))},
+	}))
+
+	mockPluginAPI.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Name: "security"}, nil)
+	mockPluginAPI.On("GetChannel", "archived").Return(&model.Channel{Id: "archived", Name: "incident", DeleteAt: 1000}, nil)
+	mockPluginAPI.On("GetChannel", "missing").Return(nil, model.NewAppError("GetChannel", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound))
+	mockPluginAPI.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "alice"}, nil)
+	var message string
+	mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		message = args.Get(1).(*model.Post).Message
+	}).Return(&model.Post{})
+
+	_, appErr := p.handleSubscriptionsList(&model.CommandArgs{UserId: "user1", ChannelId: "channel1"})
+	require.Nil(t, appErr)
+	assert.Contains(t, message, "| ~security | All Reports | Active | @alice | sub1 |")
+	assert.Contains(t, message, "| ~incident (archived) | Report ID =1234 | Paused | - | sub2 |")
+	assert.Contains(t, message, "| Unknown channel (`missing`) | All Reports | Paused until Sat Jan 02 2100 3:04 PM UTC | - | sub3 |")
+}
+
+func Test_Subscription_IsPaused(t *testing.T) {
+	now := model.GetMillis()
+	assert.False(t, (&Subscription{}).IsPaused(now))
+	assert.True(t, (&Subscription{Paused: true}).IsPaused(now))
+	assert.True(t, (&Subscription{Paused: true, PausedUntil: now + 1000}).IsPaused(now))
+	assert.False(t, (&Subscription{Paused: true, PausedUntil: now - 1000}).IsPaused(now))
+}
+
+func Test_checkSubscriptionConflict(t *testing.T) {
+	subs := []*Subscription{
+		{ID: "all", ChannelID: "channel1"},
+		{ID: "single1", ChannelID: "channel2", ReportID: "1"},
+		{ID: "single2", ChannelID: "channel2", ReportID: "2"},
+		{ID: "query", ChannelID: "channel4", Query: "state=new"},
+	}
+	tests := []struct {
+		name    string
+		sub     *Subscription
+		wantErr bool
+	}{
+		{name: "unchanged subscription", sub: &Subscription{ID: "all", ChannelID: "channel1"}},
+		{name: "other channel", sub: &Subscription{ID: "new", ChannelID: "channel3"}},
+		{name: "another report", sub: &Subscription{ID: "single1", ChannelID: "channel2", ReportID: "3"}},
+		{name: "same report", sub: &Subscription{ID: "single1", ChannelID: "channel2", ReportID: "2"}, wantErr: true},
+		{name: "channel subscribed to all reports", sub: &Subscription{ID: "new", ChannelID: "channel1", ReportID: "1"}, wantErr: true},
+		{name: "all reports with individual subscriptions", sub: &Subscription{ID: "single1", ChannelID: "channel2"}, wantErr: true},
+		{name: "query in a channel with individual subscriptions", sub: &Subscription{ID: "new", ChannelID: "channel2", Query: "state=triaged"}},
+		{name: "same query", sub: &Subscription{ID: "new", ChannelID: "channel4", Query: "state=new"}, wantErr: true},
+		{name: "other query", sub: &Subscription{ID: "new", ChannelID: "channel4", Query: "state=triaged"}},
+		{name: "query in a channel subscribed to all reports", sub: &Subscription{ID: "new", ChannelID: "channel1", Query: "state=new"}, wantErr: true},
+		{name: "all reports with a query subscription", sub: &Subscription{ID: "new", ChannelID: "channel4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSubscriptionConflict(subs, tt.sub)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_handleSubscriptionEditPauseResume(t *testing.T) {
+	setup := func() (*Plugin, *plugintest.API, *string) {
+		p := &Plugin{BotUserID: "bot"}
+		mockPluginAPI := &plugintest.API{}
+		newMemoryKVStore(mockPluginAPI)
+		p.SetAPI(mockPluginAPI)
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{
+			{ID: "sub1", ChannelID: "private", ReportID: "1234"},
+			{ID: "sub2", ChannelID: "public", SummaryOnly: true},
+		}))
+		mockPluginAPI.On("GetChannel", "private").Return(&model.Channel{Id: "private", Type: model.ChannelTypePrivate}, nil)
+		mockPluginAPI.On("GetChannel", "public").Return(&model.Channel{Id: "public", Type: model.ChannelTypeOpen}, nil)
+		message := ""
+		mockPluginAPI.On("SendEphemeralPost", "user1", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			message = args.Get(1).(*model.Post).Message
+		}).Return(&model.Post{})
+		p.setConfiguration(&configuration{HackeroneRestrictDetailsToPrivateChannels: true})
+		return p, mockPluginAPI, &message
+	}
+	args := &model.CommandArgs{UserId: "user1", ChannelId: "private"}
+
+	t.Run("Edit report and details", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--report", "all", "--summary-only", "true"})
+		assert.Contains(t, *message, "now notifies about all Hackerone reports without report details")
+		subs, _ := p.GetSubscriptions()
+		assert.Equal(t, "", subs[0].ReportID)
+		assert.True(t, subs[0].SummaryOnly)
+	})
+	t.Run("Details stay restricted in public channels", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"edit", "sub2", "--summary-only", "false"})
+		assert.Contains(t, *message, "must remain summary only")
+		subs, _ := p.GetSubscriptions()
+		assert.True(t, subs[1].SummaryOnly)
+	})
+	t.Run("Report and query are exclusive", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--report", "all", "--query", "state=triaged"})
+		assert.Contains(t, *message, "please specify only one of `--report` and `--query`")
+		subs, _ := p.GetSubscriptions()
+		assert.Equal(t, "1234", subs[0].ReportID)
+		assert.Empty(t, subs[0].Query)
+	})
+	t.Run("Switching from a query to a report forgets the reports of the query", func(t *testing.T) {
+		p, _, message := setup()
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{{ID: "query", ChannelID: "private", Query: "state=triaged"}}))
+		require.Nil(t, p.API.KVSet(queryMembersKey("query"), []byte(`["1"]`)))
+
+		p.executeSubscriptions(args, []string{"edit", "query", "--report", "all"})
+		assert.Contains(t, *message, "now notifies about all Hackerone reports")
+		members, appErr := p.API.KVGet(queryMembersKey("query"))
+		require.Nil(t, appErr)
+		assert.Nil(t, members)
+	})
+	t.Run("Adding a report with a query is rejected", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"add", "1234", "--query", "state=triaged"})
+		assert.Contains(t, *message, "please specify only one of the reportId and `--query`")
+		subs, _ := p.GetSubscriptions()
+		assert.Len(t, subs, 2)
+	})
+	t.Run("Edit SLA", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--sla", "critical:", "triage=4h;", "high:", "triage=1d"})
+		assert.Contains(t, *message, "with the SLA targets `critical: triage=4h; high: triage=1d`")
+		subs, _ := p.GetSubscriptions()
+		assert.Equal(t, "critical: triage=4h\nhigh: triage=1d", subs[0].SLAPolicies)
+
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--sla", "off"})
+		assert.Contains(t, *message, "without SLA notifications")
+		subs, _ = p.GetSubscriptions()
+		assert.True(t, subs[0].SLADisabled)
+		assert.Empty(t, subs[0].SLAPolicies)
+
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--sla", "critical:", "triage=soon"})
+		assert.Contains(t, *message, "Invalid SLA override")
+		p.executeSubscriptions(args, []string{"edit", "sub1", "--sla", "default"})
+		subs, _ = p.GetSubscriptions()
+		assert.False(t, subs[0].SLADisabled)
+		assert.Empty(t, subs[0].SLAPolicies)
+	})
+	t.Run("Unknown subscription", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"pause", "unknown"})
+		assert.Contains(t, *message, "could not find the subscription `unknown`")
+	})
+	t.Run("Pause until and resume", func(t *testing.T) {
+		p, _, message := setup()
+		p.executeSubscriptions(args, []string{"pause", "sub1", "--until", "4h"})
+		assert.Contains(t, *message, "paused until")
+		subs, _ := p.GetSubscriptions()
+		assert.True(t, subs[0].IsPaused(model.GetMillis()))
+		assert.False(t, subs[0].IsPaused(model.GetMillis()+5*time.Hour.Milliseconds()))
+
+		p.executeSubscriptions(args, []string{"resume", "sub1"})
+		subs, _ = p.GetSubscriptions()
+		assert.False(t, subs[0].Paused)
+		assert.Equal(t, int64(0), subs[0].PausedUntil)
+	})
+}
+
+func Test_matchActivity(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	store := newMemoryKVStore(mockPluginAPI)
+	p.SetAPI(mockPluginAPI)
+
+	triaged := &Report{Id: "1"}
+	triaged.Attributes.State = "triaged"
+	triaged.Relationships.Severity.Data.Attributes.Rating = "high"
+	resolved := &Report{Id: "1"}
+	resolved.Attributes.State = "resolved"
+	resolved.Relationships.Severity.Data.Attributes.Rating = "high"
+
+	t.Run("All reports and single report", func(t *testing.T) {
+		ok, _ := p.matchActivity(&Subscription{ID: "all"}, "1", nil)
+		assert.True(t, ok)
+		ok, _ = p.matchActivity(&Subscription{ID: "single", ReportID: "1"}, "1", nil)
+		assert.True(t, ok)
+		ok, _ = p.matchActivity(&Subscription{ID: "single", ReportID: "2"}, "1", nil)
+		assert.False(t, ok)
+	})
+	t.Run("Query follows the report while it matches", func(t *testing.T) {
+		sub := &Subscription{ID: "query", Query: "state=triaged severity>=high"}
+
+		ok, note := p.matchActivity(sub, "1", triaged)
+		assert.True(t, ok)
+		assert.Empty(t, note)
+		assert.Equal(t, `["1"]`, string(store.values[queryMembersKey("query")]))
+
+		ok, note = p.matchActivity(sub, "1", resolved)
+		assert.True(t, ok)
+		assert.Contains(t, note, "no longer matches")
+		assert.Equal(t, `[]`, string(store.values[queryMembersKey("query")]))
+
+		ok, _ = p.matchActivity(sub, "1", resolved)
+		assert.False(t, ok)
+	})
+	t.Run("Query without the current report", func(t *testing.T) {
+		ok, _ := p.matchActivity(&Subscription{ID: "query", Query: "state=triaged"}, "1", nil)
+		assert.False(t, ok)
+	})
+	t.Run("Unsubscribe forgets the members", func(t *testing.T) {
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{{ID: "query2", ChannelID: "channel1", Query: "state=triaged"}}))
+		_, err := p.updateQueryMembership("query2", "1", true)
+		require.NoError(t, err)
+		require.NoError(t, p.Unsubscribe("query2"))
+		assert.NotContains(t, store.values, queryMembersKey("query2"))
+	})
+	t.Run("Subscriptions replaced by all reports forget the members", func(t *testing.T) {
+		require.NoError(t, p.StoreSubscriptions([]*Subscription{{ID: "query3", ChannelID: "channel1", Query: "state=triaged"}}))
+		_, err := p.updateQueryMembership("query3", "1", true)
+		require.NoError(t, err)
+		require.NoError(t, p.AddSubscription(&Subscription{ID: "all", ChannelID: "channel1"}))
+		assert.NotContains(t, store.values, queryMembersKey("query3"))
+	})
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// stubHackeroneReports answers the report requests of the plugin with the given reports, by ID,
+// including the requests of several reports by the id filter.
+func stubHackeroneReports(p *Plugin, reports map[string]string) {
+	p.httpClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if ids := r.URL.Query()["filter[id][]"]; len(ids) > 0 {
+			list := []string{}
+			for _, id := range ids {
+				if report, ok := reports[id]; ok {
+					list = append(list, strings.TrimSuffix(strings.TrimPrefix(report, `{"data":`), "}"))
+				}
+			}
+			body := `{"data":[` + strings.Join(list, ",") + `]}`
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+		}
+		body, ok := reports[strings.TrimPrefix(r.URL.Path, "/v1/reports/")]
+		if !ok {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+}
+
+func Test_validateReportID(t *testing.T) {
+	p := &Plugin{}
+	mockPluginAPI := &plugintest.API{}
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"), mock.Anything, mock.Anything)
+	mockPluginAPI.On("LogWarn", mock.AnythingOfType("string"))
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{HackeroneProgramHandle: "security"})
+	stubHackeroneReports(p, map[string]string{
+		"1234": `{"data":{"id":"1234","attributes":{"title":"XSS in search"},"relationships":{"program":{"data":{"attributes":{"handle":"security"}}}}}}`,
+		"5678": `{"data":{"id":"5678","attributes":{"title":"Other program"},"relationships":{"program":{"data":{"attributes":{"handle":"other"}}}}}}`,
+	})
+
+	report, err := p.validateReportID("1234")
+	require.NoError(t, err)
+	assert.Equal(t, "XSS in search", report.Attributes.Title)
+
+	_, err = p.validateReportID("1243")
+	assert.EqualError(t, err, "Could not find the report ID 1243 in the Hackerone program security")
+
+	_, err = p.validateReportID("5678")
+	assert.EqualError(t, err, "Could not find the report ID 5678 in the Hackerone program security")
+
+	_, err = p.validateReportID("12a4")
+	assert.Contains(t, err.Error(), "Invalid report ID")
+}
+
+func Test_closedReportExpired(t *testing.T) {
+	now := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		closedAt string
+		want     bool
+	}{
+		{name: "open report", closedAt: "", want: false},
+		{name: "closed recently", closedAt: "2021-10-05T12:00:01.000Z", want: false},
+		{name: "closed for the configured days", closedAt: "2021-10-04T12:00:00.000Z", want: true},
+		{name: "closed long ago", closedAt: "2021-01-01T00:00:00.000Z", want: true},
+		{name: "invalid date", closedAt: "yesterday", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &Report{}
+			report.Attributes.ClosedAt = tt.closedAt
+			assert.Equal(t, tt.want, closedReportExpired(report, 7, now))
+		})
+	}
+}
+
+func Test_expireClosedReportSubscriptions(t *testing.T) {
+	p := &Plugin{BotUserID: "bot"}
+	mockPluginAPI := &plugintest.API{}
+	newMemoryKVStore(mockPluginAPI)
+	mockPluginAPI.On("LogDebug", mock.AnythingOfType("string"))
+	mockPluginAPI.On("LogInfo", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	p.SetAPI(mockPluginAPI)
+	p.setConfiguration(&configuration{HackeroneClosedReportSubscriptionDays: 7})
+	stubHackeroneReports(p, map[string]string{
+		"1": `{"data":{"id":"1","attributes":{"state":"resolved","closed_at":"2021-01-01T00:00:00.000Z"}}}`,
+		"2": `{"data":{"id":"2","attributes":{"state":"triaged"}}}`,
+	})
+	require.NoError(t, p.StoreSubscriptions([]*Subscription{
+		{ID: "sub1", ChannelID: "channel1", ReportID: "1"},
+		{ID: "sub2", ChannelID: "channel2", ReportID: "2"},
+		{ID: "sub3", ChannelID: "channel3"},
+	}))
+	mockPluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "channel1" && strings.Contains(post.Message, "report id: 1 was closed")
+	})).Return(&model.Post{}, nil).Once()
+
+	require.NoError(t, p.expireClosedReportSubscriptions())
+
+	subs, err := p.GetSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subs, 2)
+	assert.Equal(t, "sub2", subs[0].ID)
+	assert.Equal(t, "sub3", subs[1].ID)
+	mockPluginAPI.AssertExpectations(t)
+}
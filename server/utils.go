@@ -1,9 +1,13 @@
 package main
 
 import (
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
 )
 
 // Plugin utils
@@ -26,9 +30,13 @@ func (p *Plugin) sendPost(args *model.CommandArgs, message string, attachments [
 }
 
 func (p *Plugin) sendEphemeralPost(args *model.CommandArgs, message string, attachments []*model.SlackAttachment) *model.Post {
+	return p.sendEphemeralPostByChannelId(args.UserId, args.ChannelId, message, attachments)
+}
+
+func (p *Plugin) sendEphemeralPostByChannelId(userId string, channelId string, message string, attachments []*model.SlackAttachment) *model.Post {
 	post := &model.Post{
 		UserId:    p.BotUserID,
-		ChannelId: args.ChannelId,
+		ChannelId: channelId,
 		Message:   message,
 	}
 
@@ -37,7 +45,7 @@ func (p *Plugin) sendEphemeralPost(args *model.CommandArgs, message string, atta
 	}
 
 	return p.API.SendEphemeralPost(
-		args.UserId,
+		userId,
 		post,
 	)
 }
@@ -60,12 +68,30 @@ func (p *Plugin) sendPostByChannelId(channelId string, message string, attachmen
 	return post
 }
 
+// sendDirectMessage sends a message from the bot to the user in their direct message channel.
+func (p *Plugin) sendDirectMessage(userId string, message string) *model.Post {
+	channel, appErr := p.API.GetDirectChannel(userId, p.BotUserID)
+	if appErr != nil {
+		p.API.LogError("Unable to get direct channel", "user_id", userId, "appError", appErr)
+		return nil
+	}
+
+	return p.sendPostByChannelId(channel.Id, message, nil)
+}
+
 // Wrapper of p.sendEphemeralPost() to one-line the return statements in all executeCommand functions
 func (p *Plugin) sendEphemeralResponse(args *model.CommandArgs, message string) *model.CommandResponse {
 	p.sendEphemeralPost(args, message, nil)
 	return &model.CommandResponse{}
 }
 
+// sendEphemeralError sends the message of a command which was rejected or failed, and marks the
+// response so the audit log records the command as failed.
+func (p *Plugin) sendEphemeralError(args *model.CommandArgs, message string) *model.CommandResponse {
+	p.sendEphemeralPost(args, message, nil)
+	return &model.CommandResponse{Props: model.StringInterface{commandResultProp: AuditResultFailed}}
+}
+
 func contains(arr []string, str string) bool {
 	for _, a := range arr {
 		if a == str {
@@ -75,6 +101,153 @@ func contains(arr []string, str string) bool {
 	return false
 }
 
+// displayUsername returns @username for the user, or "-" when there is no user. Lookups are cached
+// in the given map while rendering a list.
+func (p *Plugin) displayUsername(userID string, cache map[string]string) string {
+	if userID == "" {
+		return "-"
+	}
+	if name, ok := cache[userID]; ok {
+		return name
+	}
+	name := userID
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		name = "@" + user.Username
+	}
+	cache[userID] = name
+	return name
+}
+
+// truncateText shortens text to at most limit characters, preferring to cut at a line break,
+// and reports whether anything was removed.
+func truncateText(text string, limit int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+
+	truncated := string(runes[:limit])
+	if i := strings.LastIndex(truncated, "\n"); i > limit/2 {
+		truncated = truncated[:i]
+	}
+	return strings.TrimRight(truncated, " \t\n") + "\n…", true
+}
+
+// parseCommandFlags splits command arguments into positional arguments and `--name value`
+// (or `--name=value`) flags. A flag without a value is set to "true". The boolFlags never take
+// the next argument as their value, so it stays positional; use parseBoolFlag to read them.
+func parseCommandFlags(split []string, boolFlags ...string) ([]string, map[string]string) {
+	isBool := map[string]bool{}
+	for _, name := range boolFlags {
+		isBool[name] = true
+	}
+	positional := []string{}
+	flags := map[string]string{}
+	for i := 0; i < len(split); i++ {
+		arg := split[i]
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name := strings.TrimPrefix(arg, "--")
+		if j := strings.Index(name, "="); j >= 0 {
+			flags[name[:j]] = name[j+1:]
+			continue
+		}
+
+		if !isBool[name] && i+1 < len(split) && !strings.HasPrefix(split[i+1], "--") {
+			flags[name] = split[i+1]
+			i++
+		} else {
+			flags[name] = "true"
+		}
+	}
+	return positional, flags
+}
+
+// parseBoolFlag reads a boolean flag parsed by parseCommandFlags. Only `--name`,
+// `--name=true` and `--name=false` are accepted.
+func parseBoolFlag(flags map[string]string, name string) (bool, error) {
+	value, ok := flags[name]
+	if !ok {
+		return false, nil
+	}
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, errors.Errorf("invalid value `%s` for `--%s`, it takes no value", value, name)
+	}
+}
+
+// splitTrailingFlag separates a `--name` flag taking the rest of the arguments as its value,
+// such as a query made of several terms, from the arguments before it.
+func splitTrailingFlag(split []string, name string) ([]string, string, bool) {
+	for i, arg := range split {
+		if arg == "--"+name {
+			return split[:i], strings.Join(split[i+1:], " "), true
+		}
+	}
+	return split, "", false
+}
+
+var durationPattern = regexp.MustCompile(`(\d+)([wdhm])`)
+
+// parseDuration parses durations such as 30m, 12h, 7d, 2w or 1d12h.
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	matches := durationPattern.FindAllStringSubmatch(value, -1)
+	if len(matches) == 0 || strings.Join(durationPattern.FindAllString(value, -1), "") != value {
+		return 0, errors.Errorf("invalid duration `%s`", value)
+	}
+
+	units := map[string]time.Duration{
+		"w": 7 * 24 * time.Hour,
+		"d": 24 * time.Hour,
+		"h": time.Hour,
+		"m": time.Minute,
+	}
+	var duration time.Duration
+	for _, m := range matches {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, errors.Errorf("invalid duration `%s`", value)
+		}
+		duration += time.Duration(n) * units[m[2]]
+	}
+	if duration <= 0 {
+		return 0, errors.Errorf("duration `%s` must be greater than zero", value)
+	}
+	return duration, nil
+}
+
+// parseUntil parses the end of a period given either as a duration from now, eg: 4h or 2d, or as
+// a date (2006-01-02) or date and time (2006-01-02T15:04) in UTC.
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if duration, err := parseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if !t.After(now) {
+				return time.Time{}, errors.Errorf("`%s` is in the past", value)
+			}
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid time `%s`. Use a duration such as `4h` or `2d`, or a date such as `2006-01-02` or `2006-01-02T15:04`", value)
+}
+
+// formatMillis formats a timestamp in milliseconds the same way as parseTime.
+func formatMillis(millis int64) string {
+	return parseTime(model.GetTimeForMillis(millis).UTC().Format(time.RFC3339))
+}
+
 func parseTime(input string) string {
 	if len(input) > 5 {
 		layout := "Mon Jan 02 2006 3:04 PM"
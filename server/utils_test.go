@@ -2,6 +2,9 @@ package main
 
 import (
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_contains(t *testing.T) {
@@ -74,3 +77,137 @@ func Test_parseTime(t *testing.T) {
 		})
 	}
 }
+
+func Test_truncateText(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		limit         int
+		want          string
+		wantTruncated bool
+	}{
+		{
+			name:          "short text",
+			text:          "short",
+			limit:         10,
+			want:          "short",
+			wantTruncated: false,
+		},
+		{
+			name:          "cut at line break",
+			text:          "first line\nsecond line\nthird line",
+			limit:         25,
+			want:          "first line\nsecond line\n…",
+			wantTruncated: true,
+		},
+		{
+			name:          "cut inside a long line",
+			text:          "abcdefghijklmnopqrstuvwxyz",
+			limit:         10,
+			want:          "abcdefghij\n…",
+			wantTruncated: true,
+		},
+		{
+			name:          "multi-byte characters",
+			text:          "ééééé",
+			limit:         3,
+			want:          "ééé\n…",
+			wantTruncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateText(tt.text, tt.limit)
+			if got != tt.want || truncated != tt.wantTruncated {
+				t.Errorf("truncateText() = %q, %v, want %q, %v", got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func Test_parseCommandFlags(t *testing.T) {
+	positional, flags := parseCommandFlags([]string{"@user1", "triager", "--for", "7d", "--dry-run", "--since=24h"})
+	assert.Equal(t, []string{"@user1", "triager"}, positional)
+	assert.Equal(t, map[string]string{"for": "7d", "dry-run": "true", "since": "24h"}, flags)
+
+	positional, flags = parseCommandFlags([]string{"--verbose", "--for", "1d", "id"})
+	assert.Equal(t, []string{"id"}, positional)
+	assert.Equal(t, map[string]string{"verbose": "true", "for": "1d"}, flags)
+
+	positional, flags = parseCommandFlags([]string{"--dry-run", "file1", "--replace=false"}, "dry-run", "replace")
+	assert.Equal(t, []string{"file1"}, positional)
+	assert.Equal(t, map[string]string{"dry-run": "true", "replace": "false"}, flags)
+}
+
+func Test_parseBoolFlag(t *testing.T) {
+	flags := map[string]string{"dry-run": "true", "replace": "false", "allow-public": "yes"}
+
+	value, err := parseBoolFlag(flags, "dry-run")
+	assert.NoError(t, err)
+	assert.True(t, value)
+	value, err = parseBoolFlag(flags, "replace")
+	assert.NoError(t, err)
+	assert.False(t, value)
+	value, err = parseBoolFlag(flags, "missing")
+	assert.NoError(t, err)
+	assert.False(t, value)
+	_, err = parseBoolFlag(flags, "allow-public")
+	assert.EqualError(t, err, "invalid value `yes` for `--allow-public`, it takes no value")
+}
+
+func Test_parseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "30m", want: 30 * time.Minute},
+		{input: "12h", want: 12 * time.Hour},
+		{input: "7d", want: 7 * 24 * time.Hour},
+		{input: "2w", want: 14 * 24 * time.Hour},
+		{input: "1d12h", want: 36 * time.Hour},
+		{input: "7D", want: 7 * 24 * time.Hour},
+		{input: "0d", wantErr: true},
+		{input: "7", wantErr: true},
+		{input: "7days", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseDuration(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseUntil(t *testing.T) {
+	now := time.Date(2021, 9, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: "4h", want: now.Add(4 * time.Hour)},
+		{input: "2021-09-05", want: time.Date(2021, 9, 5, 0, 0, 0, 0, time.UTC)},
+		{input: "2021-09-02T18:30", want: time.Date(2021, 9, 2, 18, 30, 0, 0, time.UTC)},
+		{input: "2021-09-02T18:30:00+02:00", want: time.Date(2021, 9, 2, 16, 30, 0, 0, time.UTC)},
+		{input: "2021-09-01", wantErr: true},
+		{input: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseUntil(tt.input, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), got.String())
+		})
+	}
+}